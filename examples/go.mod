@@ -6,8 +6,11 @@ replace github.com/hedzr/cmdr-addons/v2 => ../
 
 replace github.com/hedzr/cmdr-addons/service/v2 => ../service
 
+replace github.com/hedzr/cmdr-addons/service/v2/server => ../service/server
+
 require (
 	github.com/hedzr/cmdr-addons/service/v2 v2.2.0
+	github.com/hedzr/cmdr-addons/service/v2/server v0.0.0-00010101000000-000000000000
 	github.com/hedzr/cmdr-addons/v2 v2.2.3
 	github.com/hedzr/cmdr-loaders v1.4.3
 	github.com/hedzr/cmdr/v2 v2.2.3
@@ -0,0 +1,280 @@
+package service
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/user"
+	"path"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"gopkg.in/hedzr/errors.v3"
+
+	"github.com/hedzr/cmdr-addons/v2/tool/dbglog"
+)
+
+// AuditEntry is a line of the audit log, it records a control
+// operation, see Config.AuditLog.
+type AuditEntry struct {
+	Time     time.Time  `json:"time"`
+	Service  string     `json:"service"`
+	Backend  string     `json:"backend,omitempty"`
+	Command  string     `json:"command"`
+	User     string     `json:"user"`                // the invoking user
+	UID      string     `json:"uid"`                 // the real uid
+	EUID     int        `json:"euid"`                // the effective uid
+	SudoUser string     `json:"sudo_user,omitempty"` // $SUDO_USER, $DOAS_USER or $PKEXEC_UID
+	TTY      string     `json:"tty,omitempty"`
+	Pid      int        `json:"pid"`
+	Exec     [][]string `json:"exec,omitempty"` // the privileged commands run by the elevator
+	Duration float64    `json:"duration"`       // in seconds
+	OK       bool       `json:"ok"`
+	Error    string     `json:"error,omitempty"`
+}
+
+func (ae AuditEntry) String() string {
+	who := ae.User
+	if ae.SudoUser != "" && ae.SudoUser != ae.User {
+		who = ae.SudoUser + " as " + ae.User
+	}
+	if ae.TTY != "" {
+		who += " on " + ae.TTY
+	}
+	result := "ok"
+	if !ae.OK {
+		result = "failed: " + ae.Error
+	}
+	return fmt.Sprintf("%s  %-10s by %s, %d command(s) in %.3fs, %s",
+		ae.Time.Local().Format("2006-01-02 15:04:05"), ae.Command, who, len(ae.Exec),
+		ae.Duration, result)
+}
+
+// auditSyslog sends the audit log to the AUTH facility of syslog.
+const auditSyslog = "syslog"
+
+// AuditLogPath returns the audit log file: Config.AuditLog, or else
+// <LogDir>/<name>-audit.jsonl. It is "" if the audit log is disabled
+// or sent to syslog.
+//
+// The audit log never goes to the temp directory, which anyone can
+// write: /var/log is used instead of it, and a missing /var/log fails
+// the writing.
+func (e *Config) AuditLogPath() string {
+	switch e.AuditLog {
+	case "-", auditSyslog:
+		return ""
+	case "":
+		logDir := e.LogDir
+		if logDir == "" || path.Clean(logDir) == path.Clean(os.TempDir()) {
+			logDir = "/var/log"
+		}
+		return path.Join(logDir, e.ServiceBareName()+"-audit.jsonl")
+	}
+	return e.AuditLog
+}
+
+// execTrail collects the commands run by elevate during the audited
+// operations.
+var execTrail struct {
+	sync.Mutex
+	cmds *[][]string
+}
+
+func recordExec(cmd string, args []string) {
+	execTrail.Lock()
+	defer execTrail.Unlock()
+	if execTrail.cmds != nil {
+		*execTrail.cmds = append(*execTrail.cmds, append([]string{cmd}, args...))
+	}
+}
+
+// audit starts recording the operation cmd, and returns finish to
+// write its entry. A nested operation, such as the Install inside
+// Restore, shares the trail of the outer one.
+//
+// finish returns the failure of writing the entry of a privileged
+// operation, the one of a read-only operation is only logged.
+func (s *mgmtS) audit(ctx context.Context, config *Config, cmd Command) (finish func(err error) error) {
+	if config.AuditLog == "-" {
+		return func(error) error { return nil }
+	}
+
+	execTrail.Lock()
+	own := execTrail.cmds == nil
+	if own {
+		execTrail.cmds = &[][]string{}
+	}
+	trail := execTrail.cmds
+	execTrail.Unlock()
+
+	begin := time.Now()
+	return func(err error) (ea error) {
+		execTrail.Lock()
+		cmds := append([][]string(nil), *trail...)
+		if own {
+			execTrail.cmds = nil
+		}
+		execTrail.Unlock()
+
+		ae := newAuditEntry(config, cmd, s.backend)
+		ae.Time, ae.Exec = begin.UTC(), cmds
+		ae.Duration = time.Since(begin).Seconds()
+		ae.OK = err == nil
+		if err != nil {
+			ae.Error = err.Error()
+		}
+		// the read-only commands don't ask for the password just for
+		// their audit entries.
+		privileged := privilegedCommands[cmd] || len(cmds) > 0
+		if ea = writeAudit(config, ae, privileged); ea != nil {
+			dbglog.WarnContext(ctx, "[audit] cannot write the audit log", "cmd", cmd, "err", ea)
+			if privileged {
+				ea = errors.New("cannot write the audit log %q", config.AuditLogPath()).WithErrors(ea)
+			} else {
+				ea = nil
+			}
+		}
+		return
+	}
+}
+
+func newAuditEntry(config *Config, cmd Command, backend string) (ae AuditEntry) {
+	ae = AuditEntry{
+		Service: config.ServiceName(),
+		Backend: backend,
+		Command: cmd.String(),
+		UID:     strconv.Itoa(os.Getuid()),
+		EUID:    os.Geteuid(),
+		TTY:     ttyName(),
+		Pid:     os.Getpid(),
+	}
+	if u, err := user.Current(); err == nil {
+		ae.User, ae.UID = u.Username, u.Uid
+	}
+	for _, k := range []string{"SUDO_USER", "DOAS_USER", "PKEXEC_UID"} {
+		if v := os.Getenv(k); v != "" {
+			ae.SudoUser = v
+			break
+		}
+	}
+	return
+}
+
+// ttyName returns the terminal of stdin, or "".
+func ttyName() string {
+	if !isTerminal(os.Stdin) {
+		return os.Getenv("SSH_TTY")
+	}
+	if p, err := os.Readlink("/proc/self/fd/0"); err == nil && strings.HasPrefix(p, "/dev/") {
+		return p
+	}
+	if p := os.Getenv("SSH_TTY"); p != "" {
+		return p
+	}
+	return "tty"
+}
+
+// writeAudit appends ae to the audit log. The log file is opened in
+// append mode, the elevator appends it if we have no permission and
+// canElevate.
+func writeAudit(config *Config, ae AuditEntry, canElevate bool) (err error) {
+	var line []byte
+	if line, err = json.Marshal(ae); err != nil {
+		return
+	}
+	if config.AuditLog == auditSyslog {
+		return writeAuditSyslog(config.ServiceBareName(), string(line))
+	}
+
+	file := config.AuditLogPath()
+	var f *os.File
+	if f, err = os.OpenFile(file, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o640); err != nil {
+		if !os.IsPermission(err) || !canElevate || runtime.GOOS == "windows" {
+			return
+		}
+		return elevateAppend(file, string(line))
+	}
+	defer f.Close()
+	_, err = f.Write(append(line, '\n'))
+	return
+}
+
+// elevateAppend appends line to file as root, not recorded in the
+// trail.
+func elevateAppend(file, line string) (err error) {
+	retCode, msg, err := CurrentElevator().Run("sh", "-c", `umask 027; printf '%s\n' "$1" >> "$2"`, "audit", line, file)
+	if err == nil && retCode != 0 {
+		err = errors.New("cannot append to %q (exit code %d): %s", file, retCode, strings.TrimSpace(msg))
+	}
+	return
+}
+
+// ReadAuditLog returns the recent operations of the service in the
+// audit log, the newest first. limit <= 0 returns all of them.
+func ReadAuditLog(config *Config, limit int) (entries []AuditEntry, err error) {
+	if config.AuditLog == auditSyslog {
+		return nil, errors.New("the audit log of %q is sent to syslog, query it by journalctl or in /var/log/auth.log", config.ServiceName())
+	}
+	file := config.AuditLogPath()
+	if file == "" {
+		return nil, errors.New("the audit log of %q is disabled", config.ServiceName())
+	}
+
+	var f *os.File
+	if f, err = os.Open(file); err != nil {
+		if os.IsNotExist(err) {
+			err = nil
+		}
+		return
+	}
+	defer f.Close()
+
+	name := config.ServiceName()
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 4*1024*1024)
+	for scanner.Scan() {
+		var ae AuditEntry
+		if e := json.Unmarshal(scanner.Bytes(), &ae); e != nil || ae.Service != name {
+			continue // a damaged line, or another service sharing the file
+		}
+		entries = append(entries, ae)
+		if limit > 0 && len(entries) > limit {
+			entries = entries[1:]
+		}
+	}
+	if err = scanner.Err(); err != nil {
+		return
+	}
+	for i, j := 0, len(entries)-1; i < j; i, j = i+1, j-1 {
+		entries[i], entries[j] = entries[j], entries[i]
+	}
+	return
+}
+
+// auditLog implements the AuditLog command, the number of entries is
+// taken from Config.PositionalArgs, default 20.
+func (s *mgmtS) auditLog(ctx context.Context, config *Config) (err error) {
+	limit := 20
+	if len(config.PositionalArgs) > 0 {
+		if limit, err = strconv.Atoi(strings.TrimSpace(config.PositionalArgs[0])); err != nil {
+			return errors.New("expect the number of entries, got %q", config.PositionalArgs[0])
+		}
+	}
+	var entries []AuditEntry
+	if entries, err = ReadAuditLog(config, limit); err != nil {
+		return
+	}
+	if len(entries) == 0 {
+		println("no operations in", config.AuditLogPath())
+	}
+	for _, ae := range entries {
+		println(ae.String())
+	}
+	return
+}
@@ -0,0 +1,18 @@
+//go:build !windows && !plan9 && !js
+// +build !windows,!plan9,!js
+
+package service
+
+import (
+	"log/syslog"
+)
+
+// writeAuditSyslog sends line to the AUTH facility of syslog.
+func writeAuditSyslog(tag, line string) (err error) {
+	var w *syslog.Writer
+	if w, err = syslog.New(syslog.LOG_AUTH|syslog.LOG_NOTICE, tag+"-audit"); err != nil {
+		return
+	}
+	defer w.Close()
+	return w.Notice(line)
+}
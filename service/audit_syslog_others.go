@@ -0,0 +1,15 @@
+//go:build windows || plan9 || js
+// +build windows plan9 js
+
+package service
+
+import (
+	"runtime"
+
+	"gopkg.in/hedzr/errors.v3"
+)
+
+// writeAuditSyslog fails, there is no syslog here.
+func writeAuditSyslog(tag, line string) (err error) {
+	return errors.New("syslog is not available on %s, specify a file as the audit log", runtime.GOOS)
+}
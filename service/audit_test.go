@@ -0,0 +1,78 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path"
+	"testing"
+)
+
+func TestAudit(t *testing.T) {
+	ctx := context.Background()
+	SetElevator(rootElevator{})
+	defer SetElevator(nil)
+
+	file := path.Join(t.TempDir(), "audit.jsonl")
+	config := &Config{Name: "demo", AuditLog: file}
+	other := &Config{Name: "other", AuditLog: file}
+	m := &mgmtS{backend: "systemd"}
+
+	finish := m.audit(ctx, config, Install)
+	if _, _, err := elevate("true"); err != nil {
+		t.Fatal(err)
+	}
+	inner := m.audit(ctx, config, Start) // nested, shares the trail
+	_, _, _ = elevate("echo", "started")
+	inner(nil)
+	finish(nil)
+
+	m.audit(ctx, other, Stop)(nil)
+	_, _, _ = elevate("echo", "not recorded")
+	m.audit(ctx, config, Uninstall)(errors.New("busy"))
+
+	entries, err := ReadAuditLog(config, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 3 {
+		t.Fatalf("expect 3 entries, got %v", entries)
+	}
+	if e := entries[0]; e.Command != "Uninstall" || e.OK || e.Error != "busy" || len(e.Exec) != 0 {
+		t.Fatalf("bad newest entry: %+v", e)
+	}
+	if e := entries[2]; e.Command != "Start" || !e.OK || len(e.Exec) != 2 {
+		t.Fatalf("bad nested entry: %+v", e)
+	}
+	if e := entries[1]; e.Command != "Install" || e.Backend != "systemd" || e.Service != config.ServiceName() ||
+		len(e.Exec) != 2 || e.Exec[1][0] != "echo" || e.Exec[1][1] != "started" || e.User == "" {
+		t.Fatalf("bad entry: %+v", e)
+	}
+
+	if entries, _ = ReadAuditLog(config, 1); len(entries) != 1 || entries[0].Command != "Uninstall" {
+		t.Fatalf("limit: %v", entries)
+	}
+	if entries, _ = ReadAuditLog(other, 0); len(entries) != 1 || entries[0].Command != "Stop" {
+		t.Fatalf("other service: %v", entries)
+	}
+	if _, err = ReadAuditLog(&Config{Name: "demo", AuditLog: "-"}, 0); err == nil {
+		t.Fatal("expect an error for the disabled audit log")
+	}
+
+	// a privileged operation fails if its entry cannot be written
+	broken := &Config{Name: "demo", AuditLog: path.Join(t.TempDir(), "missing", "audit.jsonl")}
+	if err = m.audit(ctx, broken, Install)(nil); err == nil {
+		t.Fatal("expect the failure of writing the audit log reported")
+	}
+	if err = m.audit(ctx, broken, Status)(nil); err != nil {
+		t.Fatalf("expect a read-only operation not failed by the audit log, got %v", err)
+	}
+}
+
+func TestAuditLogPath(t *testing.T) {
+	for _, logDir := range []string{"", os.TempDir()} {
+		if got := (&Config{Name: "demo", LogDir: logDir}).AuditLogPath(); got != "/var/log/demo-audit.jsonl" {
+			t.Fatalf("LogDir %q: expect the audit log in /var/log, got %q", logDir, got)
+		}
+	}
+}
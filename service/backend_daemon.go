@@ -0,0 +1,573 @@
+//go:build !windows && !plan9 && !js
+// +build !windows,!plan9,!js
+
+package service
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"path"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/hedzr/is/dir"
+	"gopkg.in/hedzr/errors.v3"
+
+	"github.com/hedzr/cmdr-addons/v2/tool/dbglog"
+)
+
+// daemonD is a userspace backend for the hosts without a usable init
+// system, such as minimal containers, Termux, or running without
+// root.
+//
+// It daemonizes the service by re-executing itself in a new session,
+// tracks it with the pidfile, and controls it with signals. An
+// optional @reboot crontab entry acts as "enable".
+type daemonD struct {
+	Logger ZLogger
+}
+
+func (s *daemonD) String() string { return "daemon" }
+
+func (s *daemonD) Choose(ctx context.Context) (ok bool) { return true }
+
+func (s *daemonD) IsValid(ctx context.Context) (valid bool) { return true }
+
+// prepareConfig moves the pidfile and the log files into the user's
+// directories if the system ones are not writable, so that no root
+// is required.
+func (s *daemonD) prepareConfig(ctx context.Context, config *Config) {
+	if config.PIDFile == "" && !dirCreatable(path.Dir(config.PidfilePath())) {
+		config.PIDFile = path.Join(userRunDir(), config.BaseName()+".pid")
+		dbglog.DebugContext(ctx, "[daemonD] use user-level pidfile", "pidfile", config.PIDFile)
+	}
+	for _, p := range []*string{&config.StandardOutPath, &config.StandardErrorPath} {
+		if *p != "" && *p != os.DevNull && !dirWritable(path.Dir(*p)) {
+			*p = path.Join(userStateDir(config.BaseName()), path.Base(*p))
+		}
+	}
+}
+
+func (s *daemonD) Control(ctx context.Context, config *Config, m *mgmtS, cmd Command) (err error) {
+	if fn, ok := daemonCommands[cmd]; ok {
+		if s.Logger == nil {
+			s.Logger = dbglog.ZLogger()
+		}
+		return fn(ctx, config, m, s)
+	}
+	err = errors.New("unknown command %v (valid commands are in [%v, %v])", cmd, MinCommand+1, MaxCommand-1)
+	return
+}
+
+var daemonCommands = map[Command]func(ctx context.Context, config *Config, m *mgmtS, s *daemonD) (err error){
+	Info:      daemonInfo,
+	Port:      daemonPort,
+	Addr:      daemonAddr,
+	Start:     daemonStart,
+	Stop:      daemonStop,
+	Status:    daemonStatus,
+	Restart:   daemonRestart,
+	HotReload: daemonHotReload,
+	Install:   daemonInstall,
+	Uninstall: daemonUninstall,
+	Enable:    daemonEnable,
+	Disable:   daemonDisable,
+	ViewLog:   daemonViewLog,
+}
+
+func daemonInfo(ctx context.Context, config *Config, m *mgmtS, s *daemonD) (err error) {
+	if fn, ok := config.Entity.(EntityInfoAware); ok {
+		println(fn.Info(ctx, config, s.Logger))
+	}
+	return
+}
+
+func daemonPort(ctx context.Context, config *Config, m *mgmtS, s *daemonD) (err error) {
+	if fn, ok := config.Entity.(EntityPortAware); ok {
+		println(fn.Port(ctx, config, s.Logger))
+	}
+	return
+}
+
+func daemonAddr(ctx context.Context, config *Config, m *mgmtS, s *daemonD) (err error) {
+	if fn, ok := config.Entity.(EntityAddrAware); ok {
+		println(fn.Addr(ctx, config, s.Logger))
+	}
+	return
+}
+
+// daemonMainPid returns the pid of the running daemon, or 0.
+func daemonMainPid(config *Config) (pid int) {
+	var alive bool
+	if pid, alive, _ = PidfileAlive(config.PidfilePath()); !alive || pid == os.Getpid() {
+		pid = 0
+	}
+	return
+}
+
+func daemonStart(ctx context.Context, config *Config, m *mgmtS, s *daemonD) (err error) {
+	dbglog.DebugContext(ctx, "start")
+
+	_ = s.Logger.Infof("command-line is %q\n", config.CmdLines)
+	_ = s.Logger.Infof("fore: %v, sMode: %v\n", m.fore, m.serviceMode)
+
+	// we are the daemonized child, or running in foreground manually
+	if m.fore {
+		var run func(ctx context.Context) error
+		if fn, ok := config.Entity.(EntityStartAware); ok {
+			dbglog.DebugContext(ctx, "start EntityStartAware")
+			run = func(ctx context.Context) error { return fn.Start(ctx, config, s.Logger) }
+		} else if prog, ok := config.Entity.(RunnableService); ok {
+			prog.SetServiceMode(m.serviceMode)
+			_ = s.Logger.Infof("run program...\n")
+			run = func(ctx context.Context) error { return prog.Run(ctx, config, s.Logger) }
+		}
+		if m.serviceMode {
+			err = m.serveEntity(ctx, config, s.Logger, run)
+		} else if run != nil {
+			err = m.supervise(ctx, config, s.Logger, run)
+		}
+		return
+	}
+
+	if pid := daemonMainPid(config); pid > 0 {
+		err = ErrServiceIsRunning
+		_ = s.Logger.Errorf("service ran already (pid %d), err: %v", pid, err)
+		return
+	}
+
+	var pid int
+	if pid, err = daemonize(ctx, config); err != nil {
+		return
+	}
+
+	println("service started, pid:", pid)
+	_ = s.Logger.Infof("daemon %s started: pid=%v\n", config.ServiceName(), pid)
+	return
+}
+
+// daemonize re-executes the service executable in a new session with
+// its stdio redirected, and records the child into the pidfile.
+func daemonize(ctx context.Context, config *Config) (pid int, err error) {
+	var stdout, stderr *os.File
+	if stdout, err = openLogFile(config.StandardOutPath); err != nil {
+		return
+	}
+	defer stdout.Close()
+	if stderr, err = openLogFile(config.StandardErrorPath); err != nil {
+		return
+	}
+	defer stderr.Close()
+
+	var stdin *os.File
+	if stdin, err = os.Open(os.DevNull); err != nil {
+		return
+	}
+	defer stdin.Close()
+
+	cmd := exec.Command(config.ExecutablePath(), config.startArgs()...)
+	cmd.Dir = config.WorkDir
+	cmd.Env = os.Environ()
+	for k, v := range config.Env {
+		cmd.Env = append(cmd.Env, k+"="+v)
+	}
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = stdin, stdout, stderr
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setsid: true}
+
+	dbglog.InfoContext(ctx, "[daemonD] daemonizing", "exe", cmd.Path, "args", cmd.Args[1:], "workdir", cmd.Dir)
+	if err = cmd.Start(); err != nil {
+		return
+	}
+	pid = cmd.Process.Pid
+
+	file := config.PidfilePath()
+	if err = WritePidfile(file, pid); err != nil {
+		dbglog.WarnContext(ctx, "[daemonD] cannot write pidfile", "pidfile", file, "err", err)
+		err = nil
+	}
+
+	// reap the child if it failed at starting, or else release it.
+	exited := make(chan error, 1)
+	go func() { exited <- cmd.Wait() }()
+	select {
+	case e := <-exited:
+		_ = os.Remove(file)
+		err = errors.New("daemon exited at starting, see also %q", config.StandardErrorPath).WithErrors(e)
+	case <-time.After(daemonStartGrace):
+		_ = cmd.Process.Release()
+	case <-ctx.Done():
+		// cancelled at starting, don't leave the child behind.
+		_ = cmd.Process.Kill()
+		<-exited
+		_ = os.Remove(file)
+		pid, err = 0, ctx.Err()
+	}
+	return
+}
+
+const daemonStartGrace = 500 * time.Millisecond
+
+func daemonStop(ctx context.Context, config *Config, m *mgmtS, s *daemonD) (err error) {
+	if fn, ok := config.Entity.(EntityStopAware); ok && m.fore {
+		return fn.Stop(ctx, config, s.Logger)
+	}
+
+	pid := daemonMainPid(config)
+	if pid == 0 {
+		println("service not running")
+		return
+	}
+
+	res := StopProcess(ctx, config, pid, s.Logger)
+	println("stop:", res.String())
+	if !res.Stopped() {
+		err = errors.New("failed to stop service process %d: %s", pid, res.Outcome).WithErrors(res.Err)
+		return
+	}
+	_ = os.Remove(config.PidfilePath())
+	return
+}
+
+func daemonStatus(ctx context.Context, config *Config, m *mgmtS, s *daemonD) (err error) {
+	if fn, ok := config.Entity.(EntityStatusAware); ok {
+		return fn.Status(ctx, config, s.Logger)
+	}
+
+	pid := daemonMainPid(config)
+	if pid == 0 {
+		fmt.Printf("%s is not running\n", config.ServiceName())
+		return ErrServiceIsNotRunning
+	}
+
+	enabled := "disabled"
+	if ok, _ := crontabHasEntry(config); ok {
+		enabled = "enabled"
+	}
+	fmt.Printf("%s is running (pid %d, %s)\n  pidfile: %s\n  stdout:  %s\n  stderr:  %s\n",
+		config.ServiceName(), pid, enabled, config.PidfilePath(), config.StandardOutPath, config.StandardErrorPath)
+	printSupervisorStatus(config)
+	printHealthStatus(config)
+	return
+}
+
+func daemonRestart(ctx context.Context, config *Config, m *mgmtS, s *daemonD) (err error) {
+	if fn, ok := config.Entity.(EntityRestartAware); ok {
+		return fn.Restart(ctx, config, s.Logger)
+	}
+
+	if sig, ok := config.canUpgrade(); ok {
+		if pid := daemonMainPid(config); pid > 0 {
+			return upgradeService(ctx, config, pid, sig, func() int { return daemonMainPid(config) })
+		}
+	}
+
+	if err = daemonStop(ctx, config, m, s); err != nil {
+		return
+	}
+	_, err = daemonize(ctx, config)
+	return
+}
+
+func daemonHotReload(ctx context.Context, config *Config, m *mgmtS, s *daemonD) (err error) {
+	if fn, ok := config.Entity.(EntityHotReloadAware); ok && m.fore {
+		return fn.HotReload(ctx, config, s.Logger)
+	}
+
+	pid := daemonMainPid(config)
+	if pid == 0 {
+		return ErrServiceIsNotRunning
+	}
+	sig := config.reloadSignal()
+	if err = sendSignal(pid, sig); err == nil {
+		println(signalName(sig), "sent to", pid)
+	}
+	return
+}
+
+func daemonInstall(ctx context.Context, config *Config, m *mgmtS, s *daemonD) (err error) {
+	if fn, ok := config.Entity.(EntityInstallAware); ok {
+		return fn.Install(ctx, config, s.Logger)
+	}
+
+	// nothing to be installed except the directories
+	for _, d := range []string{path.Dir(config.PidfilePath()), path.Dir(config.StandardOutPath), path.Dir(config.StandardErrorPath)} {
+		if err = dir.EnsureDir(d); err != nil {
+			return
+		}
+	}
+
+	if config.AutoEnable {
+		err = daemonEnable(ctx, config, m, s)
+	}
+	if err == nil {
+		println("Service created successfully.")
+	}
+	return
+}
+
+func daemonUninstall(ctx context.Context, config *Config, m *mgmtS, s *daemonD) (err error) {
+	if fn, ok := config.Entity.(EntityUninstallAware); ok {
+		return fn.Uninstall(ctx, config, s.Logger)
+	}
+
+	// collected before disabling, to keep the enabled state
+	files, enabled := s.installedFiles(ctx, config)
+
+	if err = daemonStop(ctx, config, m, s); err != nil {
+		dbglog.WarnContext(ctx, "daemon stop command failed.", "err", err)
+	}
+	if err = daemonDisable(ctx, config, m, s); err != nil {
+		dbglog.WarnContext(ctx, "daemon disable command failed.", "err", err)
+	}
+
+	if len(files) > 0 || enabled {
+		// never remove anything not archived
+		var bm BackupManifest
+		if bm, err = archiveInstallation(ctx, config, "uninstall", files, enabled); err != nil {
+			err = errors.New("failed to back up the installation, nothing removed").WithErrors(err)
+			return
+		}
+		println("backup saved:", bm.Dir)
+	}
+	for _, file := range files {
+		if err = elevateE("rm", "-f", file); err != nil {
+			err = errors.New("failed to uninstall service").WithErrors(err)
+			return
+		}
+	}
+	println("service uninstalled")
+	return
+}
+
+// installedFiles returns the env file of the service if exists, the
+// installation is enabled by its @reboot crontab entry.
+func (s *daemonD) installedFiles(ctx context.Context, config *Config) (files []string, enabled bool) {
+	if file := config.EnvFilePath(); dir.FileExists(file) {
+		files = append(files, file)
+	}
+	enabled, _ = crontabHasEntry(config)
+	return
+}
+
+// afterRestore adds the crontab entry back if the service was enabled
+// in the backup.
+func (s *daemonD) afterRestore(ctx context.Context, config *Config, tx *installTx, bm BackupManifest) (err error) {
+	if found, _ := crontabHasEntry(config); bm.Enabled && !found {
+		if err = crontabEnable(config); err != nil {
+			return
+		}
+		tx.applied("enable", crontabTag(config), func() (err error) {
+			_, err = crontabDisable(config)
+			return
+		})
+	}
+	return
+}
+
+func daemonEnable(ctx context.Context, config *Config, m *mgmtS, s *daemonD) (err error) {
+	if fn, ok := config.Entity.(EntityEnableAware); ok {
+		return fn.Enable(ctx, config, s.Logger)
+	}
+
+	if err = crontabEnable(config); err != nil {
+		return
+	}
+
+	println("service has been enabled (@reboot crontab entry).")
+	return
+}
+
+func daemonDisable(ctx context.Context, config *Config, m *mgmtS, s *daemonD) (err error) {
+	if fn, ok := config.Entity.(EntityDisableAware); ok {
+		return fn.Disable(ctx, config, s.Logger)
+	}
+
+	var found bool
+	if found, err = crontabDisable(config); err != nil {
+		return
+	}
+	if !found {
+		println("service has not been enabled.")
+		return
+	}
+
+	println("service has been disabled.")
+	return
+}
+
+func daemonViewLog(ctx context.Context, config *Config, m *mgmtS, s *daemonD) (err error) {
+	if fn, ok := config.Entity.(EntityViewLogAware); ok {
+		return fn.ViewLog(ctx, config, s.Logger)
+	}
+
+	const maxLines = 100
+	for _, file := range []string{config.StandardOutPath, config.StandardErrorPath} {
+		var data []byte
+		if data, err = os.ReadFile(file); err != nil {
+			if os.IsNotExist(err) {
+				err = nil
+				continue
+			}
+			return
+		}
+		lines := strings.Split(strings.TrimRight(string(data), "\n"), "\n")
+		if len(lines) > maxLines {
+			lines = lines[len(lines)-maxLines:]
+		}
+		fmt.Printf("==> %s <==\n%s\n\n", file, strings.Join(lines, "\n"))
+	}
+	return
+}
+
+//
+
+//
+
+// crontabTag marks the crontab entry owned by this service.
+func crontabTag(config *Config) string { return "# cmdr-service:" + config.BaseName() }
+
+func crontabEntry(config *Config) string {
+	var sb strings.Builder
+	sb.WriteString("@reboot ")
+	if config.WorkDir != "" {
+		fmt.Fprintf(&sb, "cd %s && ", crontabQuote(config.WorkDir))
+	}
+	for k, v := range config.Env {
+		fmt.Fprintf(&sb, "%s=%s ", k, crontabQuote(v))
+	}
+	sb.WriteString(crontabQuote(config.ExecutablePath()))
+	for _, arg := range config.startArgs() {
+		sb.WriteString(" " + crontabQuote(arg))
+	}
+	fmt.Fprintf(&sb, " >>%s 2>>%s </dev/null %s", crontabQuote(config.StandardOutPath), crontabQuote(config.StandardErrorPath), crontabTag(config))
+	return sb.String()
+}
+
+// crontabQuote quotes s for sh in single quotes, and escapes '%'
+// which cron turns into a newline.
+func crontabQuote(s string) string {
+	s = strings.ReplaceAll(s, "'", `'\''`)
+	s = strings.ReplaceAll(s, "%", `\%`)
+	return "'" + s + "'"
+}
+
+// crontabEnable adds the entry of the service, or replaces it.
+func crontabEnable(config *Config) (err error) {
+	var lines []string
+	if lines, err = crontabLines(); err != nil {
+		return
+	}
+	tag := crontabTag(config)
+	var kept []string
+	for _, line := range lines {
+		if !strings.HasSuffix(line, tag) {
+			kept = append(kept, line)
+		}
+	}
+	return crontabSave(append(kept, crontabEntry(config)))
+}
+
+// crontabDisable removes the entry of the service if found.
+func crontabDisable(config *Config) (found bool, err error) {
+	var lines []string
+	if lines, err = crontabLines(); err != nil {
+		return
+	}
+	tag := crontabTag(config)
+	var kept []string
+	for _, line := range lines {
+		if strings.HasSuffix(line, tag) {
+			found = true
+			continue
+		}
+		kept = append(kept, line)
+	}
+	if found {
+		err = crontabSave(kept)
+	}
+	return
+}
+
+func crontabHasEntry(config *Config) (found bool, err error) {
+	var lines []string
+	if lines, err = crontabLines(); err == nil {
+		tag := crontabTag(config)
+		for _, line := range lines {
+			if strings.HasSuffix(line, tag) {
+				return true, nil
+			}
+		}
+	}
+	return
+}
+
+func crontabLines() (lines []string, err error) {
+	var out bytes.Buffer
+	cmd := exec.Command("crontab", "-l")
+	cmd.Stdout = &out
+	if err = cmd.Run(); err != nil {
+		var ee *exec.ExitError
+		if errors.As(err, &ee) {
+			// "no crontab for user"
+			return nil, nil
+		}
+		return
+	}
+	scan := bufio.NewScanner(&out)
+	for scan.Scan() {
+		lines = append(lines, scan.Text())
+	}
+	err = scan.Err()
+	return
+}
+
+func crontabSave(lines []string) (err error) {
+	cmd := exec.Command("crontab", "-")
+	cmd.Stdin = strings.NewReader(strings.Join(lines, "\n") + "\n")
+	var out []byte
+	if out, err = cmd.CombinedOutput(); err != nil {
+		err = errors.New("failed to update crontab. The console outputs are:\n%v", string(out)).WithErrors(err)
+	}
+	return
+}
+
+//
+
+//
+
+func openLogFile(file string) (f *os.File, err error) {
+	if file == "" {
+		file = os.DevNull
+	}
+	if file != os.DevNull {
+		if err = dir.EnsureDir(path.Dir(file)); err != nil {
+			return
+		}
+	}
+	return os.OpenFile(file, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+}
+
+// userRunDir returns $XDG_RUNTIME_DIR, or the temp directory.
+func userRunDir() string {
+	if d := os.Getenv("XDG_RUNTIME_DIR"); d != "" && dirWritable(d) {
+		return d
+	}
+	return os.TempDir()
+}
+
+// userStateDir returns $XDG_STATE_HOME/<name>, or
+// $HOME/.local/state/<name>.
+func userStateDir(name string) string {
+	if d := os.Getenv("XDG_STATE_HOME"); d != "" {
+		return path.Join(d, name)
+	}
+	if home, err := os.UserHomeDir(); err == nil {
+		return path.Join(home, ".local", "state", name)
+	}
+	return path.Join(os.TempDir(), name)
+}
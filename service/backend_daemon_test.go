@@ -0,0 +1,63 @@
+//go:build linux
+// +build linux
+
+package service
+
+import (
+	"context"
+	"path"
+	"strings"
+	"testing"
+)
+
+func TestDaemonize(t *testing.T) {
+	ctx := context.Background()
+	d := t.TempDir()
+	config := &Config{
+		Name:              "sleeper",
+		Executable:        "/bin/sleep",
+		ExecStartArgs:     "30",
+		WorkDir:           d,
+		PIDFile:           path.Join(d, "sleeper.pid"),
+		StandardOutPath:   path.Join(d, "log", "stdout.log"),
+		StandardErrorPath: path.Join(d, "log", "stderr.log"),
+		TimeoutStopSec:    "3s",
+	}
+
+	pid, err := daemonize(ctx, config)
+	if err != nil {
+		t.Skip(err)
+	}
+	if got := daemonMainPid(config); got != pid {
+		t.Fatalf("expect pidfile records %d, got %d", pid, got)
+	}
+
+	res := StopProcess(ctx, config, pid, nil)
+	if res.Outcome != StopExited {
+		t.Fatalf("expect exited, got %v", res)
+	}
+	if daemonMainPid(config) != 0 {
+		t.Fatalf("expect daemon not running")
+	}
+}
+
+func TestDaemonD_crontabEntry(t *testing.T) {
+	config := &Config{
+		Name:              "demo",
+		Executable:        "/usr/local/bin/demo",
+		StandardOutPath:   "/tmp/demo/stdout.log",
+		StandardErrorPath: "/tmp/demo/stderr.log",
+	}
+	line := crontabEntry(config)
+	if !strings.HasPrefix(line, "@reboot ") || !strings.HasSuffix(line, crontabTag(config)) {
+		t.Fatalf("bad crontab entry: %q", line)
+	}
+	if !strings.Contains(line, `'/usr/local/bin/demo' 'server' 'start' '-foreground' '-service'`) {
+		t.Fatalf("bad crontab command: %q", line)
+	}
+
+	config.Env = map[string]string{"GREETING": "it's 100%"}
+	if line = crontabEntry(config); !strings.Contains(line, `GREETING='it'\''s 100\%' `) {
+		t.Fatalf("bad crontab quoting: %q", line)
+	}
+}
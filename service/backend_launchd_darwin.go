@@ -7,15 +7,13 @@ import (
 	"os"
 	"os/user"
 	"runtime"
-	"sync"
-	"text/template"
 	"time"
 
-	"github.com/hedzr/is"
 	"github.com/hedzr/is/dir"
 	"github.com/hedzr/is/exec"
 	"gopkg.in/hedzr/errors.v3"
 
+	"github.com/hedzr/cmdr-addons/service/v2/render"
 	"github.com/hedzr/cmdr-addons/service/v2/systems"
 	"github.com/hedzr/cmdr-addons/v2/tool/dbglog"
 	logz "github.com/hedzr/logg/slog"
@@ -25,6 +23,8 @@ type launchD struct {
 	Logger ZLogger
 }
 
+func (s *launchD) String() string { return "launchd" }
+
 func (s *launchD) Close() {
 	if s.Logger != nil {
 		if c, ok := s.Logger.(interface{ Close() error }); ok {
@@ -180,12 +180,13 @@ func launchdStart(ctx context.Context, config *Config, m *mgmtS, s *launchD) (er
 
 	if m.fore {
 		// run service at foreground
+		var run func(ctx context.Context) error
 		if prog, ok := config.Entity.(RunnableService); ok {
 			prog.SetServiceMode(m.serviceMode)
 			_ = s.Logger.Infof("run program...\n")
-			err = prog.Run(ctx, config, s.Logger)
+			run = func(ctx context.Context) error { return prog.Run(ctx, config, s.Logger) }
 		}
-		return enterLoop(ctx, config, m, s)
+		return m.serveEntity(ctx, config, s.Logger, run)
 	} else if fn, ok := config.Entity.(EntityStartAware); ok {
 		// call into Entity.Start if exists
 		dbglog.DebugContext(ctx, "start EntityStartAware")
@@ -209,8 +210,8 @@ func launchdStart(ctx context.Context, config *Config, m *mgmtS, s *launchD) (er
 		sn := serviceName
 		_ = s.Logger.Infof("launchctl start %q with %q\n", sn, file)
 		dbglog.Infof("launchctl start %q with %q\n", sn, file)
-		// retCode, _, err = exec.Sudo("launchctl", "start", sn)
-		retCode, _, err = exec.Sudo("launchctl", "load", file)
+		// retCode, _, err = elevate("launchctl", "start", sn)
+		retCode, _, err = elevate("launchctl", "load", file)
 		if err != nil || retCode != 0 {
 			dbglog.DebugContext(ctx, "`sudo launchctl start service` failed", "service", config.ServiceName(), "err", err)
 			// cmdr.App().SetSuggestRetCode(retCode)
@@ -228,50 +229,6 @@ func launchdStart(ctx context.Context, config *Config, m *mgmtS, s *launchD) (er
 	return
 }
 
-func enterLoop(ctx context.Context, config *Config, m *mgmtS, s *launchD) (err error) {
-	closeChan := make(chan struct{}, 8)
-	defer func() { close(closeChan) }()
-
-	pid, ppid := os.Getpid(), os.Getppid()
-
-	catcher := is.Signals().Catch()
-	catcher.WithOnSignalCaught(func(ctx context.Context, sig os.Signal, wgShutdown *sync.WaitGroup) {
-		println()
-		fmt.Printf("signal %q caught...\n", sig)
-		closeChan <- struct{}{}
-	}).WaitFor(ctx, func(ctx context.Context, closer func()) {
-		defer func() {
-			dbglog.InfoContext(ctx, "stop launchd service", "pid", pid, "ppid", ppid, "service", config.ServiceName())
-			err = launchdStop(ctx, config, m, s)
-			if err != nil {
-				dbglog.ErrorContext(ctx, "stop launchd service failed", "pid", pid, "ppid", ppid, "service", config.ServiceName(), "err", err)
-			}
-			closer()
-		}()
-		dbglog.InfoContext(ctx, "entering loop", "service", config.ServiceName())
-		for {
-			select {
-			case <-ctx.Done():
-				return
-			case <-closeChan:
-				return
-			}
-		}
-	})
-	// for {
-	// 	select {
-	// 	case <-ctx.Done():
-	// 		dbglog.InfoContext(ctx, "stop launchd service", "pid", pid, "ppid", ppid, "service", config.ServiceName())
-	// 		err = launchdStop(ctx, config, m, s)
-	// 		if err != nil {
-	// 			dbglog.ErrorContext(ctx, "stop launchd service failed", "pid", pid, "ppid", ppid, "service", config.ServiceName(), "err", err)
-	// 		}
-	// 		return
-	// 	}
-	// }
-	return
-}
-
 func launchdStop(ctx context.Context, config *Config, m *mgmtS, s *launchD) (err error) {
 	dbglog.DebugContext(ctx, "stop")
 
@@ -285,8 +242,8 @@ func launchdStop(ctx context.Context, config *Config, m *mgmtS, s *launchD) (err
 		sn := serviceName
 		_ = s.Logger.Infof("launchctl stop %q with %q\n", sn, file)
 		var msg string
-		// retCode, msg, err = exec.Sudo("launchctl", "stop", sn)
-		retCode, msg, err = exec.Sudo("launchctl", "unload", file)
+		// retCode, msg, err = elevate("launchctl", "stop", sn)
+		retCode, msg, err = elevate("launchctl", "unload", file)
 		if err != nil || retCode != 0 {
 			err = errors.New("failed to stop service. The console outputs are:\n%v", msg).WithErrors(err)
 			return
@@ -305,13 +262,15 @@ func launchdStatus(ctx context.Context, config *Config, m *mgmtS, s *launchD) (e
 	_, _, _, serviceName, file := serviceFilename(config)
 	if dir.FileExists(file) {
 		var msg string
-		retCode, msg, err = exec.Sudo("launchctl", "status", serviceName)
+		retCode, msg, err = elevate("launchctl", "status", serviceName)
 		if err != nil || retCode != 0 {
 			err = errors.New("failed to status service. The console outputs are:\n%v", msg).WithErrors(err)
 			return
 		}
 	}
 
+	printSupervisorStatus(config)
+	printHealthStatus(config)
 	return
 }
 
@@ -324,14 +283,14 @@ func launchdRestart(ctx context.Context, config *Config, m *mgmtS, s *launchD) (
 	_, _, _, serviceName, file := serviceFilename(config)
 	if dir.FileExists(file) {
 		var msg string
-		retCode, msg, err = exec.Sudo("launchctl", "stop", serviceName)
+		retCode, msg, err = elevate("launchctl", "stop", serviceName)
 		if err != nil || retCode != 0 {
 			err = errors.New("failed to stop service. The console outputs are:\n%v", msg).WithErrors(err)
 			return
 		}
 
 		time.Sleep(333 * time.Millisecond)
-		retCode, msg, err = exec.Sudo("launchctl", "stop", serviceName)
+		retCode, msg, err = elevate("launchctl", "stop", serviceName)
 		if err != nil || retCode != 0 {
 			err = errors.New("failed to (re)start service. The console outputs are:\n%v", msg).WithErrors(err)
 			return
@@ -350,7 +309,7 @@ func launchdHotReload(ctx context.Context, config *Config, m *mgmtS, s *launchD)
 	_, _, _, serviceName, file := serviceFilename(config)
 	if dir.FileExists(file) {
 		var msg string
-		retCode, msg, err = exec.Sudo("launchctl", "reload", serviceName)
+		retCode, msg, err = elevate("launchctl", "reload", serviceName)
 		if err != nil || retCode != 0 {
 			err = errors.New("failed to hot-reload service. The console outputs are:\n%v", msg).WithErrors(err)
 			return
@@ -390,6 +349,14 @@ func launchdInstall(ctx context.Context, config *Config, m *mgmtS, s *launchD) (
 		}
 	}
 
+	if files, enabled := s.installedFiles(ctx, config); len(files) > 0 {
+		var bm BackupManifest
+		if bm, err = archiveInstallation(ctx, config, "reinstall", files, enabled); err != nil {
+			return
+		}
+		println("backup saved:", bm.Dir)
+	}
+
 	err = createServiceFile(ctx, config, file, autoEnable)
 	if err != nil {
 		return
@@ -398,7 +365,7 @@ func launchdInstall(ctx context.Context, config *Config, m *mgmtS, s *launchD) (
 	// refresh systemd
 	var retCode int
 	var msg string
-	retCode, msg, err = exec.Sudo("launchctl", "load", file)
+	retCode, msg, err = elevate("launchctl", "load", file)
 	if err != nil || retCode != 0 {
 		err = errors.New("failed to install service. The console outputs are:\n%v", msg).WithErrors(err)
 		return
@@ -425,7 +392,7 @@ func createServiceFile(ctx context.Context, config *Config, file string, autoLoa
 		if err == nil {
 			_ = tmpFile.Close()
 			// dir.DeleteFile(tmpFile.Name())
-			_, _, err = exec.Sudo("mv", tmpFile.Name(), file)
+			_, _, err = elevate("mv", tmpFile.Name(), file)
 			if err == nil {
 				logz.DebugContext(ctx, "moved service file ok.", "target", file)
 				// ctt, _ := os.ReadFile(file)
@@ -436,25 +403,15 @@ func createServiceFile(ctx context.Context, config *Config, file string, autoLoa
 		}
 	}()
 
-	var tmpl *template.Template
-	tmplFile := fmt.Sprintf("%v/share/service.darwin.tpl", config.TemplateDir)
-	if dir.FileExists(tmplFile) {
-		tmpl, err = template.New("service.file").ParseFiles(tmplFile)
-	} else {
-		tmpl, err = template.New("service.file").Parse(tplLaunchdService)
-	}
-	if err != nil {
-		return
-	}
-
-	if err = tmpl.Execute(tmpFile, struct {
-		*Config
-		AutoLoad bool
-	}{config,
-		autoLoad,
-	}); err != nil {
+	// the plist is rendered by render.Render, with the custom template
+	// <TemplateDir>/share/service.darwin.tpl if exists.
+	rc := config.RenderConfig()
+	rc.AutoLoad = autoLoad
+	var files map[string][]byte
+	if files, err = render.Render(render.Launchd, rc); err != nil {
 		return
 	}
+	_, err = tmpFile.Write(files[render.ServiceFile(render.Launchd, rc)])
 	return
 }
 
@@ -479,6 +436,9 @@ func launchdUninstall(ctx context.Context, config *Config, m *mgmtS, s *launchD)
 		return fn.Uninstall(ctx, config, s.Logger)
 	}
 
+	// collected before unloading, to keep the loaded state
+	files, enabled := s.installedFiles(ctx, config)
+
 	if err = launchdStop(ctx, config, m, s); err != nil {
 		dbglog.WarnContext(ctx, "Failed to stop service: "+err.Error())
 		// return
@@ -489,39 +449,73 @@ func launchdUninstall(ctx context.Context, config *Config, m *mgmtS, s *launchD)
 		// return
 	}
 
+	if len(files) == 0 {
+		println("nothing needs to be done.")
+		return
+	}
+
 	var retCode int
-	anyExist := false
 	_, _, userLevel, serviceName, file := serviceFilename(config)
-	if dir.FileExists(file) {
-		dbglog.InfoContext(ctx, "uninstalling service file: "+file)
-		var msg string
-		if userLevel {
-			retCode, msg, err = exec.RunWithOutput("launchctl", "unload", file)
-		} else {
-			retCode, msg, err = exec.Sudo("launchctl", "unload", file)
-		}
-		if err != nil || retCode != 0 {
-			err = errors.New("failed to uninstall service. The console outputs are:\n%v", msg).WithErrors(err)
-			return
-		}
+	dbglog.InfoContext(ctx, "uninstalling service file: "+file)
+	var msg string
+	if userLevel {
+		retCode, msg, err = exec.RunWithOutput("launchctl", "unload", file)
+	} else {
+		retCode, msg, err = elevate("launchctl", "unload", file)
+	}
+	if err != nil || retCode != 0 {
+		err = errors.New("failed to uninstall service. The console outputs are:\n%v", msg).WithErrors(err)
+		return
+	}
 
-		retCode, msg, err = exec.Sudo("mv", file, os.TempDir())
-		if err != nil || retCode != 0 {
-			err = errors.New("failed to mv service file to trashbin. The console outputs are:\n%v", msg).WithErrors(err)
+	// never remove anything not archived
+	var bm BackupManifest
+	if bm, err = archiveInstallation(ctx, config, "uninstall", files, enabled); err != nil {
+		err = errors.New("failed to back up the installation, nothing removed").WithErrors(err)
+		return
+	}
+	println("backup saved:", bm.Dir)
+
+	for _, file := range files {
+		if err = elevateE("rm", "-f", file); err != nil {
+			err = errors.New("failed to uninstall service").WithErrors(err)
 			return
 		}
-		anyExist = true
 	}
 
-	if !anyExist {
-		println("nothing needs to be done.")
+	println(serviceName, "[SUCCESS] service uninstalled.")
+	return
+}
+
+// installedFiles returns the plist of the service if exists, the
+// installation is enabled if the plist is loaded.
+func (s *launchD) installedFiles(ctx context.Context, config *Config) (files []string, enabled bool) {
+	_, _, userLevel, serviceName, file := serviceFilename(config)
+	if !dir.FileExists(file) {
 		return
 	}
-
-	println(serviceName, "[SUCCESS] service uninstalled.")
+	files = append(files, file)
+	var retCode int
+	if userLevel {
+		retCode, _, _ = exec.RunWithOutput("launchctl", "list", serviceName)
+	} else {
+		retCode, _, _ = elevate("launchctl", "list", serviceName)
+	}
+	enabled = retCode == 0
 	return
 }
 
+// afterRestore loads the restored plist if it was loaded in the
+// backup.
+func (s *launchD) afterRestore(ctx context.Context, config *Config, tx *installTx, bm BackupManifest) (err error) {
+	if !bm.Enabled {
+		return
+	}
+	_, _, _, _, file := serviceFilename(config)
+	_, _, _ = elevate("launchctl", "unload", file) // the current one, if loaded
+	return tx.exec("load", file, []string{"launchctl", "load", file}, []string{"launchctl", "unload", file})
+}
+
 func launchdEnable(ctx context.Context, config *Config, m *mgmtS, s *launchD) (err error) {
 	if fn, ok := config.Entity.(EntityEnableAware); ok {
 		return fn.Enable(ctx, config, s.Logger)
@@ -570,7 +564,7 @@ func launchdDisable(ctx context.Context, config *Config, m *mgmtS, s *launchD) (
 	var retCode int
 	var msg string
 	_, _, _, serviceName, _ := serviceFilename(config)
-	retCode, msg, err = exec.Sudo("launchctl", "disable", serviceName)
+	retCode, msg, err = elevate("launchctl", "disable", serviceName)
 	if err != nil || retCode != 0 {
 		err = errors.New("failed to disable service. The console outputs are:\n%v", msg).WithErrors(err)
 		return
@@ -588,39 +582,6 @@ func launchdViewLog(ctx context.Context, config *Config, m *mgmtS, s *launchD) (
 }
 
 const (
-	tplLaunchdService = `<?xml version="1.0" encoding="UTF-8"?>
-<!DOCTYPE plist PUBLIC "-//Apple//DTD PLIST 1.0//EN" "http://www.apple.com/DTDs/PropertyList-1.0.dtd">
-<plist version="1.0">
-<dict>
-    <key>Label</key>
-    <string>{{.Name}}</string>
-    <key>Program</key>
-    <string>{{.ExecutablePath}}</string>
-    <key>ProgramArguments</key>
-    <array>
-        {{range $k, $v := .ArgsForInstall -}}
-        <string>{{$v}}</string>
-        {{end -}}
-    </array>
-    <key>KeepAlive</key>
-    <true/>
-    <key>RunAtLoad</key>
-	{{if .AutoLoad}}
-	<true/>
-	{{else}}
-    <false/>
-	{{end}}
-    <key>OnDemand</key>
-    <true/>
-    <key>LaunchOnlyOnce</key>
-    <false/>
-    <key>StandardOutPath</key>
-    <string>{{.StandardOutPath}}</string>
-    <key>StandardErrorPath</key>
-    <string>{{.StandardErrorPath}}</string>
-</dict>
-</plist>`
-
 	systemDaemons = "/Library/LaunchDaemons"
 	systemAgents  = "/Library/LaunchAgents"
 	userAgents    = "$HOME/Library/LaunchAgents"
@@ -30,6 +30,8 @@ type ntServiceD struct {
 	inService bool
 }
 
+func (s *ntServiceD) String() string { return "ntservice" }
+
 func (s *ntServiceD) Close() {
 	if s.Logger != nil {
 		if c, ok := s.Logger.(interface{ Close() error }); ok {
@@ -620,7 +622,7 @@ func runService(ctx context.Context, m *mgmtS, s *ntServiceD, config *Config, sv
 	if ok {
 		prog.SetServiceMode(m.serviceMode)
 		_ = s.Logger.Infof("run program...\n")
-		err = prog.Run(ctx, config, s.Logger)
+		err = m.supervise(ctx, config, s.Logger, func(ctx context.Context) error { return prog.Run(ctx, config, s.Logger) })
 		if err != nil {
 			return
 		}
@@ -631,7 +633,7 @@ func runService(ctx context.Context, m *mgmtS, s *ntServiceD, config *Config, sv
 	if isDebug {
 		run = debug.Run
 	}
-	err = run(svcName, &serviceStub{s: s, config: config, done: ctx.Done()})
+	err = run(svcName, &serviceStub{s: s, m: m, config: config, done: ctx.Done()})
 	if err != nil {
 		_ = s.Logger.Errorf("%s service failed: %v", svcName, err)
 		return
@@ -643,6 +645,7 @@ func runService(ctx context.Context, m *mgmtS, s *ntServiceD, config *Config, sv
 
 type serviceStub struct {
 	s      *ntServiceD
+	m      *mgmtS
 	config *Config
 	done   <-chan struct{}
 	prog   RunnableService
@@ -658,6 +661,7 @@ func (m *serviceStub) Execute(args []string, r <-chan svc.ChangeRequest, changes
 	tick := fasttick
 
 	changes <- svc.Status{State: svc.Running, Accepts: cmdsAccepted}
+	_ = m.m.emit(context.Background(), Event{Kind: Started, Command: Start, Config: m.config, Backend: m.m.backend})
 
 	defer func() {
 		changes <- svc.Status{State: svc.StopPending}
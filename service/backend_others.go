@@ -12,12 +12,12 @@ import (
 	"os"
 	"path"
 	"strings"
-	"text/template"
 
 	"github.com/hedzr/is/dir"
 	cmdrexec "github.com/hedzr/is/exec"
 	"gopkg.in/hedzr/errors.v3"
 
+	"github.com/hedzr/cmdr-addons/service/v2/render"
 	"github.com/hedzr/cmdr-addons/service/v2/systems"
 	"github.com/hedzr/cmdr-addons/v2/tool/dbglog"
 )
@@ -32,6 +32,8 @@ type initD struct {
 	Logger ZLogger
 }
 
+func (s *initD) String() string { return "initd" }
+
 func (s *initD) Close() {
 	if s.Logger != nil {
 		if c, ok := s.Logger.(interface{ Close() error }); ok {
@@ -64,7 +66,7 @@ func (s *initD) IsValid(ctx context.Context) (valid bool) {
 		}
 
 		// refresh services
-		retCode, _, err = cmdrexec.Sudo("systemctl", "daemon-reload")
+		retCode, _, err = elevate("systemctl", "daemon-reload")
 		if err != nil || retCode != 0 {
 			valid = false
 		}
@@ -217,7 +219,7 @@ func initdStart(ctx context.Context, config *Config, m *mgmtS, s *initD) (err er
 	var retCode int
 	var msg string
 	_ = s.Logger.Infof("systemctl start %s\n", config.ServiceName())
-	retCode, msg, err = cmdrexec.Sudo("systemctl", "start", config.ServiceName())
+	retCode, msg, err = elevate("systemctl", "start", config.ServiceName())
 	if err != nil || retCode != 0 {
 		// dbglog.DebugContext(ctx, "`sudo systemctl start service` failed", "service", config.ServiceName(), "err", err)
 		// cmdr.App().SetSuggestRetCode(retCode)
@@ -244,7 +246,7 @@ func initdStop(ctx context.Context, config *Config, m *mgmtS, s *initD) (err err
 
 	var retCode int
 	var msg string
-	retCode, msg, err = cmdrexec.Sudo("systemctl", "stop", config.ServiceName())
+	retCode, msg, err = elevate("systemctl", "stop", config.ServiceName())
 	if err != nil || retCode != 0 {
 		err = errors.New("failed to stop service. The console outputs are:\n%v", msg).WithErrors(err)
 		return
@@ -260,7 +262,7 @@ func initdStatus(ctx context.Context, config *Config, m *mgmtS, s *initD) (err e
 
 	var retCode int
 	var msg string
-	retCode, msg, err = cmdrexec.Sudo("systemctl", "status", config.ServiceName())
+	retCode, msg, err = elevate("systemctl", "status", config.ServiceName())
 	if err != nil || retCode != 0 {
 		err = errors.New("failed to status service. The console outputs are:\n%v", msg).WithErrors(err)
 		return
@@ -276,7 +278,7 @@ func initdRestart(ctx context.Context, config *Config, m *mgmtS, s *initD) (err
 
 	var retCode int
 	var msg string
-	retCode, msg, err = cmdrexec.Sudo("systemctl", "restart", config.ServiceName())
+	retCode, msg, err = elevate("systemctl", "restart", config.ServiceName())
 	if err != nil || retCode != 0 {
 		err = errors.New("failed to restart service. The console outputs are:\n%v", msg).WithErrors(err)
 		return
@@ -292,7 +294,7 @@ func initdHotReload(ctx context.Context, config *Config, m *mgmtS, s *initD) (er
 
 	var retCode int
 	var msg string
-	retCode, msg, err = cmdrexec.Sudo("systemctl", "reload", config.ServiceName())
+	retCode, msg, err = elevate("systemctl", "reload", config.ServiceName())
 	if err != nil || retCode != 0 {
 		err = errors.New("failed to hot-reload service. The console outputs are:\n%v", msg).WithErrors(err)
 		return
@@ -303,7 +305,7 @@ func initdHotReload(ctx context.Context, config *Config, m *mgmtS, s *initD) (er
 
 func initdIsActive(config *Config) (text string, err error) {
 	var retCode int
-	retCode, text, err = cmdrexec.Sudo("systemctl", "is-active", config.ServiceName())
+	retCode, text, err = elevate("systemctl", "is-active", config.ServiceName())
 	if err != nil || retCode != 0 {
 		return
 	}
@@ -341,7 +343,7 @@ func initdIsStarted(ctx context.Context, config *Config, m *mgmtS, s *initD) (er
 func initdIsEnabled(ctx context.Context, config *Config, m *mgmtS, s *initD) (err error) {
 	var retCode int
 	var text string
-	retCode, text, err = cmdrexec.Sudo("systemctl", "is-enabled", config.ServiceName())
+	retCode, text, err = elevate("systemctl", "is-enabled", config.ServiceName())
 	if err != nil || retCode != 0 {
 		return
 	}
@@ -355,73 +357,31 @@ func initdGetStatus(ctx context.Context, config *Config, m *mgmtS, s *initD) (er
 	return
 }
 
+// createServiceFile writes the file of the systemd unit rendered by
+// render.Render into svcfile, or the env file if it is EnvFilePath.
 func createServiceFile(ctx context.Context, config *Config, svcfile string) (err error) {
-	var tmpl *template.Template
-	tmplFile := fmt.Sprintf("%v/share/service.tpl", config.TemplateDir)
-	if dir.FileExists(tmplFile) {
-		tmpl, err = template.New("service.file").ParseFiles(tmplFile)
-	} else {
-		tmpl, err = template.New("service.file").Parse(tplSystemdService)
-	}
-	if err != nil {
-		return
-	}
-
-	var of *os.File
-	tmpFile := fmt.Sprintf("%v/%v", config.TempDir, config.ServiceName())
-	if err = dir.EnsureDir(path.Dir(tmpFile)); err != nil {
+	rc := config.RenderConfig()
+	var files map[string][]byte
+	if files, err = render.Render(render.Systemd, rc); err != nil {
 		return
 	}
-	if of, err = os.Create(tmpFile); err != nil {
-		return
-	}
-
-	if config.Type == "" {
-		config.Type = "exec"
-	}
-
-	defer func() {
-		of.Close()
-		dir.DeleteFile(tmpFile)
-	}()
-	if err = tmpl.Execute(of, config); err != nil {
-		return
-	}
-
-	_, _, err = cmdrexec.Sudo("mv", tmpFile, svcfile)
-	return
-}
-
-func createDefaultFile(ctx context.Context, config *Config, svcfile string) (err error) {
-	var tmpl *template.Template
-	tmplFile := fmt.Sprintf("%v/share/default.tpl", config.TemplateDir)
-	if dir.FileExists(tmplFile) {
-		tmpl, err = template.New("service.file").ParseFiles(tmplFile)
-	} else {
-		tmpl, err = template.New("service.file").Parse(tplEtcDefault)
-	}
-	if err != nil {
-		return
+	name := render.ServiceFile(render.Systemd, rc)
+	if svcfile == config.EnvFilePath() {
+		name = rc.EnvFilePath
 	}
 
-	var of *os.File
-	tmpFile := fmt.Sprintf("%v/%v", config.TempDir, config.ServiceName())
-	if err = dir.EnsureDir(path.Dir(tmpFile)); err != nil {
+	var f *os.File
+	if f, err = os.CreateTemp("", path.Base(svcfile)+".*"); err != nil {
 		return
 	}
-	if of, err = os.Create(tmpFile); err != nil {
-		return
+	defer os.Remove(f.Name())
+	_, err = f.Write(files[name])
+	if e := f.Close(); err == nil {
+		err = e
 	}
-
-	defer func() {
-		of.Close()
-		dir.DeleteFile(tmpFile)
-	}()
-	if err = tmpl.Execute(of, config); err != nil {
-		return
+	if err == nil {
+		err = elevateE("install", "-m", "0644", f.Name(), svcfile)
 	}
-
-	cmdrexec.Sudo("mv", tmpFile, svcfile)
 	return
 }
 
@@ -466,12 +426,12 @@ If you wanna reinstall it, try this command line:
 		return
 	}
 
-	file = fmt.Sprintf("%s/%s", defaultsDir, config.Name)
+	file = config.EnvFilePath()
 	fileExist := dir.FileExists(file)
 	if fileExist && !config.ForceReinstall {
 		//
 	} else {
-		err = createDefaultFile(ctx, config, file)
+		err = createServiceFile(ctx, config, file)
 		if err != nil {
 			dbglog.WarnContext(ctx, "something's wrong.", "err", err)
 			err = nil
@@ -482,7 +442,7 @@ If you wanna reinstall it, try this command line:
 	// refresh systemd
 	var retCode int
 	var msg string
-	retCode, msg, err = cmdrexec.Sudo("systemctl", "daemon-reload")
+	retCode, msg, err = elevate("systemctl", "daemon-reload")
 	if err != nil || retCode != 0 {
 		err = errors.New("failed to refresh services list. The console outputs are:\n%v", msg).WithErrors(err)
 		return
@@ -508,6 +468,9 @@ func initdUninstall(ctx context.Context, config *Config, m *mgmtS, s *initD) (er
 		return errors.Unavailable
 	}
 
+	// collected before disabling, to keep the enabled state
+	files, enabled := s.installedFiles(ctx, config)
+
 	if err = initdStop(ctx, config, m, s); err != nil {
 		dbglog.WarnContext(ctx, "systemd stop command failed.", "err", err)
 		// return
@@ -518,43 +481,29 @@ func initdUninstall(ctx context.Context, config *Config, m *mgmtS, s *initD) (er
 		// return
 	}
 
-	anyExist := false
-	file := fmt.Sprintf("%s/%s", systemdDir, config.ServiceName())
-	if dir.FileExists(file) {
-		var retCode int
-		var msg string
-		retCode, msg, err = cmdrexec.Sudo("mv", file, os.TempDir())
-		if err != nil || retCode != 0 {
-			err = errors.New("failed to uninstall service. The console outputs are:\n%v", msg).WithErrors(err)
-			dbglog.WarnContext(ctx, "something's wrong.", "err", err)
-			err = nil
-			return
-		}
-		anyExist = true
+	if len(files) == 0 {
+		println("nothing needs to be done.")
+		return
 	}
 
-	file = fmt.Sprintf("%s/%s", defaultsDir, config.Name)
-	if dir.FileExists(file) {
-		var retCode int
-		var msg string
-		retCode, msg, err = cmdrexec.Sudo("mv", file, os.TempDir())
-		if err != nil || retCode != 0 {
-			err = errors.New("failed to mv service file to trashbin. The console outputs are:\n%v", msg).WithErrors(err)
-			dbglog.WarnContext(ctx, "something's wrong.", "err", err)
-			err = nil
-			return
-		}
-		anyExist = true
+	// never remove anything not archived
+	var bm BackupManifest
+	if bm, err = archiveInstallation(ctx, config, "uninstall", files, enabled); err != nil {
+		err = errors.New("failed to back up the installation, nothing removed").WithErrors(err)
+		return
 	}
+	println("backup saved:", bm.Dir)
 
-	if !anyExist {
-		println("nothing needs to be done.")
-		return
+	for _, file := range files {
+		if err = elevateE("rm", "-f", file); err != nil {
+			err = errors.New("failed to uninstall service").WithErrors(err)
+			return
+		}
 	}
 
 	// refresh systemd
 	var retCode int
-	retCode, _, err = cmdrexec.Sudo("systemctl", "daemon-reload")
+	retCode, _, err = elevate("systemctl", "daemon-reload")
 	if err != nil || retCode != 0 {
 		return
 	}
@@ -563,6 +512,33 @@ func initdUninstall(ctx context.Context, config *Config, m *mgmtS, s *initD) (er
 	return
 }
 
+func (s *initD) installedFiles(ctx context.Context, config *Config) (files []string, enabled bool) {
+	for _, file := range []string{fmt.Sprintf("%s/%s", systemdDir, config.ServiceName()), config.EnvFilePath()} {
+		if dir.FileExists(file) {
+			files = append(files, file)
+		}
+	}
+	if len(files) > 0 {
+		retCode, _, _ := elevate("systemctl", "is-enabled", config.ServiceName())
+		enabled = retCode == 0
+	}
+	return
+}
+
+// afterRestore refreshes the services list, and enables the service if
+// it was enabled in the backup.
+func (s *initD) afterRestore(ctx context.Context, config *Config, tx *installTx, bm BackupManifest) (err error) {
+	tx.atRollbackEnd("systemctl", "daemon-reload")
+	if err = tx.exec("daemon-reload", "systemd", []string{"systemctl", "daemon-reload"}, nil); err != nil {
+		return
+	}
+	unit := config.ServiceName()
+	if retCode, _, _ := elevate("systemctl", "is-enabled", unit); bm.Enabled && retCode != 0 {
+		err = tx.exec("enable", unit, []string{"systemctl", "enable", unit}, []string{"systemctl", "disable", unit})
+	}
+	return
+}
+
 func initdEnable(ctx context.Context, config *Config, m *mgmtS, s *initD) (err error) {
 	if fn, ok := config.Entity.(EntityEnableAware); ok {
 		return fn.Enable(ctx, config, s.Logger)
@@ -575,7 +551,7 @@ func initdEnable(ctx context.Context, config *Config, m *mgmtS, s *initD) (err e
 
 	var retCode int
 	var msg string
-	retCode, msg, err = cmdrexec.Sudo("systemctl", "enable", config.ServiceName())
+	retCode, msg, err = elevate("systemctl", "enable", config.ServiceName())
 	if err != nil || retCode != 0 {
 		err = errors.New("failed to enable service. The console outputs are:\n%v", msg).WithErrors(err)
 		return
@@ -597,7 +573,7 @@ func initdDisable(ctx context.Context, config *Config, m *mgmtS, s *initD) (err
 
 	var retCode int
 	var msg string
-	retCode, msg, err = cmdrexec.Sudo("systemctl", "disable", config.ServiceName())
+	retCode, msg, err = elevate("systemctl", "disable", config.ServiceName())
 	if err != nil || retCode != 0 {
 		err = errors.New("failed to disable service. The console outputs are:\n%v", msg).WithErrors(err)
 		return
@@ -615,97 +591,5 @@ func initdViewLog(ctx context.Context, config *Config, m *mgmtS, s *initD) (err
 }
 
 const (
-	tplEtcDefault = `### {{.ScreenName}} configurations
-### executable: {{.ExecutablePath}}
-
-# PORT=3211
-
-# OPTIONS="--port 3211"
-
-#
-# the service startup command line is like:
-#
-#	$ service-app [global-options] server start [options]
-#
-GLOBAL_OPTIONS=""
-OPTIONS=""
-
-`
-
-	// tplSystemService template file for creating a new systemd service.
-	//
-	//
-	//
-	//
-	// ref: to-d-o
-	tplSystemdService = `### {{.ScreenName}} services
-### {{.ServiceName}}
-### executable: {{.ExecutablePath}}
-
-[Unit]
-Description={{.ScreenName}} Service for %i - {{.Desc}}
-# Documentation=man:sshd(8) man:sshd_config(5) man:{{.Name}}(1)
-After=network.target
-# Wants=syslog.service
-ConditionPathExists={{.ExecutablePath}}
-
-[Install]
-WantedBy=multi-user.target
-
-[Service]
-Type={{.Type}}
-{{if .User}}User={{.User}}{{else}}# User=%i{{end}}
-{{if .Group}}Group={{.Group}}{{else}}# Group=%i{{end}}
-LimitNOFILE=65535
-{{if .TimeoutStartSec}}TimeoutStartSec={{.TimeoutStartSec}}{{else}}TimeoutStartSec=60s{{end}}
-{{if .TimeoutStopSec}}TimeoutStopSec={{.TimeoutStopSec}}{{else}}TimeoutStopSec=60s{{end}}
-{{if .PIDFile}}PIDFile={{.PIDFile}}{{else}}PIDFile=/run/{{.Name}}/{{.Name}}.pid{{end}}
-
-KillMode=process
-Restart=on-failure
-{{if .RestartSec}}RestartSec={{.RestartSec}}{{else}}RestartSec=23s{{end}}
-# RestartLimitIntervalSec=60
-
-EnvironmentFile=/etc/sysconfig/{{.Name}}
-{{range $k, $v := .Env -}}
-Environment={{$k}}={{$v}}
-{{end -}}
-
-{{if .WorkDir}}WorkingDirectory={{.WorkDir}}{{else}}WorkingDirectory=%h{{end}}
-
-#          start: --addr, --port,
-#           todo: --pid
-# global options: --verbose, --debug,
-ExecStart={{.ExecutablePath}} $GLOBAL_OPTIONS server start --foreground --service $OPTIONS
-#           stop: -1/--hup, -9/--kill,
-### TODO ExecStop={{.ExecutablePath}} $GLOBAL_OPTIONS server stop -1
-### TODO ExecReload=/bin/kill -HUP $MAINPID
-ExecStop={{.ExecutablePath}} $GLOBAL_OPTIONS server stop -3
-ExecReload={{.ExecutablePath}} $GLOBAL_OPTIONS server restart
-
-# # make sure log directory exists and owned by syslog
-#PermissionsStartOnly=true
-ExecStartPre=-/bin/mkdir /run/{{.Name}}
-ExecStartPre=-/bin/mkdir /var/lib/{{.Name}}
-ExecStartPre=-/bin/mkdir /var/log/{{.Name}}
-ExecStartPre=-/bin/chown -R %i: /var/run/{{.Name}} /var/lib/{{.Name}}
-# ExecStartPre=-/bin/chown -R syslog:adm /var/log/{{.Name}}
-ExecStartPre=-/bin/chown -R %i: /var/log/{{.Name}}
-
-# # enable coredump
-# ExecStartPre=ulimit -c unlimited
-
-SyslogIdentifier={{.Name}}
-StandardOutput=append:{{.StandardOutPath}}
-StandardError=append:{{.StandardErrorPath}}
-
-
-
-
-`
-
 	systemdDir = "/etc/systemd/system"
-
-	defaultsDir = "/etc/sysconfig"
-	// defaultsDir = "/etc/default"
 )
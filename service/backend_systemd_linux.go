@@ -3,7 +3,6 @@ package service
 import (
 	"bytes"
 	"context"
-	"fmt"
 	"log/slog"
 	"log/syslog"
 	"os"
@@ -11,15 +10,13 @@ import (
 	"slices"
 	"strconv"
 	"strings"
-	"sync"
 	"text/template"
-	"time"
 
-	"github.com/hedzr/is"
 	"github.com/hedzr/is/dir"
 	cmdrexec "github.com/hedzr/is/exec"
 	"gopkg.in/hedzr/errors.v3"
 
+	"github.com/hedzr/cmdr-addons/service/v2/render"
 	"github.com/hedzr/cmdr-addons/service/v2/systems"
 	"github.com/hedzr/cmdr-addons/v2/tool/dbglog"
 )
@@ -32,6 +29,8 @@ type systemD struct {
 	Logger ZLogger
 }
 
+func (s *systemD) String() string { return "systemd" }
+
 func (s *systemD) Close() {
 	if s.Logger != nil {
 		if c, ok := s.Logger.(interface{ Close() error }); ok {
@@ -62,12 +61,6 @@ func (s *systemD) IsValid(ctx context.Context) (valid bool) {
 		if err != nil || retCode != 0 {
 			valid = false
 		}
-
-		// refresh services
-		retCode, _, err = cmdrexec.Sudo("systemctl", "daemon-reload")
-		if err != nil || retCode != 0 {
-			valid = false
-		}
 	}
 	return
 }
@@ -208,23 +201,31 @@ func systemdStart(ctx context.Context, config *Config, m *mgmtS, s *systemD) (er
 	if fn, ok := config.Entity.(EntityStartAware); ok {
 		dbglog.DebugContext(ctx, "start EntityStartAware")
 		_ = s.Logger.Infof("start EntityStartAware\n")
-		err = fn.Start(ctx, config, s.Logger)
-		if err == nil && m.serviceMode && m.fore {
-			err = enterLoop(ctx, config, m, s)
+		start := func(ctx context.Context) error { return fn.Start(ctx, config, s.Logger) }
+		switch {
+		case m.fore && m.serviceMode:
+			err = m.serveEntity(ctx, config, s.Logger, start)
+		case m.fore:
+			err = m.supervise(ctx, config, s.Logger, start)
+		default:
+			err = fn.Start(ctx, config, s.Logger)
 		}
 		return
 	}
 
 	// call into RunnableService.Run if exists
 	if m.fore {
+		var run func(ctx context.Context) error
 		if prog, ok := config.Entity.(RunnableService); ok {
 			prog.SetServiceMode(m.serviceMode)
 			_ = s.Logger.Infof("run program...\n")
 			println("run program...")
-			err = prog.Run(ctx, config, s.Logger)
+			run = func(ctx context.Context) error { return prog.Run(ctx, config, s.Logger) }
 		}
-		if err == nil && m.serviceMode && m.fore {
-			err = enterLoop(ctx, config, m, s)
+		if m.serviceMode {
+			err = m.serveEntity(ctx, config, s.Logger, run)
+		} else if run != nil {
+			err = m.supervise(ctx, config, s.Logger, run)
 		}
 		return
 	}
@@ -233,7 +234,7 @@ func systemdStart(ctx context.Context, config *Config, m *mgmtS, s *systemD) (er
 	var retCode int
 	var msg string
 	_ = s.Logger.Infof("systemctl start %s\n", config.ServiceName())
-	retCode, msg, err = cmdrexec.Sudo("systemctl", "start", config.ServiceName())
+	retCode, msg, err = elevate("systemctl", "start", config.ServiceName())
 	if err != nil || retCode != 0 {
 		// dbglog.DebugContext(ctx, "`sudo systemctl start service` failed", "service", config.ServiceName(), "err", err)
 		// cmdr.App().SetSuggestRetCode(retCode)
@@ -248,86 +249,30 @@ func systemdStart(ctx context.Context, config *Config, m *mgmtS, s *systemD) (er
 	return
 }
 
-func enterLoop(ctx context.Context, config *Config, m *mgmtS, s *systemD) (err error) {
-	closeChan := make(chan struct{}, 8)
-	defer func() { close(closeChan) }()
-
-	pid, ppid := os.Getpid(), os.Getppid()
-
-	catcher := is.Signals().Catch()
-	catcher.WithOnSignalCaught(func(ctx context.Context, sig os.Signal, wgShutdown *sync.WaitGroup) {
-		println()
-		fmt.Printf("signal %q caught...\n", sig)
-		closeChan <- struct{}{}
-	}).WaitFor(ctx, func(ctx context.Context, closer func()) {
-		ticker := time.NewTicker(10 * time.Second)
-		defer func() {
-			ticker.Stop()
-			dbglog.InfoContext(ctx, "stop systemd service", "pid", pid, "ppid", ppid, "service", config.ServiceName())
-			err = systemdStop(ctx, config, m, s)
-			if err != nil {
-				dbglog.ErrorContext(ctx, "stop systemd service failed", "pid", pid, "ppid", ppid, "service", config.ServiceName(), "err", err)
-			}
-			closer()
-		}()
-		dbglog.InfoContext(ctx, "entering loop", "service", config.ServiceName())
-		for {
-			select {
-			case <-ctx.Done():
-				return
-			case <-closeChan:
-				return
-			case tick := <-ticker.C:
-				dbglog.InfoContext(ctx, "(service.ticker) tick", "tick", tick)
-			}
-		}
-	})
-	// for {
-	// 	select {
-	// 	case <-ctx.Done():
-	// 		dbglog.InfoContext(ctx, "stop systemd service", "pid", pid, "ppid", ppid, "service", config.ServiceName())
-	// 		err = systemdStop(ctx, config, m, s)
-	// 		if err != nil {
-	// 			dbglog.ErrorContext(ctx, "stop systemd service failed", "pid", pid, "ppid", ppid, "service", config.ServiceName(), "err", err)
-	// 		}
-	// 		return
-	// 	}
-	// }
-	return
-}
-
 func systemdStop(ctx context.Context, config *Config, m *mgmtS, s *systemD) (err error) {
 	if fn, ok := config.Entity.(EntityStopAware); ok {
 		return fn.Stop(ctx, config, s.Logger)
 	}
 
+	// invoked by systemd, such as ExecStop=, stop the main process
+	// directly with signals.
+	if pid := systemdStopTarget(ctx, config); pid > 0 {
+		return systemdStopPid(ctx, config, pid, s)
+	}
+
 	if systemdIsRunning(ctx, config, m, s) != nil {
+		// not managed by systemd, such as running in foreground mode
+		// manually, find it by the pidfile.
+		if pid, alive, _ := PidfileAlive(config.PidfilePath()); alive && pid != os.Getpid() {
+			return systemdStopPid(ctx, config, pid, s)
+		}
 		println("service not running")
 		return
 	}
 
 	var retCode int
 	var msg string
-
-	if len(config.PositionalArgs) > 0 {
-		t := config.PositionalArgs[len(config.PositionalArgs)-1]
-		mainpid, err1 := strconv.Atoi(t)
-		if err1 != nil {
-			mainpid = 0
-		}
-		if mainpid > 0 {
-			retCode, msg, err = cmdrexec.Sudo("kill", "-3", t)
-			if err != nil || retCode != 0 {
-				err = errors.New("failed to stop service. The console outputs are:\n%v", msg).WithErrors(err)
-				return
-			}
-			println("The kill -3 signal sent to", t)
-			dbglog.InfoContext(ctx, "The kill -3 signal sent to", "target-pid", mainpid)
-			return
-		}
-	}
-
-	retCode, msg, err = cmdrexec.Sudo("systemctl", "stop", config.ServiceName())
+	retCode, msg, err = elevate("systemctl", "stop", config.ServiceName())
 	if err != nil || retCode != 0 {
 		err = errors.New("failed to stop service. The console outputs are:\n%v", msg).WithErrors(err)
 		return
@@ -340,6 +285,52 @@ func systemdStop(ctx context.Context, config *Config, m *mgmtS, s *systemD) (err
 	return
 }
 
+// systemdStopTarget finds the main pid of the service when we are
+// spawned by systemd (ExecStop=, ExecReload=).
+//
+// The candidates are the trailing $MAINPID argument, the MAINPID
+// env-var, and the MainPID property of the unit. It returns 0 if we
+// are not spawned by systemd, or the main process is ourselves.
+func systemdStopTarget(ctx context.Context, config *Config) (pid int) {
+	if os.Getenv("INVOCATION_ID") == "" {
+		return
+	}
+
+	if len(config.PositionalArgs) > 0 {
+		pid, _ = strconv.Atoi(config.PositionalArgs[len(config.PositionalArgs)-1])
+	}
+	if pid <= 0 {
+		pid, _ = strconv.Atoi(os.Getenv("MAINPID"))
+	}
+	if pid <= 0 {
+		pid = systemdMainPid(ctx, config)
+	}
+	if pid == os.Getpid() {
+		pid = 0
+	}
+	return
+}
+
+// systemdMainPid returns the MainPID property of the unit, or 0.
+func systemdMainPid(ctx context.Context, config *Config) (pid int) {
+	retCode, text, err := cmdrexec.RunWithOutput("systemctl", "show", "--property=MainPID", "--value", config.ServiceName())
+	if err != nil || retCode != 0 {
+		dbglog.DebugContext(ctx, "query MainPID failed", "service", config.ServiceName(), "err", err)
+		return
+	}
+	pid, _ = strconv.Atoi(strings.TrimSpace(text))
+	return
+}
+
+func systemdStopPid(ctx context.Context, config *Config, pid int, s *systemD) (err error) {
+	res := StopProcess(ctx, config, pid, s.Logger)
+	println("stop:", res.String())
+	if !res.Stopped() {
+		err = errors.New("failed to stop service process %d: %s", pid, res.Outcome).WithErrors(res.Err)
+	}
+	return
+}
+
 func systemdStatus(ctx context.Context, config *Config, m *mgmtS, s *systemD) (err error) {
 	if fn, ok := config.Entity.(EntityStatusAware); ok {
 		return fn.Status(ctx, config, s.Logger)
@@ -347,12 +338,14 @@ func systemdStatus(ctx context.Context, config *Config, m *mgmtS, s *systemD) (e
 
 	var retCode int
 	var msg string
-	retCode, msg, err = cmdrexec.Sudo("systemctl", "status", config.ServiceName())
+	retCode, msg, err = elevate("systemctl", "status", config.ServiceName())
 	if err != nil || retCode != 0 {
 		err = errors.New("failed to status service. The console outputs are:\n%v", msg).WithErrors(err)
 		return
 	}
 
+	printSupervisorStatus(config)
+	printHealthStatus(config)
 	return
 }
 
@@ -361,9 +354,17 @@ func systemdRestart(ctx context.Context, config *Config, m *mgmtS, s *systemD) (
 		return fn.Restart(ctx, config, s.Logger)
 	}
 
+	// restart without dropping connections, the new process takes
+	// over MainPID by sd_notify.
+	if sig, ok := config.canUpgrade(); ok {
+		if pid := systemdMainPid(ctx, config); pid > 0 {
+			return upgradeService(ctx, config, pid, sig, func() int { return systemdMainPid(ctx, config) })
+		}
+	}
+
 	var retCode int
 	var msg string
-	retCode, msg, err = cmdrexec.Sudo("systemctl", "restart", config.ServiceName())
+	retCode, msg, err = elevate("systemctl", "restart", config.ServiceName())
 	if err != nil || retCode != 0 {
 		err = errors.New("failed to restart service. The console outputs are:\n%v", msg).WithErrors(err)
 		return
@@ -379,7 +380,7 @@ func systemdHotReload(ctx context.Context, config *Config, m *mgmtS, s *systemD)
 
 	var retCode int
 	var msg string
-	retCode, msg, err = cmdrexec.Sudo("systemctl", "reload", config.ServiceName())
+	retCode, msg, err = elevate("systemctl", "reload", config.ServiceName())
 	if err != nil || retCode != 0 {
 		err = errors.New("failed to hot-reload service. The console outputs are:\n%v", msg).WithErrors(err)
 		return
@@ -390,7 +391,7 @@ func systemdHotReload(ctx context.Context, config *Config, m *mgmtS, s *systemD)
 
 func systemdIsActive(config *Config) (text string, err error) {
 	var retCode int
-	retCode, text, err = cmdrexec.Sudo("systemctl", "is-active", config.ServiceName())
+	retCode, text, err = elevate("systemctl", "is-active", config.ServiceName())
 	if err != nil || retCode != 0 {
 		return
 	}
@@ -432,7 +433,7 @@ func systemdIsStarted(ctx context.Context, config *Config, m *mgmtS, s *systemD)
 func systemdIsEnabled(ctx context.Context, config *Config, m *mgmtS, s *systemD) (err error) {
 	var retCode int
 	var text string
-	retCode, text, err = cmdrexec.Sudo("systemctl", "is-enabled", config.ServiceName())
+	retCode, text, err = elevate("systemctl", "is-enabled", config.ServiceName())
 	if err != nil || retCode != 0 {
 		return
 	}
@@ -449,93 +450,25 @@ func systemdGetStatus(ctx context.Context, config *Config, m *mgmtS, s *systemD)
 	return
 }
 
-func createServiceFile(ctx context.Context, config *Config, svcfile, defaultDir string) (err error) {
-	var tmpl *template.Template
-	tmplFile := path.Join(config.TemplateDir, "share", "service.tpl")
-	if dir.FileExists(tmplFile) {
-		tmpl, err = template.New("service.file").ParseFiles(tmplFile)
-	} else {
-		tmpl, err = template.New("service.file").Parse(tplSystemdService)
-	}
-	if err != nil {
-		return
-	}
-	_, _, _ = ctx, config, svcfile
-
-	var of *os.File
-	tmpFile := path.Join(config.TempDir, config.ServiceName())
-	if err = dir.EnsureDir(path.Dir(tmpFile)); err != nil {
-		return
-	}
-	if of, err = os.Create(tmpFile); err != nil {
-		return
-	}
-
-	if config.Type == "" {
-		config.Type = "exec"
+// renderServiceFile renders the unit file by render.Render, with the
+// custom template <TemplateDir>/share/service.tpl if exists.
+func renderServiceFile(config *Config) (text string, err error) {
+	rc := config.RenderConfig()
+	var files map[string][]byte
+	if files, err = render.Render(render.Systemd, rc); err == nil {
+		text = string(files[render.ServiceFile(render.Systemd, rc)])
 	}
-
-	var execStartCmd, execStopCmd string
-	if config.ExecStartArgs != "" {
-		execStartCmd = fmt.Sprintf("%v $GLOBAL_OPTIONS %v $OPTIONS", config.ExecutablePath(), config.ExecStartArgs)
-	}
-	if config.ExecStopArgs != "" {
-		execStopCmd = fmt.Sprintf("%v $GLOBAL_OPTIONS %v $OPTIONS $MAINPID", config.ExecutablePath(), config.ExecStopArgs)
-	}
-
-	defer func() {
-		of.Close()
-		_, _, err = cmdrexec.Sudo("bash", "-c", fmt.Sprintf("mv %v %v", tmpFile, svcfile))
-		dir.DeleteFile(tmpFile)
-	}()
-	if err = tmpl.Execute(of, struct {
-		*Config
-		DefaultDir   string
-		ExecStartCmd string
-		ExecStopCmd  string
-	}{config, defaultDir, execStartCmd, execStopCmd}); err != nil {
-		return
-	}
-
 	return
 }
 
-func createDefaultFile(ctx context.Context, config *Config, file string) (err error) {
-	var tmpl *template.Template
-	tmplFile := path.Join(config.TemplateDir, "share", "default.tpl")
-	if dir.FileExists(tmplFile) {
-		tmpl, err = template.New("service.file").ParseFiles(tmplFile)
-	} else {
-		tmpl, err = template.New("service.file").Parse(tplEtcDefault)
-	}
-	if err != nil {
-		return
+// renderDefaultFile renders the env file by render.Render, with the
+// custom template <TemplateDir>/share/default.tpl if exists.
+func renderDefaultFile(config *Config) (text string, err error) {
+	rc := config.RenderConfig()
+	var files map[string][]byte
+	if files, err = render.Render(render.Systemd, rc); err == nil {
+		text = string(files[rc.EnvFilePath])
 	}
-	_, _, _ = ctx, config, file
-
-	var of *os.File
-	tmpFile := path.Join(config.TempDir, config.ServiceBareName())
-	if err = dir.EnsureDir(path.Dir(tmpFile)); err != nil {
-		return
-	}
-	if of, err = os.Create(tmpFile); err != nil {
-		return
-	}
-	// println(tmpFile, "created")
-
-	defer func() {
-		of.Close()
-		cmd := fmt.Sprintf("ls -la %v && mv %v %v && ls -la %v", tmpFile, tmpFile, file, file)
-		_, msg, _ := cmdrexec.Sudo("bash", "-c", cmd)
-		println(file, "created by", strconv.Quote(cmd))
-		// println(msg)
-		_ = msg
-		dir.DeleteFile(tmpFile)
-	}()
-	if err = tmpl.Execute(of, config); err != nil {
-		return
-	}
-
 	return
 }
 
@@ -558,12 +491,7 @@ func systemdInstall(ctx context.Context, config *Config, m *mgmtS, s *systemD) (
 	// cmdstore := cmdr.Store()
 	// forceReinstall := cmdstore.MustBool("server.install.force")
 
-	var defdir string
-	for _, defdir = range []string{defaultsDir, "/etc/default"} {
-		if dir.FileExists(defdir) {
-			break
-		}
-	}
+	envfile := config.EnvFilePath()
 
 	file := path.Join(systemdDir, config.ServiceName())
 	if dir.FileExists(file) {
@@ -584,51 +512,82 @@ If you wanna reinstall it, try this command line:
 		}
 	}
 
-	err = createServiceFile(ctx, config, file, defdir)
-	if err != nil {
+	if files, enabled := s.installedFiles(ctx, config); len(files) > 0 {
+		var bm BackupManifest
+		if bm, err = archiveInstallation(ctx, config, "reinstall", files, enabled); err != nil {
+			return
+		}
+		println("backup saved:", bm.Dir)
+	}
+
+	var tx *installTx
+	if tx, err = newInstallTx(ctx); err != nil {
 		return
 	}
+	var report InstallReport
+	report, err = tx.done(systemdInstallTx(ctx, config, tx, file, envfile))
+	println(report.String())
+	if err == nil {
+		s.Logger.Infof("Service created successfully.\n")
+		println("Service created successfully.")
+	}
+	return
+}
 
-	// env file
-	file = path.Join(defdir, config.ServiceBareName())
-	fileExist := dir.FileExists(file)
-	if fileExist && !config.ForceReinstall {
-		// //
-	} else {
-		err = createDefaultFile(ctx, config, file)
-		if err != nil {
-			dbglog.WarnContext(ctx, "something's wrong.", "err", err)
-			err = nil
-			return
-		}
+// systemdInstallTx provisions the user and the directories, writes the
+// unit file and the env file, refreshes systemd and enables the
+// service, all changes are recorded in tx.
+func systemdInstallTx(ctx context.Context, config *Config, tx *installTx, file, envfile string) (err error) {
+	if err = provisionUser(config, tx); err != nil {
+		return
+	}
+	if err = provisionDirs(config, tx, true); err != nil {
+		return
 	}
 
-	// refresh systemd
-	var retCode int
-	var msg string
-	retCode, msg, err = cmdrexec.Sudo("systemctl", "daemon-reload")
-	if err != nil || retCode != 0 {
-		err = errors.New("failed to refresh services list. The console outputs are:\n%v", msg).WithErrors(err)
+	var text string
+	if text, err = renderServiceFile(config); err != nil {
+		return
+	}
+	if err = tx.writeFile(file, []byte(text), 0o644); err != nil {
 		return
 	}
 
-	// // env file
-	// envfile := "/etc/sysconfig/" + config.ServiceName()
-	// retCode, msg, err = cmdrexec.Sudo("touch", envfile)
-	// if err != nil || retCode != 0 {
-	// 	err = errors.New("failed to touch env file %q. The console outputs are:\n%v", envfile, msg).WithErrors(err)
-	// 	return
-	// } else {
-	// 	println(envfile, "created")
-	// }
+	// env file, the existing one is kept unless reinstalling, and
+	// Config.Env is synced into it.
+	var ef *EnvFile
+	fresh := config.ForceReinstall || !dir.FileExists(envfile)
+	if fresh {
+		if text, err = renderDefaultFile(config); err != nil {
+			return
+		}
+		ef = ParseEnvFile([]byte(text))
+	} else if ef, err = LoadEnvFile(envfile); err != nil {
+		return
+	}
+	changed := fresh
+	for _, k := range sortedKeys(config.Env) {
+		if ef.Set(k, config.Env[k]) {
+			changed = true
+		}
+	}
+	if changed {
+		if err = tx.writeFile(envfile, ef.Bytes(), 0o644); err != nil {
+			return
+		}
+	}
 
-	if autoEnable := config.AutoEnable; autoEnable {
-		err = systemdEnable(ctx, config, m, s)
+	// refresh systemd, and again after the files restored at rollback
+	tx.atRollbackEnd("systemctl", "daemon-reload")
+	if err = tx.exec("daemon-reload", "systemd", []string{"systemctl", "daemon-reload"}, nil); err != nil {
+		return
 	}
 
-	if err == nil {
-		s.Logger.Infof("Service created successfully.\n")
-		println("Service created successfully.")
+	if config.AutoEnable {
+		if retCode, _, _ := elevate("systemctl", "is-enabled", config.ServiceName()); retCode != 0 {
+			unit := config.ServiceName()
+			err = tx.exec("enable", unit, []string{"systemctl", "enable", unit}, []string{"systemctl", "disable", unit})
+		}
 	}
 	return
 }
@@ -642,6 +601,13 @@ func systemdUninstall(ctx context.Context, config *Config, m *mgmtS, s *systemD)
 		return errors.Unavailable
 	}
 
+	// collected before disabling, to keep the enabled state
+	files, enabled := s.installedFiles(ctx, config)
+	if len(files) == 0 {
+		println("nothing needs to be done.")
+		return
+	}
+
 	if err = systemdStop(ctx, config, m, s); err != nil {
 		dbglog.WarnContext(ctx, "systemd stop command failed.", "err", err)
 		// return
@@ -652,60 +618,29 @@ func systemdUninstall(ctx context.Context, config *Config, m *mgmtS, s *systemD)
 		// return
 	}
 
-	anyExist := false
-	file := fmt.Sprintf("%s/%s", systemdDir, config.ServiceName())
-	if dir.FileExists(file) {
-		var retCode int
-		var msg string
-		retCode, msg, err = cmdrexec.Sudo("mv", file, os.TempDir())
-		if err != nil || retCode != 0 {
-			err = errors.New("failed to uninstall service. The console outputs are:\n%v", msg).WithErrors(err)
-			dbglog.WarnContext(ctx, "something's wrong.", "err", err)
-			err = nil
-			return
-		}
-		anyExist = true
+	// never remove anything not archived
+	var bm BackupManifest
+	if bm, err = archiveInstallation(ctx, config, "uninstall", files, enabled); err != nil {
+		err = errors.New("failed to back up the installation, nothing removed").WithErrors(err)
+		return
 	}
+	println("backup saved:", bm.Dir)
 
-	file = fmt.Sprintf("%s/%s", defaultsDir, config.Name)
-	if dir.FileExists(file) {
-		var retCode int
-		var msg string
-		retCode, msg, err = cmdrexec.Sudo("mv", file, os.TempDir())
-		if err != nil || retCode != 0 {
-			err = errors.New("failed to mv service file to trashbin. The console outputs are:\n%v", msg).WithErrors(err)
-			dbglog.WarnContext(ctx, "something's wrong.", "err", err)
-			err = nil
+	for _, file := range files {
+		if err = elevateE("rm", "-f", file); err != nil {
+			err = errors.New("failed to uninstall service").WithErrors(err)
 			return
 		}
-		anyExist = true
-	}
-
-	if !anyExist {
-		println("nothing needs to be done.")
-		return
 	}
+	_ = elevateE("rmdir", systemdDropInDir(config)) // if empty
 
 	// refresh systemd
 	var retCode int
-	retCode, _, err = cmdrexec.Sudo("systemctl", "daemon-reload")
+	retCode, _, err = elevate("systemctl", "daemon-reload")
 	if err != nil || retCode != 0 {
 		return
 	}
 
-	// env file
-	envfile := "/etc/sysconfig/" + config.ServiceName()
-	if dir.FileExists(envfile) {
-		var msg string
-		retCode, msg, err = cmdrexec.Sudo("rm", envfile)
-		if err != nil || retCode != 0 {
-			err = errors.New("failed to delete env file %q. The console outputs are:\n%v", envfile, msg).WithErrors(err)
-			return
-		} else {
-			println(envfile, "erased")
-		}
-	}
-
 	println("service uninstalled")
 	return
 }
@@ -722,7 +657,7 @@ func systemdEnable(ctx context.Context, config *Config, m *mgmtS, s *systemD) (e
 
 	var retCode int
 	var msg string
-	retCode, msg, err = cmdrexec.Sudo("systemctl", "enable", config.ServiceName())
+	retCode, msg, err = elevate("systemctl", "enable", config.ServiceName())
 	if err != nil || retCode != 0 {
 		err = errors.New("failed to enable service. The console outputs are:\n%v", msg).WithErrors(err)
 		return
@@ -744,7 +679,7 @@ func systemdDisable(ctx context.Context, config *Config, m *mgmtS, s *systemD) (
 
 	var retCode int
 	var msg string
-	retCode, msg, err = cmdrexec.Sudo("systemctl", "disable", config.ServiceName())
+	retCode, msg, err = elevate("systemctl", "disable", config.ServiceName())
 	if err != nil || retCode != 0 {
 		err = errors.New("failed to disable service. The console outputs are:\n%v", msg).WithErrors(err)
 		return
@@ -761,98 +696,143 @@ func systemdViewLog(ctx context.Context, config *Config, m *mgmtS, s *systemD) (
 	return
 }
 
-const (
-	tplEtcDefault = `### {{.ScreenName}} configurations
-### executable: {{.ExecutablePath}}
-
-# PORT=3211
-
-# OPTIONS="--port 3211"
-
-#
-# the service startup command line is like:
-#
-#	$ service-app [global-options] server start [options]
-#
-GLOBAL_OPTIONS=""
-OPTIONS=""
-
-`
-
-	// tplSystemService template file for creating a new systemd service.
-	//
-	//
-	//
-	//
-	// ref: to-d-o
-	tplSystemdService = `### {{.ScreenName}} services
-### {{.ServiceName}}
-### executable: {{.ExecutablePath}}
+// systemdDropInDir returns the directory of the drop-ins of the unit.
+func systemdDropInDir(config *Config) string {
+	return path.Join(systemdDir, config.ServiceName()+".d")
+}
 
-[Unit]
-Description={{.ScreenName}} Service for %i - {{.Desc}}
-# Documentation=man:sshd(8) man:sshd_config(5) man:{{.Name}}(1)
-After=network.target
-# Wants=syslog.service
-ConditionPathExists={{.ExecutablePath}}
+func (s *systemD) installedFiles(ctx context.Context, config *Config) (files []string, enabled bool) {
+	for _, file := range []string{path.Join(systemdDir, config.ServiceName()), config.EnvFilePath(), sysusersFile(config)} {
+		if dir.FileExists(file) {
+			files = append(files, file)
+		}
+	}
+	if entries, err := os.ReadDir(systemdDropInDir(config)); err == nil {
+		for _, ent := range entries {
+			if !ent.IsDir() && strings.HasSuffix(ent.Name(), ".conf") {
+				files = append(files, path.Join(systemdDropInDir(config), ent.Name()))
+			}
+		}
+	}
+	if len(files) > 0 {
+		retCode, _, _ := elevate("systemctl", "is-enabled", config.ServiceName())
+		enabled = retCode == 0
+	}
+	return
+}
 
-[Install]
-WantedBy=multi-user.target
+// afterRestore refreshes systemd, and enables the service if it was
+// enabled in the backup.
+func (s *systemD) afterRestore(ctx context.Context, config *Config, tx *installTx, bm BackupManifest) (err error) {
+	tx.atRollbackEnd("systemctl", "daemon-reload")
+	if err = tx.exec("daemon-reload", "systemd", []string{"systemctl", "daemon-reload"}, nil); err != nil {
+		return
+	}
+	unit := config.ServiceName()
+	if retCode, _, _ := elevate("systemctl", "is-enabled", unit); bm.Enabled && retCode != 0 {
+		err = tx.exec("enable", unit, []string{"systemctl", "enable", unit}, []string{"systemctl", "disable", unit})
+	}
+	return
+}
 
-[Service]
-Type={{.Type}}
-{{if .User}}User={{.User}}{{else}}# User=%i{{end}}
-{{if .Group}}Group={{.Group}}{{else}}# Group=%i{{end}}
-LimitNOFILE=65535
-{{if .TimeoutStartSec}}TimeoutStartSec={{.TimeoutStartSec}}{{else}}TimeoutStartSec=60s{{end}}
-{{if .TimeoutStopSec}}TimeoutStopSec={{.TimeoutStopSec}}{{else}}TimeoutStopSec=60s{{end}}
-{{if .PIDFile}}PIDFile={{.PIDFile}}{{else}}PIDFile=/run/{{.Name}}/{{.Name}}.pid{{end}}
+// installTarget writes the umbrella target of the group and enables
+// it, the services of the group are PartOf= the target.
+func (s *systemD) installTarget(ctx context.Context, g *ServiceGroup) (err error) {
+	if !hasSystemd(ctx) {
+		return errors.Unavailable
+	}
 
-KillMode=process
-Restart=on-failure
-{{if .RestartSec}}RestartSec={{.RestartSec}}{{else}}RestartSec=23s{{end}}
-# RestartLimitIntervalSec=60
+	var text string
+	if text, err = renderSystemdTarget(g); err != nil {
+		return
+	}
 
-EnvironmentFile={{.DefaultDir}}/{{.Name}}
-{{range $k, $v := .Env -}}
-Environment={{$k}}={{$v}}
-{{end -}}
+	var f *os.File
+	if f, err = os.CreateTemp("", g.TargetName()+".*"); err != nil {
+		return
+	}
+	defer os.Remove(f.Name())
+	_, err = f.WriteString(text)
+	if e := f.Close(); err == nil {
+		err = e
+	}
+	if err != nil {
+		return
+	}
 
-{{if .WorkDir}}WorkingDirectory={{.WorkDir}}{{else}}WorkingDirectory=%h{{end}}
+	file := path.Join(systemdDir, g.TargetName())
+	if err = elevateE("install", "-m", "0644", f.Name(), file); err == nil {
+		if err = elevateE("systemctl", "daemon-reload"); err == nil {
+			err = elevateE("systemctl", "enable", g.TargetName())
+		}
+	}
+	if err != nil {
+		err = errors.New("failed to install target %q", g.TargetName()).WithErrors(err)
+		return
+	}
+	println(file, "created")
+	return
+}
 
-#          start: --addr, --port,
-#           todo: --pid
-# global options: --verbose, --debug,
-{{if .ExecStartCmd}}ExecStart={{.ExecStartCmd}}{{else}}ExecStart={{.ExecutablePath}} $GLOBAL_OPTIONS server start -foreground -service $OPTIONS{{end}}
-#           stop: -1/--hup, -9/--kill,
-### TODO ExecStop={{.ExecutablePath}} $GLOBAL_OPTIONS server stop -1
-### TODO ExecReload=/bin/kill -HUP $MAINPID
-{{if .ExecStopCmd}}ExecStop={{.ExecStopCmd}}{{else}}ExecStop={{.ExecutablePath}} $GLOBAL_OPTIONS server stop -3 $MAINPID{{end}}
-ExecReload={{.ExecutablePath}} $GLOBAL_OPTIONS server restart
+func (s *systemD) uninstallTarget(ctx context.Context, g *ServiceGroup) (err error) {
+	file := path.Join(systemdDir, g.TargetName())
+	if !dir.FileExists(file) {
+		return
+	}
 
-# # make sure log directory exists and owned by syslog
-#PermissionsStartOnly=true
-ExecStartPre=-/bin/mkdir /run/{{.Name}}
-ExecStartPre=-/bin/mkdir /var/lib/{{.Name}}
-ExecStartPre=-/bin/mkdir /var/log/{{.Name}}
-ExecStartPre=-/bin/chown -R %i: /var/run/{{.Name}} /var/lib/{{.Name}}
-# ExecStartPre=-/bin/chown -R syslog:adm /var/log/{{.Name}}
-ExecStartPre=-/bin/chown -R %i: /var/log/{{.Name}}
+	retCode, _, _ := elevate("systemctl", "is-enabled", g.TargetName())
+	_, _, _ = elevate("systemctl", "disable", g.TargetName())
 
-# # enable coredump
-# ExecStartPre=ulimit -c unlimited
+	// never remove anything not archived
+	var bm BackupManifest
+	if bm, err = archiveInstallation(ctx, &Config{Name: g.Name}, "uninstall", []string{file}, retCode == 0); err != nil {
+		err = errors.New("failed to back up target %q, nothing removed", g.TargetName()).WithErrors(err)
+		return
+	}
+	println("backup saved:", bm.Dir)
 
-SyslogIdentifier={{.Name}}
-StandardOutput=append:{{.StandardOutPath}}
-StandardError=append:{{.StandardErrorPath}}
+	if err = elevateE("rm", "-f", file); err == nil {
+		err = elevateE("systemctl", "daemon-reload")
+	}
+	if err != nil {
+		err = errors.New("failed to uninstall target %q", g.TargetName()).WithErrors(err)
+		return
+	}
+	println(file, "erased")
+	return
+}
 
+func renderSystemdTarget(g *ServiceGroup) (text string, err error) {
+	var tmpl *template.Template
+	if tmpl, err = template.New("target.file").Parse(tplSystemdTarget); err != nil {
+		return
+	}
+	desc := g.Description
+	if desc == "" {
+		desc = g.Name + " services"
+	}
+	var sb strings.Builder
+	err = tmpl.Execute(&sb, struct {
+		Name        string
+		Description string
+		Units       []string
+	}{g.Name, desc, g.Units()})
+	text = sb.String()
+	return
+}
 
+const (
+	// tplSystemdTarget is the umbrella target of a ServiceGroup.
+	tplSystemdTarget = `### {{.Name}} service group
 
+[Unit]
+Description={{.Description}}
+Wants={{range $i, $u := .Units}}{{if $i}} {{end}}{{$u}}{{end}}
+After={{range $i, $u := .Units}}{{if $i}} {{end}}{{$u}}{{end}}
 
+[Install]
+WantedBy=multi-user.target
 `
 
 	systemdDir = "/etc/systemd/system"
-
-	defaultsDir = "/etc/sysconfig"
-	// defaultsDir = "/etc/default"
 )
@@ -4,16 +4,14 @@
 package service
 
 import (
-	"context"
 	"os"
+	"strings"
 	"testing"
 
 	"github.com/hedzr/is/dir"
 )
 
 func TestServiceFile(t *testing.T) {
-	ctx := context.Background()
-	file := "/tmp/1.service"
 	config := &Config{
 		Name:           "123",
 		DisplayName:    "123 Tool",
@@ -28,24 +26,50 @@ func TestServiceFile(t *testing.T) {
 
 		TempDir: os.TempDir(),
 	}
-	err := createServiceFile(ctx, config, file, "/etc/default")
+	gen, err := renderServiceFile(config)
 	if err != nil {
 		t.Fatal(err)
 	}
 
 	tstfile := "./testdata/123.service"
 	if dir.FileExists(tstfile) {
-		gen, err := os.ReadFile(file)
-		if err != nil {
-			t.Fatal(err)
-		}
 		tst, err := os.ReadFile(tstfile)
 		if err != nil {
 			t.Fatal(err)
 		}
 
-		if string(tst) != string(gen) {
+		if string(tst) != gen {
 			t.Fatalf("generated service file content is not ok.")
 		}
 	}
 }
+
+func TestSystemdTarget(t *testing.T) {
+	g, err := (&mgmtS{}).Group("product", &Config{Name: "api", Dependencies: []string{"db"}}, &Config{Name: "db"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	text, err := renderSystemdTarget(g)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(text, "Wants=db.service api.service\n") || !strings.Contains(text, "WantedBy=multi-user.target") {
+		t.Fatalf("bad target unit:\n%s", text)
+	}
+}
+
+func TestServiceFile_directories(t *testing.T) {
+	config := &Config{Name: "demo", Executable: "/bin/sh", User: "demo", LogDir: "/var/log/demo", RunDir: "/var/run"}
+	text, err := renderServiceFile(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{"User=demo\n", "StateDirectory=demo\n", "LogsDirectory=demo\n", "RuntimeDirectory=demo\n", "PIDFile=/var/run/demo/demo.pid\n"} {
+		if !strings.Contains(text, want) {
+			t.Fatalf("expect %q in:\n%s", want, text)
+		}
+	}
+	if strings.Contains(text, "\nExecStartPre=") {
+		t.Fatalf("unexpected ExecStartPre in:\n%s", text)
+	}
+}
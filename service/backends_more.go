@@ -11,6 +11,8 @@ import (
 
 type upstartD struct{}
 
+func (s *upstartD) String() string { return "upstart" }
+
 func (s *upstartD) Choose(ctx context.Context) (ok bool) {
 	if systems.HasLinuxBackends {
 		ok = hasUpstart(ctx)
@@ -40,6 +42,8 @@ func (s *upstartD) Control(ctx context.Context, config *Config, m *mgmtS, cmd Co
 
 type sysvInitD struct{}
 
+func (s *sysvInitD) String() string { return "sysvinit" }
+
 func (s *sysvInitD) Choose(ctx context.Context) (ok bool) {
 	if systems.HasLinuxBackends {
 		ok = hasSysvInitD(ctx)
@@ -0,0 +1,231 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/hedzr/is/dir"
+	"gopkg.in/hedzr/errors.v3"
+
+	"github.com/hedzr/cmdr-addons/v2/tool/dbglog"
+)
+
+// BackupManifest describes a backup of an installation, it is saved as
+// manifest.json in the backup directory.
+type BackupManifest struct {
+	Version string       `json:"version"`
+	Service string       `json:"service"`
+	Reason  string       `json:"reason"` // "uninstall", "reinstall" or "restore"
+	Time    time.Time    `json:"time"`
+	Enabled bool         `json:"enabled"` // the service was enabled
+	Files   []BackupFile `json:"files"`
+
+	Dir string `json:"-"` // the backup directory
+}
+
+// BackupFile is a file archived in a backup.
+type BackupFile struct {
+	Path string      `json:"path"` // the installed file
+	Name string      `json:"name"` // the file in the backup directory
+	Mode os.FileMode `json:"mode"`
+	Size int64       `json:"size"`
+}
+
+func (bm BackupManifest) String() string {
+	enabled := ""
+	if bm.Enabled {
+		enabled = ", enabled"
+	}
+	return fmt.Sprintf("%s  %s  %s, %d file(s)%s", bm.Version, bm.Time.Local().Format(time.DateTime), bm.Reason, len(bm.Files), enabled)
+}
+
+// installationBackuper is implemented by the backends which install
+// files, so that the installations can be archived and restored.
+type installationBackuper interface {
+	// installedFiles returns the files of the installation which exist,
+	// such as the unit file, its drop-ins and the env file.
+	installedFiles(ctx context.Context, config *Config) (files []string, enabled bool)
+	// afterRestore refreshes the init system after the files restored.
+	afterRestore(ctx context.Context, config *Config, tx *installTx, bm BackupManifest) (err error)
+}
+
+const backupManifestFile = "manifest.json"
+
+// BackupDirPath returns the directory of the backups of this service, it
+// is BackupDir if specified, or else /var/backups/<name>.
+func (e *Config) BackupDirPath() string {
+	if e.BackupDir != "" {
+		return e.BackupDir
+	}
+	return path.Join("/var/backups", e.BaseName())
+}
+
+// archiveInstallation copies files into a new versioned backup
+// directory with a manifest.
+func archiveInstallation(ctx context.Context, config *Config, reason string, files []string, enabled bool) (bm BackupManifest, err error) {
+	bm = BackupManifest{Service: config.ServiceName(), Reason: reason, Time: time.Now().UTC(), Enabled: enabled}
+	bm.Version = bm.Time.Format("20060102T150405Z")
+	bm.Dir = path.Join(config.BackupDirPath(), bm.Version)
+	for i := 1; dir.FileExists(bm.Dir); i++ {
+		bm.Version = fmt.Sprintf("%s-%d", bm.Time.Format("20060102T150405Z"), i)
+		bm.Dir = path.Join(config.BackupDirPath(), bm.Version)
+	}
+
+	if err = elevateE("mkdir", "-p", bm.Dir); err != nil {
+		return
+	}
+	for i, file := range files {
+		info, e := os.Stat(file)
+		if e != nil {
+			continue
+		}
+		bf := BackupFile{Path: file, Name: fmt.Sprintf("%d-%s", i, path.Base(file)), Mode: info.Mode().Perm(), Size: info.Size()}
+		if err = elevateE("cp", "-p", file, path.Join(bm.Dir, bf.Name)); err != nil {
+			return
+		}
+		bm.Files = append(bm.Files, bf)
+	}
+
+	var data []byte
+	if data, err = json.MarshalIndent(bm, "", "  "); err != nil {
+		return
+	}
+	tmpFile := path.Join(os.TempDir(), fmt.Sprintf("%s-%s.json", config.BaseName(), bm.Version))
+	if err = os.WriteFile(tmpFile, data, 0o600); err != nil {
+		return
+	}
+	defer dir.DeleteFile(tmpFile)
+	if err = elevateE("install", "-m", "0644", tmpFile, path.Join(bm.Dir, backupManifestFile)); err != nil {
+		return
+	}
+	dbglog.InfoContext(ctx, "[backup] installation archived", "dir", bm.Dir, "reason", reason, "files", len(bm.Files))
+	return
+}
+
+// LoadBackups returns the backups of the service, the newest first.
+func LoadBackups(config *Config) (backups []BackupManifest, err error) {
+	var entries []os.DirEntry
+	if entries, err = os.ReadDir(config.BackupDirPath()); err != nil {
+		if os.IsNotExist(err) {
+			err = nil
+		}
+		return
+	}
+	for _, ent := range entries {
+		if !ent.IsDir() {
+			continue
+		}
+		d := path.Join(config.BackupDirPath(), ent.Name())
+		data, e := os.ReadFile(path.Join(d, backupManifestFile))
+		if e != nil {
+			continue
+		}
+		var bm BackupManifest
+		if e = json.Unmarshal(data, &bm); e != nil {
+			dbglog.Warn("[backup] bad manifest", "dir", d, "err", e)
+			continue
+		}
+		bm.Dir = d
+		backups = append(backups, bm)
+	}
+	sort.Slice(backups, func(i, j int) bool { return backups[i].Time.After(backups[j].Time) })
+	return
+}
+
+// restoreInstallation brings the backup version back, or the newest one
+// if version is empty. The current installation is archived first, and
+// all changes are rolled back if any step failed.
+func restoreInstallation(ctx context.Context, config *Config, ib installationBackuper, version string) (report InstallReport, err error) {
+	var backups []BackupManifest
+	if backups, err = LoadBackups(config); err != nil {
+		return
+	}
+	var bm *BackupManifest
+	for i := range backups {
+		if version == "" || backups[i].Version == version {
+			bm = &backups[i]
+			break
+		}
+	}
+	if bm == nil {
+		if version == "" {
+			err = errors.New("no backups in %q", config.BackupDirPath())
+		} else {
+			err = errors.New("backup %q not found in %q", version, config.BackupDirPath())
+		}
+		return
+	}
+
+	if files, enabled := ib.installedFiles(ctx, config); len(files) > 0 {
+		var cur BackupManifest
+		if cur, err = archiveInstallation(ctx, config, "restore", files, enabled); err != nil {
+			return
+		}
+		println("current installation saved:", cur.Dir)
+	}
+
+	var tx *installTx
+	if tx, err = newInstallTx(ctx); err != nil {
+		return
+	}
+	return tx.done(func() (err error) {
+		for _, bf := range bm.Files {
+			if err = tx.copyFile(path.Join(bm.Dir, bf.Name), bf.Path, bf.Mode); err != nil {
+				return
+			}
+		}
+		return ib.afterRestore(ctx, config, tx, *bm)
+	}())
+}
+
+// backups implements the Restore and ListBackups commands. Restore
+// takes the version from Config.PositionalArgs, the newest backup is
+// restored if not specified.
+func (s *mgmtS) backups(ctx context.Context, config *Config, be Backend, cmd Command) (err error) {
+	if cmd == ListBackups {
+		var backups []BackupManifest
+		if backups, err = LoadBackups(config); err != nil {
+			return
+		}
+		if len(backups) == 0 {
+			println("no backups in", config.BackupDirPath())
+		}
+		for _, bm := range backups {
+			println(bm.String())
+		}
+		return
+	}
+
+	ib, ok := be.(installationBackuper)
+	if !ok {
+		return errors.New("restoring is not supported by backend %v", be)
+	}
+	var version string
+	if len(config.PositionalArgs) > 0 {
+		version = strings.TrimSpace(config.PositionalArgs[0])
+	}
+	var report InstallReport
+	report, err = restoreInstallation(ctx, config, ib, version)
+	if len(report.Applied) > 0 || report.Err != nil {
+		println(report.String())
+	}
+	if err == nil {
+		println("service restored.")
+	}
+	return
+}
+
+// elevateE runs cmd by elevate, a non-zero exit code is an error.
+func elevateE(cmd string, args ...string) (err error) {
+	retCode, msg, err := elevate(cmd, args...)
+	if err == nil && retCode != 0 {
+		err = errors.New("%s failed (exit code %d): %s", strings.Join(append([]string{cmd}, args...), " "), retCode, strings.TrimSpace(msg))
+	}
+	return
+}
@@ -0,0 +1,97 @@
+//go:build !windows && !plan9 && !js
+// +build !windows,!plan9,!js
+
+package service
+
+import (
+	"context"
+	"os"
+	"path"
+	"testing"
+)
+
+// fakeInstallation is an installationBackuper of the files in a
+// directory.
+type fakeInstallation struct {
+	files    []string
+	enabled  bool
+	restored int
+}
+
+func (f *fakeInstallation) installedFiles(ctx context.Context, config *Config) (files []string, enabled bool) {
+	for _, file := range f.files {
+		if _, err := os.Stat(file); err == nil {
+			files = append(files, file)
+		}
+	}
+	return files, f.enabled
+}
+
+func (f *fakeInstallation) afterRestore(ctx context.Context, config *Config, tx *installTx, bm BackupManifest) (err error) {
+	f.restored++
+	f.enabled = bm.Enabled
+	return
+}
+
+func TestBackups(t *testing.T) {
+	SetElevator(rootElevator{})
+	defer SetElevator(nil)
+
+	root := t.TempDir()
+	config := &Config{Name: "demo", BackupDir: path.Join(root, "backups")}
+	unit, env := path.Join(root, "demo.service"), path.Join(root, "demo.env")
+	if err := os.WriteFile(unit, []byte("v1"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(env, []byte("A=1"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	fi := &fakeInstallation{files: []string{unit, env}, enabled: true}
+
+	files, enabled := fi.installedFiles(ctx, config)
+	bm, err := archiveInstallation(ctx, config, "uninstall", files, enabled)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(bm.Files) != 2 || bm.Files[1].Mode != 0o600 {
+		t.Fatalf("bad manifest: %+v", bm)
+	}
+
+	// uninstalled
+	for _, f := range fi.files {
+		_ = os.Remove(f)
+	}
+	fi.enabled = false
+
+	if _, err = restoreInstallation(ctx, config, fi, "nope"); err == nil {
+		t.Fatal("expect an error for unknown version")
+	}
+	if _, err = restoreInstallation(ctx, config, fi, ""); err != nil {
+		t.Fatal(err)
+	}
+	if data, _ := os.ReadFile(unit); string(data) != "v1" || fi.restored != 1 || !fi.enabled {
+		t.Fatalf("not restored: %q, restored = %d, enabled = %v", data, fi.restored, fi.enabled)
+	}
+	if info, e := os.Stat(env); e != nil || info.Mode().Perm() != 0o600 {
+		t.Fatalf("bad env file: %v, %v", info, e)
+	}
+
+	// the restoring archived the current installation too
+	if err = os.WriteFile(unit, []byte("v2"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = restoreInstallation(ctx, config, fi, bm.Version); err != nil {
+		t.Fatal(err)
+	}
+	backups, err := LoadBackups(config)
+	if err != nil || len(backups) != 2 {
+		t.Fatalf("expect 2 backups, got %v, %v", backups, err)
+	}
+	if backups[0].Reason != "restore" || backups[1].Version != bm.Version {
+		t.Fatalf("bad order: %v", backups)
+	}
+	if data, _ := os.ReadFile(unit); string(data) != "v1" {
+		t.Fatalf("not restored: %q", data)
+	}
+}
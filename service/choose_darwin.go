@@ -15,6 +15,10 @@ func ChooseBackend(ctx context.Context) (be Backend, err error) {
 	return
 }
 
+// fallbackBackend returns nil since the native service manager is
+// always available.
+func fallbackBackend(ctx context.Context) Backend { return nil }
+
 var (
 	choosers = []Chooser{
 		&launchD{},
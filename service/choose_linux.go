@@ -15,6 +15,10 @@ func ChooseBackend(ctx context.Context) (be Backend, err error) {
 	return
 }
 
+// fallbackBackend is used when no init system is detected, such as
+// in minimal containers or Termux.
+func fallbackBackend(ctx context.Context) Backend { return &daemonD{} }
+
 var (
 	choosers = []Chooser{
 		&systemD{},
@@ -0,0 +1,155 @@
+package main
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"sort"
+	"strings"
+
+	"gopkg.in/hedzr/errors.v3"
+
+	"github.com/hedzr/cmdr-addons/service/v2"
+)
+
+// configExts are the formats of the config files, in lookup order.
+var configExts = []string{".yaml", ".yml", ".toml", ".json"}
+
+// configDir keeps the config files of the installed services,
+// $SVCCTL_DIR if specified.
+func configDir() string {
+	if d := os.Getenv("SVCCTL_DIR"); d != "" {
+		return d
+	}
+	if runtime.GOOS == "windows" {
+		return filepath.Join(os.Getenv("ProgramData"), "svcctl")
+	}
+	return "/etc/svcctl"
+}
+
+// findConfig returns the config file of the installed service name.
+func findConfig(name string) (file string, err error) {
+	for _, ext := range configExts {
+		file = filepath.Join(configDir(), name+ext)
+		if _, err = os.Stat(file); err == nil {
+			return
+		}
+	}
+	return "", errors.New("service %q is not installed by svcctl, no config file in %s", name, configDir())
+}
+
+// installedPath returns the config file of the service name in the
+// config directory, in the format of file.
+func installedPath(name, file string) string {
+	ext := strings.ToLower(filepath.Ext(file))
+	if ext == "" {
+		ext = ".yaml"
+	}
+	return filepath.Join(configDir(), name+ext)
+}
+
+// loadConfig loads the config file with its host override, see
+// service.LoadConfig. The name defaults to the base name of file, and
+// the working directory to the directory of the program.
+func loadConfig(file string) (config *service.Config, err error) {
+	if config, err = service.LoadConfig(file, service.HostConfigPath(file)); err != nil {
+		return
+	}
+	if config.Name == "" {
+		config.Name = strings.TrimSuffix(filepath.Base(file), filepath.Ext(file))
+	}
+	if config.Executable == "" {
+		return nil, errors.New("%s: executable is required, it is the program run by the service", file)
+	}
+	if config.WorkDir == "" {
+		config.WorkDir = filepath.Dir(config.Executable)
+	}
+	return
+}
+
+// checkProgram checks the program to be run by the service.
+func checkProgram(file string) (err error) {
+	info, err := os.Stat(file)
+	if err != nil {
+		return errors.New("the program %q: %v", file, err)
+	}
+	if info.IsDir() || runtime.GOOS != "windows" && info.Mode().Perm()&0o111 == 0 {
+		return errors.New("the program %q is not an executable file", file)
+	}
+	return
+}
+
+// saveConfig writes config into file, by the elevator if we have no
+// permission.
+func saveConfig(config *service.Config, file string) (err error) {
+	if err = os.MkdirAll(filepath.Dir(file), 0o755); err == nil {
+		if err = config.Save(file); err == nil || !isPermission(err) {
+			return
+		}
+	} else if !isPermission(err) {
+		return
+	}
+
+	tmp := filepath.Join(os.TempDir(), fmt.Sprintf("svcctl-%d-%s", os.Getpid(), filepath.Base(file)))
+	if err = config.Save(tmp); err != nil {
+		return
+	}
+	defer os.Remove(tmp)
+	return elevated("sh", "-c", `mkdir -p "$(dirname "$2")" && cp "$1" "$2" && chmod 0644 "$2"`, "svcctl", tmp, file)
+}
+
+// removeConfig removes the config file of an uninstalled service.
+func removeConfig(file string) (err error) {
+	if err = os.Remove(file); err != nil && isPermission(err) {
+		return elevated("rm", "-f", file)
+	}
+	if errors.Is(err, fs.ErrNotExist) {
+		err = nil
+	}
+	return
+}
+
+func isPermission(err error) bool { return errors.Is(err, fs.ErrPermission) }
+
+// elevated runs cmd as root by the elevator of the service package,
+// it is recorded in the audit entry of the running operation.
+func elevated(cmd string, args ...string) (err error) {
+	retCode, msg, err := service.Elevate(cmd, args...)
+	if err == nil && retCode != 0 {
+		err = errors.New("%s failed (exit code %d): %s", cmd, retCode, strings.TrimSpace(msg))
+	}
+	return
+}
+
+// list prints the services installed by svcctl.
+func list() (err error) {
+	entries, err := os.ReadDir(configDir())
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			err = nil
+		}
+		return
+	}
+	var names []string
+	for _, ent := range entries {
+		ext := filepath.Ext(ent.Name())
+		hostOverride := strings.HasSuffix(ent.Name(), strings.TrimPrefix(service.HostConfigPath(ext), ext))
+		if !ent.IsDir() && slices.Contains(configExts, ext) && !hostOverride {
+			names = append(names, ent.Name())
+		}
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		file := filepath.Join(configDir(), name)
+		config, e := service.LoadConfig(file)
+		if e != nil {
+			fmt.Printf("%-24s  (%v)\n", name, e)
+			continue
+		}
+		fmt.Printf("%-24s  %s %s\n", strings.TrimSuffix(name, filepath.Ext(name)), config.Executable, config.ExecStartArgs)
+	}
+	return
+}
@@ -0,0 +1,240 @@
+// svcctl installs and manages any executable as a system service, by
+// a config file of service.Config in yaml, toml or json:
+//
+//	name: myapp
+//	description: my app
+//	executable: /usr/local/bin/myapp # the program to run
+//	exec_start_args: --port 8080     # and its arguments
+//	user: myapp
+//
+// Usage:
+//
+//	svcctl install -f myapp.yaml
+//	svcctl status myapp
+//	svcctl logs -f myapp
+//
+// The config file is kept in /etc/svcctl (or $SVCCTL_DIR) at installing,
+// and the service manager runs "svcctl run -f /etc/svcctl/myapp.yaml",
+// which runs the program as its child and supervises it.
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+
+	"github.com/hedzr/is"
+	"gopkg.in/hedzr/errors.v3"
+
+	"github.com/hedzr/cmdr-addons/service/v2"
+)
+
+func main() {
+	flag.Usage = usage
+	flag.BoolVar(&debug, "debug", false, "debug mode")
+	flag.Parse()
+	if flag.NArg() < 1 {
+		usage()
+		os.Exit(2)
+	}
+	if debug {
+		is.SetDebugMode(true)
+	}
+
+	if err := run(context.Background(), flag.Arg(0), flag.Args()[1:]); err != nil {
+		fmt.Fprintf(os.Stderr, "svcctl: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+var debug bool
+
+func usage() {
+	fmt.Fprint(os.Stderr, `svcctl - install and manage any executable as a service
+
+Usage: svcctl [-debug] COMMAND [options] [NAME] [args...]
+
+Commands:
+  install -f FILE [-force]   install the service described by FILE
+  uninstall NAME             uninstall the service and remove its config
+  start|stop|restart NAME    control the service
+  reload NAME                hot-reload the service, SIGHUP the program
+  status NAME                show the status of the service
+  enable|disable NAME        enable or disable the service at boot
+  logs [-f] [-n N] NAME      show the logs of the service
+  health NAME                check the health probes of the service
+  verify NAME|-f FILE        validate the config of the service
+  audit NAME [N]             list the recent operations of the service
+  backups NAME               list the backups of the installation
+  restore NAME [VERSION]     restore a backup of the installation
+  list                       list the services installed by svcctl
+
+NAME is looked up in the config directory, `+configDir()+`, or
+specify the config file by -f FILE instead.
+`)
+}
+
+// commands are the svcctl commands run by service.Manager.Control.
+var commands = map[string]service.Command{
+	"install":   service.Install,
+	"uninstall": service.Uninstall,
+	"start":     service.Start,
+	"stop":      service.Stop,
+	"restart":   service.Restart,
+	"reload":    service.HotReload,
+	"status":    service.Status,
+	"enable":    service.Enable,
+	"disable":   service.Disable,
+	"logs":      service.ViewLog,
+	"health":    service.Health,
+	"audit":     service.AuditLog,
+	"backups":   service.ListBackups,
+	"restore":   service.Restore,
+	"run":       service.Start,
+}
+
+type options struct {
+	file        string
+	force       bool
+	serviceMode bool
+	follow      bool
+	lines       int
+}
+
+func run(ctx context.Context, name string, args []string) (err error) {
+	var opts options
+	fs := flag.NewFlagSet("svcctl "+name, flag.ExitOnError)
+	if name == "logs" {
+		fs.BoolVar(&opts.follow, "f", false, "follow the logs")
+		fs.IntVar(&opts.lines, "n", 50, "show the last `N` lines")
+	} else {
+		fs.StringVar(&opts.file, "f", "", "the config `FILE` of the service")
+	}
+	switch name {
+	case "install":
+		fs.BoolVar(&opts.force, "force", false, "reinstall it if installed already")
+	case "stop":
+		fs.BoolVar(&opts.serviceMode, "service", false, "stop the service process, used by the service manager")
+	}
+	if err = fs.Parse(args); err != nil {
+		return
+	}
+	rest := fs.Args()
+
+	if name == "list" || name == "ls" {
+		return list()
+	}
+	cmd, ok := commands[name]
+	if !ok && name != "verify" {
+		usage()
+		return errors.New("unknown command %q", name)
+	}
+	if opts.file == "" {
+		if len(rest) == 0 {
+			return errors.New("expect the NAME of the service, or -f FILE")
+		}
+		if name == "install" {
+			return errors.New("expect the config file by -f FILE")
+		}
+		if opts.file, err = findConfig(rest[0]); err != nil {
+			return
+		}
+		rest = rest[1:]
+	}
+
+	var config *service.Config
+	if config, err = loadConfig(opts.file); err != nil {
+		return
+	}
+	config.PositionalArgs = rest
+	if name == "verify" {
+		return verify(ctx, config)
+	}
+	if name == "install" {
+		return install(ctx, config, opts)
+	}
+
+	p := wrap(config, opts.file)
+	p.follow, p.lines = opts.follow, opts.lines
+	if name == "logs" && opts.follow {
+		var cancel context.CancelFunc
+		ctx, cancel = signal.NotifyContext(ctx, os.Interrupt)
+		defer cancel()
+	}
+
+	m := service.New(ctx)
+	switch name {
+	case "run":
+		m.SetForegroundMode(true)
+		m.SetServiceMode(true)
+	case "stop":
+		m.SetServiceMode(opts.serviceMode)
+	}
+	if name == "uninstall" {
+		// removed in the operation, so it is in the same audit entry
+		var removeErr error
+		m.OnEvent(func(ctx context.Context, ev service.Event) (err error) {
+			if ev.Kind == service.AfterUninstall {
+				removeErr = removeConfig(opts.file)
+				err = removeErr
+			}
+			return
+		})
+		defer func() {
+			if err == nil {
+				err = removeErr
+			}
+		}()
+	}
+	err = m.Control(ctx, config, cmd)
+	return
+}
+
+// install saves the config into the config directory, and installs
+// the service running "svcctl run" with it.
+func install(ctx context.Context, config *service.Config, opts options) (err error) {
+	if err = checkProgram(config.Executable); err != nil {
+		return
+	}
+	installed := installedPath(config.Name, opts.file)
+	_, e := os.Stat(installed)
+	existed := e == nil
+	if existed && !opts.force && installed != opts.file {
+		return errors.New("service %q is installed already by %s, use -force to reinstall it", config.Name, installed)
+	}
+
+	// the config file is saved in the operation, so the commands run
+	// by the elevator are in its audit entry.
+	saved := *config
+	m := service.New(ctx)
+	m.OnEvent(func(ctx context.Context, ev service.Event) (err error) {
+		switch {
+		case ev.Kind == service.BeforeInstall:
+			err = saveConfig(&saved, installed)
+		case ev.Kind == service.Failed && ev.Command == service.Install && !existed:
+			if e := removeConfig(installed); e != nil {
+				fmt.Fprintf(os.Stderr, "svcctl: cannot remove %s: %v\n", installed, e)
+			}
+		}
+		return
+	})
+
+	wrap(config, installed)
+	config.ForceReinstall = config.ForceReinstall || opts.force
+	return m.Control(ctx, config, service.Install)
+}
+
+// verify validates the config before wrapping, so the program is
+// checked as the executable.
+func verify(ctx context.Context, config *service.Config) (err error) {
+	if err = checkProgram(config.Executable); err != nil {
+		return
+	}
+	if err = config.Validate(ctx); err != nil {
+		return
+	}
+	fmt.Printf("the config of %s is valid.\n", config.ServiceName())
+	return
+}
@@ -0,0 +1,190 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"os"
+	"os/exec"
+	"runtime"
+	"strconv"
+	"sync"
+	"syscall"
+	"time"
+
+	"gopkg.in/hedzr/errors.v3"
+
+	"github.com/hedzr/cmdr-addons/service/v2"
+)
+
+// program is the entity of a wrapped executable. The service process,
+// "svcctl run", runs it as a child process.
+type program struct {
+	name   string
+	desc   string
+	path   string
+	args   []string
+	follow bool // for ViewLog
+	lines  int
+
+	mu          sync.Mutex
+	proc        *os.Process
+	serviceMode bool
+}
+
+// wrap makes config run the program by "svcctl run -f file", the
+// executable of config is the program to be wrapped.
+func wrap(config *service.Config, file string) (p *program) {
+	p = &program{
+		name: config.Name,
+		desc: config.Description,
+		path: config.Executable,
+		args: service.SplitArgs(config.ExecStartArgs),
+	}
+	self, err := os.Executable()
+	if err != nil {
+		self = os.Args[0]
+	}
+	config.Entity = p
+	config.Executable = self
+	config.ExecStartArgs = service.JoinArgs("run", "-f", file)
+	config.ExecStopArgs = service.JoinArgs("stop", "-service", "-f", file)
+	config.ArgsForInstall = []string{self, "run", "-f", file}
+	return
+}
+
+func (p *program) Name() string           { return p.name }
+func (p *program) Desc() string           { return p.desc }
+func (p *program) ScreenName() string     { return p.name }
+func (p *program) ServiceName() string    { return p.name }
+func (p *program) ExecutablePath() string { return p.path }
+func (p *program) SetServiceMode(b bool)  { p.serviceMode = b }
+
+// Run runs the program till it exits, or ctx is cancelled and it is
+// stopped by service.StopProcess with the stop signals of config. The
+// supervisor restarts it by Config.Supervisor.
+//
+// The program is the service, so its exit by itself is always an
+// error, even with status 0: the service ends as a failure and the
+// service manager or the supervisor restarts it.
+func (p *program) Run(ctx context.Context, config *service.Config, logger service.Logger) (err error) {
+	cmd := exec.Command(p.path, p.args...)
+	cmd.Dir = config.WorkDir
+	cmd.Stdout, cmd.Stderr = os.Stdout, os.Stderr
+	cmd.Env = os.Environ()
+	for k, v := range config.Env {
+		cmd.Env = append(cmd.Env, k+"="+v)
+	}
+	if err = cmd.Start(); err != nil {
+		return
+	}
+	p.setProc(cmd.Process)
+	defer p.setProc(nil)
+	_ = logger.Infof("%s started, pid %d\n", p.path, cmd.Process.Pid)
+
+	exited := make(chan error, 1)
+	go func() { exited <- cmd.Wait() }()
+	select {
+	case err = <-exited:
+		if err == nil {
+			err = errors.New("%s exited by itself", p.path)
+		}
+		return
+	case <-ctx.Done():
+	}
+
+	res := service.StopProcess(context.Background(), config, cmd.Process.Pid, logger)
+	<-exited
+	_ = logger.Infof("%s: %v\n", p.path, res)
+	return res.Err
+}
+
+// HotReload sends SIGHUP to the program.
+func (p *program) HotReload(ctx context.Context, config *service.Config, logger service.Logger) (err error) {
+	p.mu.Lock()
+	proc := p.proc
+	p.mu.Unlock()
+	if proc == nil {
+		return errors.New("%s is not running", p.path)
+	}
+	return proc.Signal(syscall.SIGHUP)
+}
+
+func (p *program) setProc(proc *os.Process) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.proc = proc
+}
+
+// ViewLog shows the journal of the unit if systemd is running, or else
+// the standard output file of the service.
+func (p *program) ViewLog(ctx context.Context, config *service.Config, logger service.Logger) (err error) {
+	if runtime.GOOS == "linux" {
+		if _, e := os.Stat("/run/systemd/system"); e == nil {
+			if jc, e := exec.LookPath("journalctl"); e == nil {
+				args := []string{"-u", config.ServiceName(), "-n", strconv.Itoa(p.lines), "--no-pager"}
+				if config.UserLevel {
+					args[0] = "--user-unit"
+				}
+				if p.follow {
+					args = append(args, "-f")
+				}
+				c := exec.CommandContext(ctx, jc, args...)
+				c.Stdout, c.Stderr = os.Stdout, os.Stderr
+				if err = c.Run(); ctx.Err() != nil {
+					err = nil // interrupted while following
+				}
+				return
+			}
+		}
+	}
+	return tailFile(ctx, os.Stdout, config.StandardOutPath, p.lines, p.follow)
+}
+
+// tailFile writes the last lines of file to w, and the appended ones
+// till ctx is cancelled if follow.
+func tailFile(ctx context.Context, w io.Writer, file string, lines int, follow bool) (err error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return
+	}
+	defer f.Close()
+
+	data, err := io.ReadAll(f)
+	if err != nil {
+		return
+	}
+	if _, err = w.Write(lastLines(data, lines)); err != nil {
+		return
+	}
+	for follow {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(500 * time.Millisecond):
+		}
+		if _, err = io.Copy(w, f); err != nil {
+			return
+		}
+	}
+	return
+}
+
+// lastLines returns the last n lines of data.
+func lastLines(data []byte, n int) []byte {
+	if n <= 0 {
+		return data
+	}
+	i := len(data)
+	if i > 0 && data[i-1] == '\n' {
+		i--
+	}
+	for ; n > 0; n-- {
+		j := bytes.LastIndexByte(data[:i], '\n')
+		if j < 0 {
+			return data
+		}
+		i = j
+	}
+	return data[i+1:]
+}
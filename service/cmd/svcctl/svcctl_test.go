@@ -0,0 +1,137 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/hedzr/cmdr-addons/service/v2"
+)
+
+type testLogger struct{ t *testing.T }
+
+func (l testLogger) Info(msg string, args ...any)  { l.t.Log(append([]any{msg}, args...)...) }
+func (l testLogger) Warn(msg string, args ...any)  { l.t.Log(append([]any{msg}, args...)...) }
+func (l testLogger) Error(msg string, args ...any) { l.t.Log(append([]any{msg}, args...)...) }
+func (l testLogger) Infof(format string, a ...any) error {
+	l.t.Logf(format, a...)
+	return nil
+}
+func (l testLogger) Warnf(format string, a ...any) error {
+	l.t.Logf(format, a...)
+	return nil
+}
+func (l testLogger) Errorf(format string, a ...any) error {
+	l.t.Logf(format, a...)
+	return nil
+}
+
+func TestLoadAndWrap(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "svcctl configs") // a path with a space
+	t.Setenv("SVCCTL_DIR", dir)
+	file := filepath.Join(t.TempDir(), "myapp.yaml")
+	if err := os.WriteFile(file, []byte("executable: /usr/local/bin/myapp\nexec_start_args: --port 8080 -v --greeting 'hello world'\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	config, err := loadConfig(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if config.Name != "myapp" || config.WorkDir != "/usr/local/bin" {
+		t.Fatalf("bad defaults: name %q, work dir %q", config.Name, config.WorkDir)
+	}
+
+	installed := installedPath(config.Name, file)
+	if installed != filepath.Join(dir, "myapp.yaml") {
+		t.Fatalf("bad installed path %q", installed)
+	}
+	if err = saveConfig(config, installed); err != nil {
+		t.Fatal(err)
+	}
+	if found, err := findConfig("myapp"); err != nil || found != installed {
+		t.Fatalf("findConfig: %q, %v", found, err)
+	}
+
+	p := wrap(config, installed)
+	if p.path != "/usr/local/bin/myapp" || !slices.Equal(p.args, []string{"--port", "8080", "-v", "--greeting", "hello world"}) {
+		t.Fatalf("bad program: %q %q", p.path, p.args)
+	}
+	if config.Entity != p || !slices.Equal(service.SplitArgs(config.ExecStartArgs), []string{"run", "-f", installed}) || config.Executable == p.path {
+		t.Fatalf("bad wrapped config: %v", config)
+	}
+	if !slices.Equal(service.SplitArgs(config.ExecStopArgs), []string{"stop", "-service", "-f", installed}) {
+		t.Fatalf("bad stop args: %q", config.ExecStopArgs)
+	}
+
+	if err = removeConfig(installed); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = findConfig("myapp"); err == nil {
+		t.Fatal("expect not found after removing")
+	}
+}
+
+func TestProgramRun(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("needs /bin/sh")
+	}
+	config, err := loadConfigFrom(t, "executable: /bin/sh\nexec_start_args: -c 'exec sleep 30'\ntimeout_stop_sec: 5s\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := wrap(config, "test.yaml")
+	p.args = []string{"-c", "exec sleep 30"}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	begin := time.Now()
+	if err = p.Run(ctx, config, testLogger{t}); err != nil {
+		t.Fatal(err)
+	}
+	if d := time.Since(begin); d > 5*time.Second {
+		t.Fatalf("the program is not stopped in time: %v", d)
+	}
+
+	// exits by itself
+	p.args = []string{"-c", "exit 3"}
+	if err = p.Run(context.Background(), config, testLogger{t}); err == nil {
+		t.Fatal("expect the exit error")
+	}
+
+	// a normal exit ends the service as well
+	p.args = []string{"-c", "exit 0"}
+	if err = p.Run(context.Background(), config, testLogger{t}); err == nil {
+		t.Fatal("expect an error at the normal exit")
+	}
+}
+
+func loadConfigFrom(t *testing.T, content string) (*service.Config, error) {
+	file := filepath.Join(t.TempDir(), "demo.yaml")
+	if err := os.WriteFile(file, []byte(content), 0o644); err != nil {
+		return nil, err
+	}
+	return loadConfig(file)
+}
+
+func TestLastLines(t *testing.T) {
+	for _, c := range []struct {
+		data string
+		n    int
+		want string
+	}{
+		{"a\nb\nc\n", 2, "b\nc\n"},
+		{"a\nb\nc", 1, "c"},
+		{"a\nb\n", 5, "a\nb\n"},
+		{"a\nb\n", 0, "a\nb\n"},
+		{"", 3, ""},
+	} {
+		if got := string(lastLines([]byte(c.data), c.n)); got != c.want {
+			t.Errorf("lastLines(%q, %d) = %q, want %q", c.data, c.n, got, c.want)
+		}
+	}
+}
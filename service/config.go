@@ -3,6 +3,7 @@ package service
 import (
 	"fmt"
 	"os"
+	"path"
 	"strings"
 
 	"github.com/hedzr/is/dir"
@@ -75,12 +76,106 @@ func (e *Config) ExecutablePath() string {
 	return ""
 }
 
+// defaultRunDir returns /run, or /var/run on the systems without it.
+func defaultRunDir() string {
+	if dir.FileExists("/run") {
+		return "/run"
+	}
+	return "/var/run"
+}
+
+// PidfilePath returns the pidfile of this service, it is PIDFile if
+// specified, or else <RunDir>/<Name>.pid. A shared RunDir, such as
+// the default /run, gets a directory of the service in it:
+// <RunDir>/<Name>/<Name>.pid, which can be owned by User.
+func (e *Config) PidfilePath() string {
+	if e.PIDFile != "" {
+		return e.PIDFile
+	}
+	d, name := e.RunDir, e.BaseName()
+	if d == "" {
+		d = defaultRunDir()
+	}
+	if isSharedDir(d) {
+		d = path.Join(d, name)
+	}
+	return path.Join(d, name+".pid")
+}
+
+// startArgs returns the arguments to run the service in foreground
+// and service mode, it is ExecStartArgs if specified.
+func (e *Config) startArgs() []string {
+	if e.ExecStartArgs != "" {
+		return SplitArgs(e.ExecStartArgs)
+	}
+	return []string{"server", "start", "-foreground", "-service"}
+}
+
+// SplitArgs splits the command line s into the arguments like sh, so
+// ExecStartArgs and ExecStopArgs can hold the ones with spaces:
+// 'single quoted', "double quoted" and the backslash escapes are
+// understood. The variables and globs are not expanded.
+func SplitArgs(s string) (args []string) {
+	var sb strings.Builder
+	inArg, quote, escaped := false, rune(0), false
+	for _, r := range s {
+		switch {
+		case escaped:
+			sb.WriteRune(r)
+			escaped = false
+		case quote == '\'':
+			if r == '\'' {
+				quote = 0
+			} else {
+				sb.WriteRune(r)
+			}
+		case r == '\\' && (quote == 0 || quote == '"'):
+			escaped, inArg = true, true
+		case quote == '"':
+			if r == '"' {
+				quote = 0
+			} else {
+				sb.WriteRune(r)
+			}
+		case r == '\'' || r == '"':
+			quote, inArg = r, true
+		case r == ' ' || r == '\t' || r == '\n':
+			if inArg {
+				args = append(args, sb.String())
+				sb.Reset()
+				inArg = false
+			}
+		default:
+			sb.WriteRune(r)
+			inArg = true
+		}
+	}
+	if inArg {
+		args = append(args, sb.String())
+	}
+	return
+}
+
+// JoinArgs joins args into a command line for ExecStartArgs and
+// ExecStopArgs, each one is quoted if necessary. See SplitArgs.
+func JoinArgs(args ...string) string {
+	quoted := make([]string, len(args))
+	for i, a := range args {
+		if a == "" || strings.ContainsAny(a, " \t\n'\"\\$`;&|<>(){}*?[]~#") {
+			a = "'" + strings.ReplaceAll(a, "'", `'\''`) + "'"
+		}
+		quoted[i] = a
+	}
+	return strings.Join(quoted, " ")
+}
+
 func (e *Config) makeSafety() {
-	if e.Executable == "" || !dir.FileExists(e.Executable) {
+	// the missing ones are reported by Validate, not replaced
+	if e.Executable == "" {
 		e.Executable = dir.GetExecutablePath()
 	}
 
-	if e.WorkDir == "" || !dir.FileExists(e.WorkDir) {
+	if e.WorkDir == "" {
 		e.WorkDir = dir.GetExecutableDir()
 	}
 
@@ -88,7 +183,7 @@ func (e *Config) makeSafety() {
 		e.TempDir = os.TempDir()
 	}
 	if e.RunDir == "" {
-		e.RunDir = "/var/run"
+		e.RunDir = defaultRunDir()
 		if !dir.FileExists(e.RunDir) {
 			e.RunDir = os.TempDir()
 		}
@@ -0,0 +1,281 @@
+package service
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/fs"
+	"os"
+	"path"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pelletier/go-toml/v2"
+	"gopkg.in/hedzr/errors.v3"
+	"gopkg.in/yaml.v3"
+)
+
+// LoadConfig reads the service config from file, and applies the
+// overrides in order, such as a host-specific file (see
+// HostConfigPath). The missing overrides are skipped.
+//
+// The format is picked by the extension: .yaml/.yml, .toml or .json.
+// The keys are the json tags of Config, such as "work_dir", and
+// ${VAR} or ${VAR:-default} in the values are replaced by the
+// environment variables. A duration can be a time span like "1min 30s"
+// or a number of seconds.
+//
+// Entity is not loaded, set it before using the config.
+func LoadConfig(file string, overrides ...string) (config *Config, err error) {
+	return LoadConfigFS(osFS{}, file, overrides...)
+}
+
+// LoadConfigFS is LoadConfig reading the files from fsys.
+func LoadConfigFS(fsys fs.FS, name string, overrides ...string) (config *Config, err error) {
+	var m map[string]any
+	if m, err = readConfigLayer(fsys, name); err != nil {
+		return
+	}
+	for _, o := range overrides {
+		layer, e := readConfigLayer(fsys, o)
+		if errors.Is(e, fs.ErrNotExist) {
+			continue
+		}
+		if e != nil {
+			return nil, e
+		}
+		mergeConfigMap(m, layer)
+	}
+
+	config = &Config{}
+	if err = decodeConfigMap(m, config); err != nil {
+		err = errors.New("bad service config %q: %v", name, err)
+		config = nil
+	}
+	return
+}
+
+// HostConfigPath returns the host-specific override of file, such as
+// /etc/app/service.web1.yaml for /etc/app/service.yaml on host web1.
+func HostConfigPath(file string) string {
+	host, _ := os.Hostname()
+	if i := strings.IndexByte(host, '.'); i > 0 {
+		host = host[:i]
+	}
+	ext := path.Ext(file)
+	return strings.TrimSuffix(file, ext) + "." + host + ext
+}
+
+// Dump returns the effective config in format ("yaml", "toml" or
+// "json"), with the defaults filled as the backends do. The durations
+// are written as time spans.
+func (e *Config) Dump(format string) (data []byte, err error) {
+	c := *e
+	c.makeSafety()
+
+	var m map[string]any
+	if data, err = json.Marshal(&c); err != nil {
+		return
+	}
+	if err = json.Unmarshal(data, &m); err != nil {
+		return
+	}
+	formatDurations(reflect.TypeOf(c), m)
+
+	switch format {
+	case "yaml", "yml":
+		return yaml.Marshal(m)
+	case "toml":
+		return toml.Marshal(m)
+	case "json":
+		return json.MarshalIndent(m, "", "  ")
+	}
+	return nil, errors.New("unknown config format %q", format)
+}
+
+// Save writes Dump into file, the format is picked by the extension.
+func (e *Config) Save(file string) (err error) {
+	var data []byte
+	if data, err = e.Dump(strings.TrimPrefix(path.Ext(file), ".")); err != nil {
+		return
+	}
+	return writeFileAtomic(file, data, 0o644)
+}
+
+type osFS struct{}
+
+func (osFS) Open(name string) (fs.File, error) { return os.Open(name) }
+
+func readConfigLayer(fsys fs.FS, name string) (m map[string]any, err error) {
+	var data []byte
+	if data, err = fs.ReadFile(fsys, name); err != nil {
+		return
+	}
+	switch ext := path.Ext(name); ext {
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(data, &m)
+	case ".toml":
+		err = toml.Unmarshal(data, &m)
+	case ".json":
+		err = json.Unmarshal(data, &m)
+	default:
+		err = errors.New("unknown config format %q", ext)
+	}
+	if err != nil {
+		err = errors.New("cannot parse %q: %v", name, err)
+		return
+	}
+	if m == nil {
+		m = make(map[string]any) // an empty file
+	}
+	expandConfigEnv(m)
+	return
+}
+
+// expandConfigEnv replaces ${VAR} and ${VAR:-default} in the string
+// values. $VAR is kept as is, it is common in the command lines for the
+// init systems, such as $MAINPID.
+func expandConfigEnv(v any) any {
+	switch t := v.(type) {
+	case string:
+		return expandEnvBraces(t)
+	case map[string]any:
+		for k, e := range t {
+			t[k] = expandConfigEnv(e)
+		}
+	case []any:
+		for i, e := range t {
+			t[i] = expandConfigEnv(e)
+		}
+	}
+	return v
+}
+
+func expandEnvBraces(s string) string {
+	var sb strings.Builder
+	for {
+		i := strings.Index(s, "${")
+		if i < 0 {
+			break
+		}
+		j := strings.IndexByte(s[i:], '}')
+		if j < 0 {
+			break
+		}
+		sb.WriteString(s[:i])
+		name, def, hasDef := strings.Cut(s[i+2:i+j], ":-")
+		if v, ok := os.LookupEnv(name); ok && (v != "" || !hasDef) {
+			sb.WriteString(v)
+		} else {
+			sb.WriteString(def)
+		}
+		s = s[i+j+1:]
+	}
+	sb.WriteString(s)
+	return sb.String()
+}
+
+// mergeConfigMap merges the override into m: the maps are merged
+// recursively, the others are replaced.
+func mergeConfigMap(m, override map[string]any) {
+	for k, v := range override {
+		if sub, ok := v.(map[string]any); ok {
+			if base, ok := m[k].(map[string]any); ok {
+				mergeConfigMap(base, sub)
+				continue
+			}
+		}
+		m[k] = v
+	}
+}
+
+func decodeConfigMap(m map[string]any, config *Config) (err error) {
+	if err = parseDurations(reflect.TypeOf(*config), m); err != nil {
+		return
+	}
+	var data []byte
+	if data, err = json.Marshal(m); err != nil {
+		return
+	}
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	return dec.Decode(config)
+}
+
+var durationType = reflect.TypeOf(time.Duration(0))
+
+// parseDurations converts the time spans and the seconds in v into
+// the nanoseconds, for the time.Duration fields of t.
+func parseDurations(t reflect.Type, v any) (err error) {
+	return walkDurations(t, v, "", func(key string, d any) (any, error) {
+		switch n := d.(type) {
+		case string:
+			dur, e := parseTimespanE(n)
+			if e != nil {
+				return nil, errors.New("%s: %v", key, e)
+			}
+			return int64(dur), nil
+		case int64:
+			return n * int64(time.Second), nil
+		case uint64:
+			return int64(n) * int64(time.Second), nil
+		case int:
+			return int64(n) * int64(time.Second), nil
+		case float64:
+			return int64(n * float64(time.Second)), nil
+		}
+		return d, nil
+	})
+}
+
+// formatDurations converts the nanoseconds in v into the time spans,
+// for the time.Duration fields of t.
+func formatDurations(t reflect.Type, v any) {
+	_ = walkDurations(t, v, "", func(key string, d any) (any, error) {
+		if n, ok := d.(float64); ok {
+			return time.Duration(n).String(), nil
+		}
+		return d, nil
+	})
+}
+
+// walkDurations calls fn for the values of v decoded from a file or
+// from json, which are of the time.Duration fields of t.
+func walkDurations(t reflect.Type, v any, key string, fn func(key string, d any) (any, error)) (err error) {
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	switch t.Kind() {
+	case reflect.Struct:
+		m, ok := v.(map[string]any)
+		if !ok {
+			return
+		}
+		for i := 0; i < t.NumField(); i++ {
+			f := t.Field(i)
+			name, _, _ := strings.Cut(f.Tag.Get("json"), ",")
+			fv, ok := m[name]
+			if name == "" || name == "-" || !ok {
+				continue
+			}
+			k := strings.TrimPrefix(key+"."+name, ".")
+			if f.Type == durationType {
+				if m[name], err = fn(k, fv); err != nil {
+					return
+				}
+			} else if err = walkDurations(f.Type, fv, k, fn); err != nil {
+				return
+			}
+		}
+	case reflect.Slice:
+		if s, ok := v.([]any); ok {
+			for i, e := range s {
+				if err = walkDurations(t.Elem(), e, key+"["+strconv.Itoa(i)+"]", fn); err != nil {
+					return
+				}
+			}
+		}
+	}
+	return
+}
@@ -0,0 +1,94 @@
+package service
+
+import (
+	"path"
+	"strings"
+	"testing"
+	"testing/fstest"
+	"time"
+)
+
+func TestLoadConfigFS(t *testing.T) {
+	t.Setenv("DEMO_PORT", "8080")
+	fsys := fstest.MapFS{
+		"service.yaml": {Data: []byte(`
+name: demo
+description: demo service on ${DEMO_PORT}
+work_dir: ${DEMO_HOME:-/opt/demo}
+env:
+  PORT: ${DEMO_PORT}
+  MODE: prod
+timeout_stop_sec: 30s
+supervisor:
+  policy: on-failure
+  max_backoff: 1min 30s
+health:
+  interval: 10
+  liveness:
+    - name: http
+      http: http://localhost:${DEMO_PORT}/healthz
+      timeout: 500ms
+env_apply: HotReload
+`)},
+		"service.web1.toml": {Data: []byte(`
+user = "demo"
+[env]
+MODE = "staging"
+[supervisor]
+reset_after = "2m"
+`)},
+		"bad.json": {Data: []byte(`{"name": "demo", "wokr_dir": "/tmp"}`)},
+	}
+
+	config, err := LoadConfigFS(fsys, "service.yaml", "service.web1.toml", "service.web2.toml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if config.Name != "demo" || config.Description != "demo service on 8080" || config.WorkDir != "/opt/demo" || config.User != "demo" {
+		t.Fatalf("bad config: %+v", config)
+	}
+	if config.Env["PORT"] != "8080" || config.Env["MODE"] != "staging" || config.EnvApply != HotReload || config.TimeoutStopSec != "30s" {
+		t.Fatalf("bad config: %+v", config)
+	}
+	if s := config.Supervisor; s == nil || s.Policy != RestartOnFailure || s.MaxBackoff != 90*time.Second || s.ResetAfter != 2*time.Minute {
+		t.Fatalf("bad supervisor: %+v", s)
+	}
+	if h := config.Health; h == nil || h.Interval != 10*time.Second || len(h.Liveness) != 1 ||
+		h.Liveness[0].HTTP != "http://localhost:8080/healthz" || h.Liveness[0].Timeout != 500*time.Millisecond {
+		t.Fatalf("bad health: %+v", h)
+	}
+
+	if _, err = LoadConfigFS(fsys, "bad.json"); err == nil || !strings.Contains(err.Error(), "wokr_dir") {
+		t.Fatalf("expect the unknown field reported, got %v", err)
+	}
+	if _, err = LoadConfigFS(fsys, "nope.yaml"); err == nil {
+		t.Fatal("expect an error for the missing base file")
+	}
+}
+
+func TestConfigSave(t *testing.T) {
+	config := &Config{
+		Name:       "demo",
+		WorkDir:    t.TempDir(),
+		Env:        map[string]string{"PORT": "8080"},
+		Supervisor: &SupervisorConfig{Policy: RestartAlways, MaxBackoff: 90 * time.Second},
+		EnvApply:   Restart,
+	}
+	for _, ext := range []string{".yaml", ".toml", ".json"} {
+		file := path.Join(t.TempDir(), "service"+ext)
+		if err := config.Save(file); err != nil {
+			t.Fatal(err)
+		}
+		loaded, err := LoadConfig(file)
+		if err != nil {
+			t.Fatalf("%s: %v", ext, err)
+		}
+		if loaded.Name != "demo" || loaded.Env["PORT"] != "8080" || loaded.EnvApply != Restart || loaded.RunDir == "" ||
+			loaded.Supervisor == nil || loaded.Supervisor.MaxBackoff != 90*time.Second {
+			t.Fatalf("%s: bad round trip: %+v", ext, loaded)
+		}
+	}
+	if config.RunDir != "" {
+		t.Fatal("Save must not change the config")
+	}
+}
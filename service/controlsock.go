@@ -0,0 +1,387 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+	"time"
+
+	logzorig "github.com/hedzr/logg/slog"
+	"gopkg.in/hedzr/errors.v3"
+
+	"github.com/hedzr/cmdr-addons/v2/tool/dbglog"
+)
+
+// ControlRequest is a request sent to the control socket of the running
+// service. The protocol is one JSON object per line: the client writes a
+// request and reads a ControlResponse on the same connection.
+type ControlRequest struct {
+	Cmd  string          `json:"cmd"`
+	Args json.RawMessage `json:"args,omitempty"`
+}
+
+// ControlResponse is the reply of the running service.
+type ControlResponse struct {
+	OK    bool            `json:"ok"`
+	Error string          `json:"error,omitempty"`
+	Data  json.RawMessage `json:"data,omitempty"`
+}
+
+// ControlHandler handles a custom command of the control socket, see
+// Manager.OnControl. The returned data is sent back as JSON.
+type ControlHandler func(ctx context.Context, config *Config, logger Logger, args json.RawMessage) (data any, err error)
+
+// ControlStatus is the reply of the "status" command.
+type ControlStatus struct {
+	Name       string            `json:"name"`
+	Pid        int               `json:"pid"`
+	Started    time.Time         `json:"started"`
+	LogLevel   string            `json:"logLevel,omitempty"`
+	Info       string            `json:"info,omitempty"`
+	Port       int               `json:"port,omitempty"`
+	Addr       string            `json:"addr,omitempty"`
+	Supervisor *SupervisorStatus `json:"supervisor,omitempty"`
+	Health     *HealthReport     `json:"health,omitempty"`
+}
+
+func (st ControlStatus) String() string {
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "%s is running (pid %d, up %v)", st.Name, st.Pid, time.Since(st.Started).Round(time.Second))
+	if st.LogLevel != "" {
+		fmt.Fprintf(&sb, "\n  log level: %s", st.LogLevel)
+	}
+	if st.Addr != "" {
+		fmt.Fprintf(&sb, "\n  addr:      %s", st.Addr)
+	} else if st.Port != 0 {
+		fmt.Fprintf(&sb, "\n  port:      %d", st.Port)
+	}
+	if st.Info != "" {
+		fmt.Fprintf(&sb, "\n  info:      %s", st.Info)
+	}
+	if st.Supervisor != nil {
+		fmt.Fprintf(&sb, "\n%s", st.Supervisor.String())
+	}
+	if st.Health != nil {
+		fmt.Fprintf(&sb, "\n%s", st.Health.String())
+	}
+	return sb.String()
+}
+
+const defaultControlTimeout = 30 * time.Second
+
+// controlSocketPath returns the control socket, it is ControlSocket if
+// specified, or else beside the pidfile. "-" disables the socket.
+func controlSocketPath(config *Config) string {
+	if config.ControlSocket != "" {
+		if config.ControlSocket == "-" {
+			return ""
+		}
+		return config.ControlSocket
+	}
+	return strings.TrimSuffix(config.PidfilePath(), ".pid") + ".sock"
+}
+
+// OnControl registers a custom command of the control socket. The
+// built-in commands can't be overridden.
+func (s *mgmtS) OnControl(name string, handler ControlHandler) Manager {
+	if _, ok := controlCommands[name]; ok {
+		dbglog.Warn("[control] built-in command cannot be overridden", "cmd", name)
+		return s
+	}
+	s.hooksMu.Lock()
+	defer s.hooksMu.Unlock()
+	if s.controlHandlers == nil {
+		s.controlHandlers = make(map[string]ControlHandler)
+	}
+	s.controlHandlers[name] = handler
+	return s
+}
+
+// controlCommands are the built-in commands of the control socket.
+var controlCommands = map[string]func(ctx context.Context, config *Config, m *mgmtS, logger Logger, args json.RawMessage) (data any, err error){
+	"status":    controlStatus,
+	"info":      controlInfo,
+	"port":      controlPort,
+	"addr":      controlAddr,
+	"reload":    controlReload,
+	"log-level": controlLogLevel,
+	"stop":      controlStop,
+	"metrics":   controlMetrics,
+}
+
+// handleControl runs a request in the service process.
+func (s *mgmtS) handleControl(ctx context.Context, config *Config, logger Logger, req ControlRequest) (resp ControlResponse) {
+	var data any
+	var err error
+	if fn, ok := controlCommands[req.Cmd]; ok {
+		data, err = fn(ctx, config, s, logger, req.Args)
+	} else {
+		s.hooksMu.Lock()
+		handler, ok := s.controlHandlers[req.Cmd]
+		s.hooksMu.Unlock()
+		if !ok {
+			err = errors.New("unknown control command %q", req.Cmd)
+		} else {
+			data, err = handler(ctx, config, logger, req.Args)
+		}
+	}
+
+	if err != nil {
+		resp.Error = err.Error()
+		return
+	}
+	if data != nil {
+		if resp.Data, err = json.Marshal(data); err != nil {
+			resp.Error = err.Error()
+			return
+		}
+	}
+	resp.OK = true
+	return
+}
+
+func controlStatus(ctx context.Context, config *Config, m *mgmtS, logger Logger, args json.RawMessage) (data any, err error) {
+	st := ControlStatus{Name: config.ServiceName(), Pid: os.Getpid(), Started: m.started, LogLevel: levelName(dbglog.GetLevel())}
+	if fn, ok := config.Entity.(EntityInfoAware); ok {
+		st.Info = fn.Info(ctx, config, logger)
+	}
+	if fn, ok := config.Entity.(EntityPortAware); ok {
+		st.Port = fn.Port(ctx, config, logger)
+	}
+	if fn, ok := config.Entity.(EntityAddrAware); ok {
+		st.Addr = fn.Addr(ctx, config, logger)
+	}
+	if sup, e := ReadSupervisorStatus(config); e == nil && sup.Pid == st.Pid {
+		st.Supervisor = &sup
+	}
+	if h, e := ReadHealthStatus(config); e == nil && h.Pid == st.Pid {
+		st.Health = &h
+	}
+	return st, nil
+}
+
+func controlInfo(ctx context.Context, config *Config, m *mgmtS, logger Logger, args json.RawMessage) (data any, err error) {
+	if fn, ok := config.Entity.(EntityInfoAware); ok {
+		return fn.Info(ctx, config, logger), nil
+	}
+	return
+}
+
+func controlPort(ctx context.Context, config *Config, m *mgmtS, logger Logger, args json.RawMessage) (data any, err error) {
+	if fn, ok := config.Entity.(EntityPortAware); ok {
+		return fn.Port(ctx, config, logger), nil
+	}
+	return
+}
+
+func controlAddr(ctx context.Context, config *Config, m *mgmtS, logger Logger, args json.RawMessage) (data any, err error) {
+	if fn, ok := config.Entity.(EntityAddrAware); ok {
+		return fn.Addr(ctx, config, logger), nil
+	}
+	return
+}
+
+func controlReload(ctx context.Context, config *Config, m *mgmtS, logger Logger, args json.RawMessage) (data any, err error) {
+	return nil, m.hotReload(ctx, config, logger)
+}
+
+// controlLogLevel sets the level if args is {"level":"debug"}, and
+// returns the current level.
+func controlLogLevel(ctx context.Context, config *Config, m *mgmtS, logger Logger, args json.RawMessage) (data any, err error) {
+	var a struct {
+		Level string `json:"level"`
+	}
+	if len(args) > 0 {
+		if err = json.Unmarshal(args, &a); err != nil {
+			return
+		}
+	}
+	if a.Level != "" {
+		var level logzorig.Level
+		if level, err = parseLevel(a.Level); err != nil {
+			return
+		}
+		dbglog.SetLevel(level)
+		dbglog.InfoContext(ctx, "[control] log level changed", "level", a.Level)
+	}
+	return levelName(dbglog.GetLevel()), nil
+}
+
+// controlStop starts the graceful shutdown and returns our pid, so the
+// client can wait for us.
+func controlStop(ctx context.Context, config *Config, m *mgmtS, logger Logger, args json.RawMessage) (data any, err error) {
+	if err = quitSelf(config); err != nil {
+		return
+	}
+	return os.Getpid(), nil
+}
+
+var levelNames = map[string]logzorig.Level{
+	"panic": logzorig.PanicLevel,
+	"fatal": logzorig.FatalLevel,
+	"error": logzorig.ErrorLevel,
+	"warn":  logzorig.WarnLevel,
+	"info":  logzorig.InfoLevel,
+	"debug": logzorig.DebugLevel,
+	"trace": logzorig.TraceLevel,
+	"off":   logzorig.OffLevel,
+}
+
+func parseLevel(name string) (level logzorig.Level, err error) {
+	name = strings.ToLower(name)
+	if name == "warning" {
+		name = "warn"
+	}
+	var ok bool
+	if level, ok = levelNames[name]; !ok {
+		err = errors.New("unknown log level %q", name)
+	}
+	return
+}
+
+func levelName(level logzorig.Level) string {
+	for name, l := range levelNames {
+		if l == level {
+			return name
+		}
+	}
+	return fmt.Sprint(int(level))
+}
+
+// SendControl sends a command to the control socket of the running
+// service and returns the data replied. The built-in commands are
+// "status", "info", "port", "addr", "reload", "log-level" (with args
+// {"level":"debug"}) and "stop", the others are registered by
+// Manager.OnControl.
+//
+// ErrServiceIsNotRunning is returned if the socket can't be connected.
+func SendControl(ctx context.Context, config *Config, cmd string, args any) (data json.RawMessage, err error) {
+	var reached bool
+	if data, reached, err = sendControl(ctx, config, cmd, args); !reached && err != nil {
+		dbglog.DebugContext(ctx, "[control] cannot connect", "socket", controlSocketPath(config), "err", err)
+		err = ErrServiceIsNotRunning
+	}
+	return
+}
+
+// sendControl reports whether the running service was reached, so that
+// the caller can fall back to the init system.
+func sendControl(ctx context.Context, config *Config, cmd string, args any) (data json.RawMessage, reached bool, err error) {
+	file := controlSocketPath(config)
+	if file == "" {
+		err = errors.New("the control socket is disabled")
+		return
+	}
+
+	req := ControlRequest{Cmd: cmd}
+	if args != nil {
+		if req.Args, err = json.Marshal(args); err != nil {
+			return
+		}
+	}
+
+	var conn net.Conn
+	if conn, err = (&net.Dialer{Timeout: 2 * time.Second}).DialContext(ctx, "unix", file); err != nil {
+		return
+	}
+	defer conn.Close()
+	reached = true
+
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		deadline = time.Now().Add(defaultControlTimeout)
+	}
+	_ = conn.SetDeadline(deadline)
+
+	if err = json.NewEncoder(conn).Encode(req); err != nil {
+		return
+	}
+	var resp ControlResponse
+	if err = json.NewDecoder(conn).Decode(&resp); err != nil {
+		err = errors.New("bad reply of control command %q", cmd).WithErrors(err)
+		return
+	}
+	if !resp.OK {
+		err = errors.New("control command %q failed: %s", cmd, resp.Error)
+		return
+	}
+	data = resp.Data
+	return
+}
+
+// controlCommandNames are the Commands answered by the running service
+// through the control socket.
+var controlCommandNames = map[Command]string{
+	Info:      "info",
+	Port:      "port",
+	Addr:      "addr",
+	Status:    "status",
+	HotReload: "reload",
+	Stop:      "stop",
+}
+
+// controlRunning asks the running service for cmd. It returns false if
+// the service can't be reached, or cmd should be left to the backend:
+//
+//   - the entity customized the command (EntityStatusAware, ...);
+//   - Status and Stop, if the service is managed by an init system:
+//     it knows the state of the unit (enabled, failed, restarting...)
+//     and must see the stopping, or it would restart the service.
+func (s *mgmtS) controlRunning(ctx context.Context, config *Config, be Backend, cmd Command) (handled bool, err error) {
+	name, ok := controlCommandNames[cmd]
+	if !ok {
+		return
+	}
+	switch cmd {
+	case Status:
+		if _, ok = config.Entity.(EntityStatusAware); ok || !isDaemonBackend(be) {
+			return
+		}
+	case Stop:
+		if _, ok = config.Entity.(EntityStopAware); ok || !isDaemonBackend(be) {
+			return
+		}
+	}
+
+	var data json.RawMessage
+	if data, handled, err = sendControl(ctx, config, name, nil); !handled {
+		dbglog.DebugContext(ctx, "[control] service not reachable, fallback to backend", "cmd", cmd, "err", err)
+		err = nil
+		return
+	}
+	if err != nil {
+		return
+	}
+
+	switch cmd {
+	case Status:
+		var st ControlStatus
+		if err = json.Unmarshal(data, &st); err == nil {
+			println(st.String())
+		}
+	case HotReload:
+		println("reloaded")
+	case Stop:
+		var pid int
+		if err = json.Unmarshal(data, &pid); err != nil {
+			return
+		}
+		timeout := parseTimespan(config.TimeoutStopSec, defaultStopTimeout)
+		if handled = waitProcessExit(ctx, pid, timeout); handled {
+			println("service stopped, pid:", pid)
+			return
+		}
+		dbglog.WarnContext(ctx, "[control] service is still running, fallback to backend", "pid", pid, "timeout", timeout)
+	default:
+		if len(data) > 0 {
+			var v any
+			if err = json.Unmarshal(data, &v); err == nil {
+				println(fmt.Sprint(v))
+			}
+		}
+	}
+	return
+}
@@ -0,0 +1,16 @@
+//go:build windows || plan9 || js
+// +build windows plan9 js
+
+package service
+
+import (
+	"context"
+)
+
+// serveControl is not supported, the Commands are sent through the
+// service manager of the platform.
+func (s *mgmtS) serveControl(ctx context.Context, config *Config, logger Logger) (stop func()) {
+	return func() {}
+}
+
+func isDaemonBackend(be Backend) bool { return false }
@@ -0,0 +1,126 @@
+//go:build !windows && !plan9 && !js
+// +build !windows,!plan9,!js
+
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"os"
+	"path"
+	"strings"
+	"testing"
+)
+
+type controlEntity struct {
+	shutdownEntity
+	reloads int
+}
+
+func (e *controlEntity) Info(ctx context.Context, config *Config, logger Logger) string {
+	return "v1.2"
+}
+
+func (e *controlEntity) Port(ctx context.Context, config *Config, logger Logger) int {
+	return 8080
+}
+
+func (e *controlEntity) HotReload(ctx context.Context, config *Config, logger Logger) (err error) {
+	e.reloads++
+	return
+}
+
+func TestControlSocket(t *testing.T) {
+	entity := &controlEntity{shutdownEntity: shutdownEntity{entityS: entityS{name: "demo"}}}
+	config := &Config{Name: "demo", Entity: entity, PIDFile: path.Join(t.TempDir(), "demo.pid")}
+	ctx := context.Background()
+
+	m := &mgmtS{}
+	m.OnControl("echo", func(ctx context.Context, config *Config, logger Logger, args json.RawMessage) (data any, err error) {
+		return args, nil
+	})
+	stop := m.serveControl(ctx, config, nil)
+
+	file := controlSocketPath(config)
+	if info, err := os.Stat(file); err != nil || info.Mode().Perm() != 0o600 {
+		t.Fatalf("bad control socket %q: %v, %v", file, info, err)
+	}
+
+	data, err := SendControl(ctx, config, "status", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var st ControlStatus
+	if err = json.Unmarshal(data, &st); err != nil || st.Pid != os.Getpid() || st.Port != 8080 || st.Info != "v1.2" {
+		t.Fatalf("bad status %s: %v", data, err)
+	}
+
+	if _, err = SendControl(ctx, config, "reload", nil); err != nil || entity.reloads != 1 {
+		t.Fatalf("reload: reloads = %d, err = %v", entity.reloads, err)
+	}
+	if data, err = SendControl(ctx, config, "log-level", map[string]string{"level": "debug"}); err != nil || string(data) != `"debug"` {
+		t.Fatalf("log-level: %s, %v", data, err)
+	}
+	if _, err = SendControl(ctx, config, "log-level", map[string]string{"level": "loud"}); err == nil {
+		t.Fatal("expect an error for unknown level")
+	}
+	var text string
+	if data, err = SendControl(ctx, config, "metrics", nil); err != nil || json.Unmarshal(data, &text) != nil || !strings.Contains(text, "service_reloads_total 1\n") {
+		t.Fatalf("metrics: %s, %v", text, err)
+	}
+	if data, err = SendControl(ctx, config, "echo", []int{1, 2}); err != nil || string(data) != "[1,2]" {
+		t.Fatalf("echo: %s, %v", data, err)
+	}
+	if _, err = SendControl(ctx, config, "nope", nil); err == nil {
+		t.Fatal("expect an error for unknown command")
+	}
+
+	// the status of a service under an init system is left to it
+	if handled, _ := m.controlRunning(ctx, config, nil, Status); handled {
+		t.Fatal("expect Status left to the init system")
+	}
+	if handled, err := m.controlRunning(ctx, config, &daemonD{}, Status); !handled || err != nil {
+		t.Fatalf("expect Status answered for the daemon backend, got %v, %v", handled, err)
+	}
+
+	stop()
+	if _, err = os.Stat(file); !os.IsNotExist(err) {
+		t.Fatalf("control socket is not removed: %v", err)
+	}
+	if _, err = SendControl(ctx, config, "status", nil); err != ErrServiceIsNotRunning {
+		t.Fatalf("expect ErrServiceIsNotRunning, got %v", err)
+	}
+}
+
+func TestControlSocket_authorize(t *testing.T) {
+	config := &Config{Name: "demo", PIDFile: path.Join(t.TempDir(), "demo.pid")}
+	cs, err := listenControl(config, controlSocketPath(config))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cs.ln.Close()
+
+	go func() {
+		if conn, err := net.Dial("unix", cs.file); err == nil {
+			defer conn.Close()
+			_, _ = conn.Read(make([]byte, 1))
+		}
+	}()
+	conn, err := cs.ln.AcceptUnix()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	if err = cs.authorize(conn); err != nil {
+		t.Fatalf("expect ourselves allowed, got %v", err)
+	}
+	if _, _, e := peerCred(conn); e != nil {
+		t.Skipf("peer credentials unavailable: %v", e)
+	}
+	cs.uids, cs.gid = map[int]bool{}, -1
+	if err = cs.authorize(conn); err == nil {
+		t.Fatal("expect the peer rejected")
+	}
+}
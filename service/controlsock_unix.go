@@ -0,0 +1,199 @@
+//go:build !windows && !plan9 && !js
+// +build !windows,!plan9,!js
+
+package service
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"net"
+	"os"
+	"os/user"
+	"path"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/hedzr/is/dir"
+	"gopkg.in/hedzr/errors.v3"
+
+	"github.com/hedzr/cmdr-addons/v2/tool/dbglog"
+)
+
+// serveControl opens the control socket in service mode, till the
+// returned func is called.
+//
+// The socket is created with mode 0600, or 0660 and owned by Group if
+// specified. Besides the file permissions, the credentials of the peer
+// are checked where the platform supports it (SO_PEERCRED, or
+// LOCAL_PEERCRED): root, the service user, the members of Group and
+// ControlUsers are allowed.
+func (s *mgmtS) serveControl(ctx context.Context, config *Config, logger Logger) (stop func()) {
+	file := controlSocketPath(config)
+	if file == "" {
+		return func() {}
+	}
+
+	cs, err := listenControl(config, file)
+	if err != nil {
+		dbglog.WarnContext(ctx, "[control] cannot open the control socket", "socket", file, "err", err)
+		return func() {}
+	}
+	dbglog.InfoContext(ctx, "[control] control socket opened", "socket", file)
+
+	ctx, cancel := context.WithCancel(ctx)
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for {
+			conn, err := cs.ln.AcceptUnix()
+			if err != nil {
+				if ctx.Err() == nil {
+					dbglog.ErrorContext(ctx, "[control] accept failed", "err", err)
+				}
+				return
+			}
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				cs.serve(ctx, config, s, logger, conn)
+			}()
+		}
+	}()
+	return func() {
+		cancel()
+		_ = cs.ln.Close()
+		cs.remove()
+		wg.Wait()
+	}
+}
+
+type controlServer struct {
+	ln   *net.UnixListener
+	file string
+	info os.FileInfo // to tell our socket from the one of an upgraded process
+
+	uids map[int]bool
+	gid  int // -1 if Group is not specified
+}
+
+func listenControl(config *Config, file string) (cs *controlServer, err error) {
+	if err = dir.EnsureDir(path.Dir(file)); err != nil {
+		return
+	}
+	cs = &controlServer{file: file, uids: map[int]bool{0: true, os.Geteuid(): true}, gid: -1}
+	for _, name := range config.ControlUsers {
+		if uid, e := lookupUid(name); e == nil {
+			cs.uids[uid] = true
+		} else {
+			dbglog.Warn("[control] unknown user in ControlUsers", "user", name, "err", e)
+		}
+	}
+	mode := os.FileMode(0o600)
+	if config.Group != "" {
+		if g, e := user.LookupGroup(config.Group); e == nil {
+			cs.gid, _ = strconv.Atoi(g.Gid)
+			mode = 0o660
+		} else {
+			dbglog.Warn("[control] unknown group", "group", config.Group, "err", e)
+		}
+	}
+
+	// a stale socket is left if the service crashed. the pidfile
+	// guards us from removing the socket of another running instance,
+	// and an upgraded process takes over the socket of the old one.
+	_ = os.Remove(file)
+	if cs.ln, err = net.ListenUnix("unix", &net.UnixAddr{Name: file, Net: "unix"}); err != nil {
+		return
+	}
+	cs.ln.SetUnlinkOnClose(false)
+	if cs.gid >= 0 {
+		if err = os.Chown(file, -1, cs.gid); err != nil {
+			dbglog.Warn("[control] cannot change the group of the control socket", "socket", file, "err", err)
+		}
+	}
+	if err = os.Chmod(file, mode); err != nil {
+		_ = cs.ln.Close()
+		_ = os.Remove(file)
+		return
+	}
+	cs.info, err = os.Stat(file)
+	return
+}
+
+// remove unlinks the socket unless it was replaced by an upgraded
+// process.
+func (cs *controlServer) remove() {
+	if info, err := os.Stat(cs.file); err == nil && os.SameFile(info, cs.info) {
+		_ = os.Remove(cs.file)
+	}
+}
+
+func lookupUid(name string) (uid int, err error) {
+	if uid, err = strconv.Atoi(name); err == nil {
+		return
+	}
+	var u *user.User
+	if u, err = user.Lookup(name); err != nil {
+		return
+	}
+	return strconv.Atoi(u.Uid)
+}
+
+// authorize checks the credentials of the peer.
+func (cs *controlServer) authorize(conn *net.UnixConn) (err error) {
+	uid, gids, err := peerCred(conn)
+	if err != nil {
+		if errors.Is(err, errors.Unavailable) {
+			// rely on the permissions of the socket file
+			return nil
+		}
+		return
+	}
+	if cs.uids[uid] {
+		return
+	}
+	for _, gid := range gids {
+		if gid == cs.gid {
+			return
+		}
+	}
+	return errors.New("uid %d is not allowed", uid)
+}
+
+func (cs *controlServer) serve(ctx context.Context, config *Config, m *mgmtS, logger Logger, conn *net.UnixConn) {
+	defer conn.Close()
+
+	enc := json.NewEncoder(conn)
+	if err := cs.authorize(conn); err != nil {
+		dbglog.WarnContext(ctx, "[control] peer rejected", "err", err)
+		_ = enc.Encode(ControlResponse{Error: "permission denied"})
+		return
+	}
+
+	_ = conn.SetReadDeadline(time.Now().Add(defaultControlTimeout))
+	var req ControlRequest
+	if err := json.NewDecoder(bufio.NewReader(conn)).Decode(&req); err != nil {
+		_ = enc.Encode(ControlResponse{Error: "bad request: " + err.Error()})
+		return
+	}
+
+	dbglog.InfoContext(ctx, "[control] command received", "cmd", req.Cmd)
+	resp := m.handleControl(ctx, config, logger, req)
+	if !resp.OK {
+		dbglog.WarnContext(ctx, "[control] command failed", "cmd", req.Cmd, "err", resp.Error)
+	}
+	_ = conn.SetWriteDeadline(time.Now().Add(defaultControlTimeout))
+	if err := enc.Encode(resp); err != nil {
+		dbglog.DebugContext(ctx, "[control] cannot reply", "cmd", req.Cmd, "err", err)
+	}
+}
+
+// isDaemonBackend reports whether be is the built-in daemon backend,
+// which isn't an init system.
+func isDaemonBackend(be Backend) bool {
+	_, ok := be.(*daemonD)
+	return ok
+}
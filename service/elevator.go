@@ -0,0 +1,200 @@
+package service
+
+import (
+	"bytes"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+
+	"gopkg.in/hedzr/errors.v3"
+
+	"github.com/hedzr/cmdr-addons/v2/tool/dbglog"
+)
+
+// Elevator runs the privileged steps, such as writing the unit files
+// and calling systemctl, with the root privilege.
+type Elevator interface {
+	Name() string
+	// Run runs cmd as root. A non-zero exit code of cmd is returned as
+	// retCode with a nil err, the same as exec.RunWithOutput.
+	Run(cmd string, args ...string) (retCode int, output string, err error)
+}
+
+// ElevatorOptions tunes DetectElevator.
+type ElevatorOptions struct {
+	// Prefer is the tool tried first: "sudo", "doas" or "pkexec".
+	Prefer string
+	// NonInteractive never prompts for a password, and fails fast with
+	// a clear error instead of hanging. It is implied if stdin is not
+	// a terminal.
+	NonInteractive bool
+	// Askpass is the helper program printing the password, for sudo
+	// -A. Default $SUDO_ASKPASS.
+	Askpass string
+}
+
+// ErrElevationUnavailable is returned if the root privilege is required
+// but no elevator tool is found.
+var ErrElevationUnavailable = errors.New("root privilege is required, but none of sudo, doas and pkexec is found. Run it as root")
+
+// DetectElevator picks the elevator for this process: a no-op one if
+// the effective uid is 0, or else the first of sudo, doas and pkexec
+// found in PATH.
+func DetectElevator(opts ElevatorOptions) Elevator {
+	if os.Geteuid() == 0 {
+		return rootElevator{}
+	}
+	if opts.Askpass == "" {
+		opts.Askpass = os.Getenv("SUDO_ASKPASS")
+	}
+	if !opts.NonInteractive && !isTerminal(os.Stdin) {
+		opts.NonInteractive = true
+	}
+
+	tools := []string{"sudo", "doas", "pkexec"}
+	if opts.Prefer != "" {
+		tools = append([]string{opts.Prefer}, tools...)
+	}
+	for _, tool := range tools {
+		if p, err := exec.LookPath(tool); err == nil {
+			return &cmdElevator{name: tool, path: p, opts: opts}
+		}
+	}
+	return noElevator{}
+}
+
+var elevator struct {
+	sync.Mutex
+	Elevator
+}
+
+// SetElevator replaces the elevator used by the backends. nil restores
+// the auto-detected one.
+func SetElevator(e Elevator) {
+	elevator.Lock()
+	defer elevator.Unlock()
+	elevator.Elevator = e
+}
+
+// CurrentElevator returns the elevator used by the backends.
+func CurrentElevator() Elevator {
+	elevator.Lock()
+	defer elevator.Unlock()
+	if elevator.Elevator == nil {
+		elevator.Elevator = DetectElevator(ElevatorOptions{})
+		dbglog.Debug("[elevator] detected", "elevator", elevator.Elevator.Name())
+	}
+	return elevator.Elevator
+}
+
+// elevate runs cmd as root by the current elevator.
+func elevate(cmd string, args ...string) (retCode int, output string, err error) {
+	recordExec(cmd, args)
+	return CurrentElevator().Run(cmd, args...)
+}
+
+// Elevate runs cmd as root by the current elevator, like the backends
+// do. Inside a control operation, such as an EventObserver, it is
+// recorded in the audit entry of the operation.
+func Elevate(cmd string, args ...string) (retCode int, output string, err error) {
+	return elevate(cmd, args...)
+}
+
+// rootElevator runs the commands directly, we are root already.
+type rootElevator struct{}
+
+func (rootElevator) Name() string { return "root" }
+
+func (rootElevator) Run(cmd string, args ...string) (retCode int, output string, err error) {
+	return runCommand(exec.Command(cmd, args...))
+}
+
+// noElevator fails, we are not root and no tool is found.
+type noElevator struct{}
+
+func (noElevator) Name() string { return "none" }
+
+func (noElevator) Run(cmd string, args ...string) (retCode int, output string, err error) {
+	err = errors.New("cannot run %q", strings.Join(append([]string{cmd}, args...), " ")).WithErrors(ErrElevationUnavailable)
+	return
+}
+
+// cmdElevator runs the commands by sudo, doas or pkexec.
+type cmdElevator struct {
+	name string
+	path string
+	opts ElevatorOptions
+}
+
+func (e *cmdElevator) Name() string { return e.name }
+
+func (e *cmdElevator) Run(cmd string, args ...string) (retCode int, output string, err error) {
+	c := exec.Command(e.path, append(e.flags(), append([]string{cmd}, args...)...)...)
+	if e.name == "sudo" && e.opts.Askpass != "" {
+		c.Env = append(os.Environ(), "SUDO_ASKPASS="+e.opts.Askpass)
+	}
+	if !e.opts.NonInteractive {
+		c.Stdin = os.Stdin
+	}
+	if retCode, output, err = runCommand(c); err == nil && retCode != 0 && e.opts.NonInteractive && e.denied(output) {
+		err = errors.New("%s needs a password to run %q, but we are not interactive. "+
+			"Run it as root, allow it without password (NOPASSWD), or specify an askpass helper by SUDO_ASKPASS",
+			e.name, strings.Join(append([]string{cmd}, args...), " "))
+	}
+	return
+}
+
+// flags returns the options of the tool, for the non-interactive mode
+// they make the tool fail instead of prompting.
+func (e *cmdElevator) flags() []string {
+	switch e.name {
+	case "sudo":
+		if e.opts.Askpass != "" {
+			return []string{"-A"}
+		}
+		if e.opts.NonInteractive {
+			return []string{"-n"}
+		}
+	case "doas":
+		if e.opts.NonInteractive {
+			return []string{"-n"}
+		}
+	case "pkexec":
+		if e.opts.NonInteractive {
+			return []string{"--disable-internal-agent"}
+		}
+	}
+	return nil
+}
+
+// denied tells whether the tool failed for the lack of a password,
+// rather than cmd failed.
+func (e *cmdElevator) denied(output string) bool {
+	output = strings.ToLower(output)
+	for _, s := range []string{"password is required", "a terminal is required", "authentication required", "not authorized", "authorization required"} {
+		if strings.Contains(output, s) {
+			return true
+		}
+	}
+	return false
+}
+
+func runCommand(c *exec.Cmd) (retCode int, output string, err error) {
+	var buf bytes.Buffer
+	c.Stdout, c.Stderr = &buf, &buf
+	err = c.Run()
+	output = buf.String()
+	var ee *exec.ExitError
+	if errors.As(err, &ee) {
+		retCode, err = ee.ExitCode(), nil
+	} else if err != nil {
+		retCode = -1
+	}
+	return
+}
+
+func isTerminal(f *os.File) bool {
+	info, err := f.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
@@ -0,0 +1,50 @@
+//go:build !windows && !plan9 && !js
+// +build !windows,!plan9,!js
+
+package service
+
+import (
+	"os"
+	"path"
+	"strings"
+	"testing"
+)
+
+// fakeSudo writes a sudo which asks for a password unless -A is given,
+// and prints its arguments and SUDO_ASKPASS.
+func fakeSudo(t *testing.T) string {
+	file := path.Join(t.TempDir(), "sudo")
+	script := `#!/bin/sh
+if [ "$1" = "-n" ]; then echo "sudo: a password is required" >&2; exit 1; fi
+echo "$@" "$SUDO_ASKPASS"
+`
+	if err := os.WriteFile(file, []byte(script), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	return file
+}
+
+func TestElevator_root(t *testing.T) {
+	code, out, err := rootElevator{}.Run("sh", "-c", "echo hi; exit 3")
+	if err != nil || code != 3 || out != "hi\n" {
+		t.Fatalf("code = %d, out = %q, err = %v", code, out, err)
+	}
+	if _, _, err = (noElevator{}).Run("true"); err == nil {
+		t.Fatal("expect an error without elevator")
+	}
+}
+
+func TestElevator_sudo(t *testing.T) {
+	sudo := fakeSudo(t)
+
+	e := &cmdElevator{name: "sudo", path: sudo, opts: ElevatorOptions{NonInteractive: true}}
+	if _, _, err := e.Run("systemctl", "daemon-reload"); err == nil || !strings.Contains(err.Error(), "SUDO_ASKPASS") {
+		t.Fatalf("expect failing fast, got %v", err)
+	}
+
+	e.opts.Askpass = "/usr/bin/ssh-askpass"
+	code, out, err := e.Run("systemctl", "daemon-reload")
+	if err != nil || code != 0 || out != "-A systemctl daemon-reload /usr/bin/ssh-askpass\n" {
+		t.Fatalf("code = %d, out = %q, err = %v", code, out, err)
+	}
+}
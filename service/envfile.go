@@ -0,0 +1,403 @@
+package service
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/hedzr/is/dir"
+	"gopkg.in/hedzr/errors.v3"
+
+	"github.com/hedzr/cmdr-addons/v2/tool/dbglog"
+)
+
+// EnvFile is an environment file of a service, such as
+// /etc/default/<name>, read by the init system (EnvironmentFile= of
+// systemd) or sourced by the init script.
+//
+// The comments, the blank lines and the quoting of the untouched keys
+// are kept as is when the file is saved.
+type EnvFile struct {
+	Path  string
+	lines []envLine
+}
+
+type envLine struct {
+	raw    string // the original line, or empty if modified
+	key    string // empty for the comments and blank lines
+	value  string // unquoted
+	export bool   // "export KEY=VALUE"
+	quote  byte   // the quote char used, 0 if bare
+}
+
+// LoadEnvFile reads and parses file. An empty EnvFile is returned if
+// the file doesn't exist.
+func LoadEnvFile(file string) (ef *EnvFile, err error) {
+	var data []byte
+	if data, err = os.ReadFile(file); err != nil {
+		if os.IsNotExist(err) {
+			return &EnvFile{Path: file}, nil
+		}
+		return
+	}
+	ef = ParseEnvFile(data)
+	ef.Path = file
+	return
+}
+
+// ParseEnvFile parses the content of an environment file.
+func ParseEnvFile(data []byte) (ef *EnvFile) {
+	ef = &EnvFile{}
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		ef.lines = append(ef.lines, parseEnvLine(scanner.Text()))
+	}
+	return
+}
+
+func parseEnvLine(raw string) (l envLine) {
+	l.raw = raw
+	s := strings.TrimSpace(raw)
+	if s == "" || s[0] == '#' {
+		return
+	}
+	if rest, ok := strings.CutPrefix(s, "export "); ok {
+		l.export, s = true, strings.TrimSpace(rest)
+	}
+	key, value, ok := strings.Cut(s, "=")
+	if !ok || strings.ContainsAny(key, " \t") {
+		return // not an assignment, kept as a comment
+	}
+	l.key = key
+	l.value, l.quote = unquoteEnvValue(strings.TrimSpace(value))
+	return
+}
+
+func unquoteEnvValue(s string) (value string, quote byte) {
+	if len(s) == 0 {
+		return
+	}
+	switch s[0] {
+	case '\'':
+		if i := strings.IndexByte(s[1:], '\''); i >= 0 {
+			return s[1 : i+1], '\''
+		}
+	case '"':
+		var sb strings.Builder
+		for i := 1; i < len(s); i++ {
+			c := s[i]
+			if c == '"' {
+				return sb.String(), '"'
+			}
+			if c == '\\' && i+1 < len(s) && strings.IndexByte("\"\\$`", s[i+1]) >= 0 {
+				i++
+				c = s[i]
+			}
+			sb.WriteByte(c)
+		}
+	}
+	// bare value, strip the trailing comment
+	if i := strings.Index(s, " #"); i >= 0 {
+		s = strings.TrimSpace(s[:i])
+	}
+	return s, 0
+}
+
+func quoteEnvValue(value string, quote byte) string {
+	if quote == 0 && (value == "" || strings.ContainsAny(value, " \t#'\"\\$`;&|<>(){}*?[]~")) {
+		quote = '"'
+	}
+	switch quote {
+	case '\'':
+		if !strings.Contains(value, "'") {
+			return "'" + value + "'"
+		}
+		fallthrough
+	case '"':
+		var sb strings.Builder
+		sb.WriteByte('"')
+		for i := 0; i < len(value); i++ {
+			if strings.IndexByte("\"\\$`", value[i]) >= 0 {
+				sb.WriteByte('\\')
+			}
+			sb.WriteByte(value[i])
+		}
+		sb.WriteByte('"')
+		return sb.String()
+	}
+	return value
+}
+
+func (l envLine) String() string {
+	if l.raw != "" || l.key == "" {
+		return l.raw
+	}
+	s := l.key + "=" + quoteEnvValue(l.value, l.quote)
+	if l.export {
+		s = "export " + s
+	}
+	return s
+}
+
+// Get returns the value of key.
+func (ef *EnvFile) Get(key string) (value string, ok bool) {
+	for _, l := range ef.lines {
+		if l.key == key {
+			value, ok = l.value, true // the last one wins
+		}
+	}
+	return
+}
+
+// Set updates key in place, keeping its quoting, or appends it. It
+// reports whether the file was changed.
+func (ef *EnvFile) Set(key, value string) (changed bool) {
+	found := false
+	for i := range ef.lines {
+		if l := &ef.lines[i]; l.key == key {
+			found = true
+			if l.value != value {
+				l.value, l.raw, changed = value, "", true
+			}
+		}
+	}
+	if !found {
+		ef.lines = append(ef.lines, envLine{key: key, value: value})
+		changed = true
+	}
+	return
+}
+
+// Unset removes key, and reports whether it was present.
+func (ef *EnvFile) Unset(key string) (changed bool) {
+	lines := ef.lines[:0]
+	for _, l := range ef.lines {
+		if l.key == key {
+			changed = true
+			continue
+		}
+		lines = append(lines, l)
+	}
+	ef.lines = lines
+	return
+}
+
+// Keys returns the keys in the order of the file.
+func (ef *EnvFile) Keys() (keys []string) {
+	seen := make(map[string]bool)
+	for _, l := range ef.lines {
+		if l.key != "" && !seen[l.key] {
+			seen[l.key] = true
+			keys = append(keys, l.key)
+		}
+	}
+	return
+}
+
+// Map returns the keys and values.
+func (ef *EnvFile) Map() (m map[string]string) {
+	m = make(map[string]string)
+	for _, l := range ef.lines {
+		if l.key != "" {
+			m[l.key] = l.value
+		}
+	}
+	return
+}
+
+// Bytes returns the content of the file.
+func (ef *EnvFile) Bytes() []byte {
+	var buf bytes.Buffer
+	for _, l := range ef.lines {
+		buf.WriteString(l.String())
+		buf.WriteByte('\n')
+	}
+	return buf.Bytes()
+}
+
+// Save writes the file back to Path. sudo is used if we have no
+// permission to write it.
+func (ef *EnvFile) Save() (err error) {
+	mode := os.FileMode(0o644)
+	if info, e := os.Stat(ef.Path); e == nil {
+		mode = info.Mode().Perm() // it may keep secrets
+	}
+	if err = dir.EnsureDir(path.Dir(ef.Path)); err == nil {
+		if err = writeFileAtomic(ef.Path, ef.Bytes(), mode); err == nil || !os.IsPermission(err) {
+			return
+		}
+	}
+
+	var f *os.File
+	if f, err = os.CreateTemp("", path.Base(ef.Path)+".*.env"); err != nil {
+		return
+	}
+	defer os.Remove(f.Name())
+	_, err = f.Write(ef.Bytes())
+	if e := f.Close(); err == nil {
+		err = e
+	}
+	if err == nil {
+		if err = elevateE("mkdir", "-p", path.Dir(ef.Path)); err == nil {
+			err = elevateE("install", "-m", fmt.Sprintf("%04o", mode), f.Name(), ef.Path)
+		}
+	}
+	if err != nil {
+		err = errors.New("failed to save env file %q", ef.Path).WithErrors(err)
+	}
+	return
+}
+
+// EnvFilePath returns the environment file of this service, it is
+// EnvFile if specified, or else <dir>/<name> where dir depends on the
+// distro, see envFileDir.
+func (e *Config) EnvFilePath() string {
+	if e.EnvFile != "" {
+		return e.EnvFile
+	}
+	return path.Join(envFileDir(), e.ServiceBareName())
+}
+
+// envFileDir returns the directory of the environment files of the
+// services on this distro: /etc/default for the Debian family,
+// /etc/sysconfig for the Red Hat and SUSE families, and /etc/conf.d for
+// the OpenRC and Arch ones.
+func envFileDir() string {
+	data, _ := os.ReadFile("/etc/os-release")
+	if d := envFileDirOf(string(data)); d != "" {
+		return d
+	}
+	for _, d := range []string{"/etc/default", "/etc/sysconfig", "/etc/conf.d"} {
+		if dir.FileExists(d) {
+			return d
+		}
+	}
+	return "/etc/default"
+}
+
+// envFileDirOf picks the directory by ID and ID_LIKE of os-release(5).
+func envFileDirOf(osRelease string) string {
+	var ids []string
+	for _, line := range strings.Split(osRelease, "\n") {
+		if k, v, ok := strings.Cut(strings.TrimSpace(line), "="); ok && (k == "ID" || k == "ID_LIKE") {
+			v, _ = unquoteEnvValue(v)
+			ids = append(ids, strings.Fields(v)...)
+		}
+	}
+	for _, id := range ids {
+		switch id {
+		case "debian", "ubuntu":
+			return "/etc/default"
+		case "rhel", "fedora", "centos", "suse", "opensuse", "amzn":
+			return "/etc/sysconfig"
+		case "alpine", "gentoo", "arch":
+			return "/etc/conf.d"
+		}
+	}
+	return ""
+}
+
+// SyncEnvFile writes Config.Env into the environment file of the
+// service, the other keys are kept. It reports whether the file was
+// changed.
+func SyncEnvFile(ctx context.Context, config *Config) (changed bool, err error) {
+	var ef *EnvFile
+	if ef, err = LoadEnvFile(config.EnvFilePath()); err != nil {
+		return
+	}
+	keys := sortedKeys(config.Env)
+	for _, k := range keys {
+		if strings.ContainsAny(config.Env[k], "\r\n") {
+			err = errors.New("the value of %q has a line break, which an env file cannot hold", k)
+			return
+		}
+	}
+	for _, k := range keys {
+		if ef.Set(k, config.Env[k]) {
+			changed = true
+		}
+	}
+	if changed {
+		dbglog.InfoContext(ctx, "[env] env file synced", "file", ef.Path, "keys", keys)
+		err = ef.Save()
+	}
+	return
+}
+
+func sortedKeys(m map[string]string) (keys []string) {
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return
+}
+
+// env implements the EnvGet, EnvSet, EnvUnset and EnvList commands,
+// the keys (and "KEY=VALUE" for EnvSet) are taken from
+// Config.PositionalArgs. After the file changed, the service is
+// reloaded or restarted if Config.EnvApply asks.
+func (s *mgmtS) env(ctx context.Context, config *Config, cmd Command) (err error) {
+	var ef *EnvFile
+	if ef, err = LoadEnvFile(config.EnvFilePath()); err != nil {
+		return
+	}
+
+	changed := false
+	switch cmd {
+	case EnvList:
+		for _, k := range ef.Keys() {
+			v, _ := ef.Get(k)
+			fmt.Printf("%s=%s\n", k, quoteEnvValue(v, 0))
+		}
+		return
+	case EnvGet:
+		for _, k := range config.PositionalArgs {
+			v, ok := ef.Get(k)
+			if !ok {
+				config.RetCode = 1
+				err = errors.New("%q is not set in %q", k, ef.Path)
+				return
+			}
+			fmt.Println(v)
+		}
+		return
+	case EnvSet:
+		for _, kv := range config.PositionalArgs {
+			k, v, ok := strings.Cut(kv, "=")
+			if !ok || k == "" {
+				return errors.New("expect KEY=VALUE, got %q", kv)
+			}
+			if strings.ContainsAny(v, "\r\n") {
+				return errors.New("the value of %q has a line break, which an env file cannot hold", k)
+			}
+			if ef.Set(k, v) {
+				changed = true
+			}
+		}
+	case EnvUnset:
+		for _, k := range config.PositionalArgs {
+			if ef.Unset(k) {
+				changed = true
+			}
+		}
+	}
+
+	if !changed {
+		println("env file unchanged:", ef.Path)
+		return
+	}
+	if err = ef.Save(); err != nil {
+		return
+	}
+	println("env file updated:", ef.Path)
+
+	if config.EnvApply == HotReload || config.EnvApply == Restart {
+		err = s.Control(ctx, config, config.EnvApply)
+	}
+	return
+}
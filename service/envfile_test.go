@@ -0,0 +1,106 @@
+package service
+
+import (
+	"context"
+	"os"
+	"path"
+	"testing"
+)
+
+const sampleEnvFile = `### demo configurations
+
+# PORT=3211
+GLOBAL_OPTIONS=""
+OPTIONS='--port 3211'   
+export TOKEN="a \"b\" \$c"
+LEVEL=info # the log level
+`
+
+func TestEnvFile_edit(t *testing.T) {
+	ef := ParseEnvFile([]byte(sampleEnvFile))
+	if got := ef.Keys(); len(got) != 4 {
+		t.Fatalf("bad keys: %v", got)
+	}
+	for k, want := range map[string]string{"GLOBAL_OPTIONS": "", "OPTIONS": "--port 3211", "TOKEN": `a "b" $c`, "LEVEL": "info"} {
+		if v, ok := ef.Get(k); !ok || v != want {
+			t.Fatalf("%s = %q, want %q", k, v, want)
+		}
+	}
+	if string(ef.Bytes()) != sampleEnvFile {
+		t.Fatalf("the untouched file is changed:\n%s", ef.Bytes())
+	}
+
+	if ef.Set("LEVEL", "info") {
+		t.Fatal("expect unchanged")
+	}
+	ef.Set("OPTIONS", "--port 8080")
+	ef.Set("TOKEN", "x$y")
+	ef.Set("NEW", "two words")
+	ef.Unset("GLOBAL_OPTIONS")
+	want := `### demo configurations
+
+# PORT=3211
+OPTIONS='--port 8080'
+export TOKEN="x\$y"
+LEVEL=info # the log level
+NEW="two words"
+`
+	if got := string(ef.Bytes()); got != want {
+		t.Fatalf("bad file:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func TestEnvFileDir(t *testing.T) {
+	for osRelease, want := range map[string]string{
+		"NAME=\"Ubuntu\"\nID=ubuntu\nID_LIKE=debian\n":   "/etc/default",
+		"ID=\"rocky\"\nID_LIKE=\"rhel centos fedora\"\n": "/etc/sysconfig",
+		"ID=opensuse-leap\nID_LIKE=\"suse opensuse\"\n":  "/etc/sysconfig",
+		"ID=alpine\n": "/etc/conf.d",
+		"ID=nixos\n":  "",
+	} {
+		if got := envFileDirOf(osRelease); got != want {
+			t.Fatalf("envFileDirOf(%q) = %q, want %q", osRelease, got, want)
+		}
+	}
+}
+
+func TestEnvCommands(t *testing.T) {
+	file := path.Join(t.TempDir(), "demo")
+	if err := os.WriteFile(file, []byte("# comment\nA=1\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	config := &Config{Name: "demo", EnvFile: file, Env: map[string]string{"B": "2"}}
+
+	if changed, err := SyncEnvFile(ctx, config); err != nil || !changed {
+		t.Fatalf("sync: changed = %v, err = %v", changed, err)
+	}
+
+	m := &mgmtS{}
+	config.PositionalArgs = []string{"C=3 4"}
+	if err := m.env(ctx, config, EnvSet); err != nil {
+		t.Fatal(err)
+	}
+	config.PositionalArgs = []string{"D=5\nE=6"}
+	if err := m.env(ctx, config, EnvSet); err == nil {
+		t.Fatal("expect a value with a line break refused")
+	}
+	config.PositionalArgs = []string{"A"}
+	if err := m.env(ctx, config, EnvUnset); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.env(ctx, config, EnvGet); err == nil || config.RetCode != 1 {
+		t.Fatalf("expect A unset, got %v", err)
+	}
+
+	data, err := os.ReadFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != "# comment\nB=2\nC=\"3 4\"\n" {
+		t.Fatalf("bad env file:\n%s", data)
+	}
+	if info, _ := os.Stat(file); info.Mode().Perm() != 0o600 {
+		t.Fatalf("the mode is not kept: %v", info.Mode())
+	}
+}
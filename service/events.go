@@ -0,0 +1,192 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"gopkg.in/hedzr/errors.v3"
+
+	"github.com/hedzr/cmdr-addons/v2/tool/dbglog"
+)
+
+// EventKind is the kind of a lifecycle event, see Manager.OnEvent.
+type EventKind int
+
+const (
+	BeforeInstall EventKind = iota + 1
+	AfterInstall
+	BeforeUninstall
+	AfterUninstall
+	BeforeStart
+	Started // the service started, or is ready in service mode
+	StopRequested
+	Stopped
+	BeforeReload
+	Reloaded
+	Failed // the operation failed or was vetoed, see Event.Err
+)
+
+var eventKindNames = map[EventKind]string{
+	BeforeInstall:   "BeforeInstall",
+	AfterInstall:    "AfterInstall",
+	BeforeUninstall: "BeforeUninstall",
+	AfterUninstall:  "AfterUninstall",
+	BeforeStart:     "BeforeStart",
+	Started:         "Started",
+	StopRequested:   "StopRequested",
+	Stopped:         "Stopped",
+	BeforeReload:    "BeforeReload",
+	Reloaded:        "Reloaded",
+	Failed:          "Failed",
+}
+
+func (k EventKind) String() string {
+	if s, ok := eventKindNames[k]; ok {
+		return s
+	}
+	return fmt.Sprintf("Event{%d}", int(k))
+}
+
+// IsBefore reports whether the observers can veto the operation.
+func (k EventKind) IsBefore() bool {
+	return k == BeforeInstall || k == BeforeUninstall || k == BeforeStart || k == StopRequested || k == BeforeReload
+}
+
+// Event is a lifecycle event of the service.
+type Event struct {
+	Kind     EventKind
+	Command  Command
+	Config   *Config
+	Backend  string        // such as "systemd", "launchd", "ntservice" or "daemon"
+	Duration time.Duration // the time the operation took, for the after events and Failed
+	Err      error         // the failure, for Failed
+}
+
+func (ev Event) String() string {
+	s := fmt.Sprintf("%v %v of %q by %s", ev.Kind, ev.Command, ev.Config.ServiceName(), ev.Backend)
+	if ev.Duration > 0 {
+		s += fmt.Sprintf(" in %v", ev.Duration)
+	}
+	if ev.Err != nil {
+		s += fmt.Sprintf(": %v", ev.Err)
+	}
+	return s
+}
+
+// EventObserver observes the lifecycle events. An error returned for
+// a before event (see EventKind.IsBefore) vetoes the operation, it is
+// logged for the others.
+type EventObserver func(ctx context.Context, ev Event) error
+
+// VetoError is returned by Control if an observer vetoed the command.
+type VetoError struct {
+	Event Event
+	Err   error
+}
+
+func (e *VetoError) Error() string {
+	return fmt.Sprintf("%v of %q vetoed at %v: %v", e.Event.Command, e.Event.Config.ServiceName(), e.Event.Kind, e.Err)
+}
+func (e *VetoError) Unwrap() error { return e.Err }
+
+// OnEvent registers an observer of the lifecycle events, they are
+// called in the order registered.
+func (s *mgmtS) OnEvent(observer EventObserver) Manager {
+	s.hooksMu.Lock()
+	defer s.hooksMu.Unlock()
+	s.observers = append(s.observers, observer)
+	return s
+}
+
+// emit calls the observers. For a before event, the first error stops
+// it and is returned as a *VetoError.
+func (s *mgmtS) emit(ctx context.Context, ev Event) (err error) {
+	s.hooksMu.Lock()
+	observers := append([]EventObserver(nil), s.observers...)
+	s.hooksMu.Unlock()
+
+	for _, fn := range observers {
+		e := s.callObserver(ctx, fn, ev)
+		if e == nil {
+			continue
+		}
+		if ev.Kind.IsBefore() {
+			return &VetoError{Event: ev, Err: e}
+		}
+		dbglog.WarnContext(ctx, "[events] observer failed", "event", ev.Kind, "cmd", ev.Command, "err", e)
+	}
+	return
+}
+
+func (s *mgmtS) callObserver(ctx context.Context, fn EventObserver, ev Event) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = errors.New("observer panicked: %v", r)
+		}
+	}()
+	return fn(ctx, ev)
+}
+
+// commandEvents maps the commands to their before and after events.
+var commandEvents = map[Command][2]EventKind{
+	Install:   {BeforeInstall, AfterInstall},
+	Uninstall: {BeforeUninstall, AfterUninstall},
+	Start:     {BeforeStart, Started},
+	Stop:      {StopRequested, Stopped},
+	Restart:   {BeforeStart, Started},
+	HotReload: {BeforeReload, Reloaded},
+}
+
+// observe emits the before event of cmd, and returns done to emit the
+// after event, or Failed if the command failed. A vetoed command emits
+// Failed and returns the *VetoError. done is a no-op for the commands
+// without events.
+//
+// In foreground mode, Start runs the service till it ends, so Started
+// is emitted by serveLoop once the service is ready, and Stopped by
+// done.
+func (s *mgmtS) observe(ctx context.Context, config *Config, cmd Command) (done func(err error), err error) {
+	kinds, ok := commandEvents[cmd]
+	if !ok {
+		return func(error) {}, nil
+	}
+	after := kinds[1]
+	if cmd == Start && s.fore {
+		after = Stopped
+	}
+
+	ev := Event{Kind: kinds[0], Command: cmd, Config: config, Backend: s.backend}
+	if err = s.emit(ctx, ev); err != nil {
+		ev.Kind, ev.Err = Failed, err
+		s.metrics.record(cmd, 0, err)
+		_ = s.emit(ctx, ev)
+		return
+	}
+
+	begin := time.Now()
+	done = func(err error) {
+		ev.Kind, ev.Duration, ev.Err = after, time.Since(begin), err
+		s.metrics.record(cmd, ev.Duration, err)
+		if err != nil {
+			ev.Kind = Failed
+		}
+		_ = s.emit(ctx, ev)
+	}
+	return
+}
+
+// hotReload reloads the entity in the service process, for a signal or
+// the control socket, between BeforeReload and Reloaded.
+func (s *mgmtS) hotReload(ctx context.Context, config *Config, logger Logger) (err error) {
+	fn, ok := config.Entity.(EntityHotReloadAware)
+	if !ok {
+		return errors.New("the entity doesn't support hot-reload")
+	}
+	var done func(error)
+	if done, err = s.observe(ctx, config, HotReload); err != nil {
+		return
+	}
+	defer func() { done(err) }()
+	return fn.HotReload(ctx, config, logger)
+}
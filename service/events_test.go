@@ -0,0 +1,100 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+)
+
+type reloadEntity struct {
+	shutdownEntity
+	reloads int
+}
+
+func (e *reloadEntity) HotReload(ctx context.Context, config *Config, logger Logger) (err error) {
+	e.reloads++
+	return
+}
+
+func TestEvents(t *testing.T) {
+	ctx := context.Background()
+	config := &Config{Name: "demo"}
+	m := &mgmtS{backend: "systemd"}
+
+	var got []string
+	m.OnEvent(func(ctx context.Context, ev Event) error {
+		got = append(got, ev.Kind.String())
+		if ev.Kind == Failed && ev.Err == nil {
+			t.Errorf("Failed without Err: %v", ev)
+		}
+		if ev.Backend != "systemd" || ev.Config != config {
+			t.Errorf("bad event: %v", ev)
+		}
+		return nil
+	})
+	m.OnEvent(func(ctx context.Context, ev Event) error {
+		if ev.Kind == BeforeUninstall {
+			return errors.New("production lock")
+		}
+		if ev.Kind == AfterInstall {
+			panic("observer bug") // logged only
+		}
+		return nil
+	})
+
+	done, err := m.observe(ctx, config, Install)
+	if err != nil {
+		t.Fatal(err)
+	}
+	done(nil)
+
+	_, err = m.observe(ctx, config, Uninstall)
+	var ve *VetoError
+	if !errors.As(err, &ve) || ve.Event.Kind != BeforeUninstall || !strings.Contains(err.Error(), "production lock") {
+		t.Fatalf("expect vetoed, got %v", err)
+	}
+
+	if done, err = m.observe(ctx, config, Stop); err != nil {
+		t.Fatal(err)
+	}
+	done(errors.New("timeout"))
+
+	if done, err = m.observe(ctx, config, Status); err != nil {
+		t.Fatal(err)
+	}
+	done(nil) // no events
+
+	want := "BeforeInstall AfterInstall BeforeUninstall Failed StopRequested Failed"
+	if s := strings.Join(got, " "); s != want {
+		t.Fatalf("expect events\n  %s\ngot\n  %s", want, s)
+	}
+}
+
+func TestEvents_hotReload(t *testing.T) {
+	ctx := context.Background()
+	entity := &reloadEntity{}
+	config := &Config{Name: "demo", Entity: entity}
+	m := &mgmtS{}
+
+	veto := true
+	var got []string
+	m.OnEvent(func(ctx context.Context, ev Event) error {
+		got = append(got, ev.Kind.String())
+		if ev.Kind == BeforeReload && veto {
+			return errors.New("busy")
+		}
+		return nil
+	})
+
+	if err := m.hotReload(ctx, config, nil); err == nil || entity.reloads != 0 {
+		t.Fatalf("expect vetoed, got %v, reloads = %d", err, entity.reloads)
+	}
+	veto = false
+	if err := m.hotReload(ctx, config, nil); err != nil || entity.reloads != 1 {
+		t.Fatalf("reload: %v, reloads = %d", err, entity.reloads)
+	}
+	if s := strings.Join(got, " "); s != "BeforeReload Failed BeforeReload Reloaded" {
+		t.Fatalf("bad events: %s", s)
+	}
+}
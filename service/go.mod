@@ -14,9 +14,11 @@ require (
 	github.com/hedzr/cmdr-addons/v2 v2.2.3
 	github.com/hedzr/is v0.9.5
 	github.com/hedzr/logg v0.9.3
+	github.com/pelletier/go-toml/v2 v2.3.1
 	github.com/stretchr/testify v1.11.1
 	golang.org/x/sys v0.47.0
 	gopkg.in/hedzr/errors.v3 v3.3.5
+	gopkg.in/yaml.v3 v3.0.1
 )
 
 require (
@@ -27,5 +29,4 @@ require (
 	golang.org/x/net v0.57.0 // indirect
 	golang.org/x/term v0.45.0 // indirect
 	gopkg.in/check.v1 v1.0.0-20201130134442-10cb98267c6c // indirect
-	gopkg.in/yaml.v3 v3.0.1 // indirect
 )
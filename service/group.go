@@ -0,0 +1,252 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"gopkg.in/hedzr/errors.v3"
+
+	"github.com/hedzr/cmdr-addons/service/v2/render"
+	"github.com/hedzr/cmdr-addons/v2/tool/dbglog"
+)
+
+// ServiceGroup is a set of cooperating services, ordered by their
+// Dependencies. See Manager.Group.
+//
+// Install, Start, Restart and Enable run in the dependency order, a
+// service is skipped if one of its Dependencies failed. Stop, Uninstall
+// and Disable run in the reverse order.
+type ServiceGroup struct {
+	Name        string
+	Description string
+
+	// Target installs an umbrella unit <Name>.target on systemd, which
+	// wants all the services of the group, so the group can be
+	// started or stopped as a whole by systemctl.
+	Target bool
+
+	m       *mgmtS
+	configs []*Config // in dependency order
+}
+
+// GroupResult is the result of a command on a service of the group.
+type GroupResult struct {
+	Name    string
+	Skipped bool // a dependency failed
+	Err     error
+}
+
+func (r GroupResult) String() string {
+	switch {
+	case r.Skipped:
+		return fmt.Sprintf("%s: skipped, %v", r.Name, r.Err)
+	case r.Err != nil:
+		return fmt.Sprintf("%s: failed, %v", r.Name, r.Err)
+	}
+	return r.Name + ": ok"
+}
+
+// GroupReport is the result of a command on the group, one entry per
+// service in the order they were handled.
+type GroupReport struct {
+	Cmd     Command
+	Results []GroupResult
+}
+
+func (r GroupReport) String() string {
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "%v:", r.Cmd)
+	for _, res := range r.Results {
+		fmt.Fprintf(&sb, "\n  %s", res.String())
+	}
+	return sb.String()
+}
+
+// Err returns the errors of the failed services, or nil.
+func (r GroupReport) Err() error {
+	var errs []error
+	for _, res := range r.Results {
+		if res.Err != nil {
+			errs = append(errs, errors.New("%s", res.String()))
+		}
+	}
+	if len(errs) == 0 {
+		return nil
+	}
+	return errors.New("%v failed for %d service(s)", r.Cmd, len(errs)).WithErrors(errs...)
+}
+
+// Group resolves the dependencies of configs and returns the group.
+// The Dependencies naming a service outside the group are left to the
+// init system. An error is returned if the services depend on each
+// other in a cycle.
+func (s *mgmtS) Group(name string, configs ...*Config) (g *ServiceGroup, err error) {
+	g = &ServiceGroup{Name: name, m: s}
+	if g.configs, err = sortByDependencies(configs); err != nil {
+		g = nil
+	}
+	return
+}
+
+// Configs returns the services in the dependency order.
+func (g *ServiceGroup) Configs() []*Config { return g.configs }
+
+func (g *ServiceGroup) Install(ctx context.Context) GroupReport   { return g.Control(ctx, Install) }
+func (g *ServiceGroup) Uninstall(ctx context.Context) GroupReport { return g.Control(ctx, Uninstall) }
+func (g *ServiceGroup) Start(ctx context.Context) GroupReport     { return g.Control(ctx, Start) }
+func (g *ServiceGroup) Stop(ctx context.Context) GroupReport      { return g.Control(ctx, Stop) }
+
+// Control runs cmd on each service of the group, see ServiceGroup.
+func (g *ServiceGroup) Control(ctx context.Context, cmd Command) (report GroupReport) {
+	report.Cmd = cmd
+	reverse := cmd == Stop || cmd == Uninstall || cmd == Disable
+
+	configs := g.configs
+	if reverse {
+		configs = make([]*Config, len(g.configs))
+		for i, c := range g.configs {
+			configs[len(configs)-1-i] = c
+		}
+	}
+
+	if cmd == Install && g.Target {
+		for _, c := range configs {
+			c.Target = g.TargetName()
+		}
+	}
+
+	failed := make(map[string]bool)
+	for _, c := range configs {
+		res := GroupResult{Name: c.BaseName()}
+		if !reverse {
+			for _, dep := range c.Dependencies {
+				if failed[dep] {
+					res.Skipped, res.Err = true, errors.New("dependency %q failed", dep)
+					break
+				}
+			}
+		}
+		if !res.Skipped {
+			dbglog.InfoContext(ctx, "[group] control service", "group", g.Name, "service", res.Name, "cmd", cmd)
+			res.Err = g.m.Control(ctx, c, cmd)
+		}
+		if res.Err != nil {
+			failed[res.Name] = true
+		}
+		report.Results = append(report.Results, res)
+	}
+
+	if g.Target && (cmd == Install || cmd == Uninstall) {
+		res := GroupResult{Name: g.TargetName()}
+		res.Err = g.controlTarget(ctx, cmd)
+		report.Results = append(report.Results, res)
+	}
+	return
+}
+
+// TargetName returns the name of the umbrella target unit.
+func (g *ServiceGroup) TargetName() string { return g.Name + ".target" }
+
+// Units returns the unit names of the services of the group.
+func (g *ServiceGroup) Units() (units []string) {
+	for _, c := range g.configs {
+		units = append(units, c.ServiceName())
+	}
+	return
+}
+
+// groupTargetInstaller is implemented by the backends which support
+// the umbrella target of a group.
+type groupTargetInstaller interface {
+	installTarget(ctx context.Context, g *ServiceGroup) (err error)
+	uninstallTarget(ctx context.Context, g *ServiceGroup) (err error)
+}
+
+func (g *ServiceGroup) controlTarget(ctx context.Context, cmd Command) (err error) {
+	var be Backend
+	if be, err = g.m.chooseBackend(ctx); err != nil {
+		return
+	}
+	ti, ok := be.(groupTargetInstaller)
+	if !ok {
+		dbglog.InfoContext(ctx, "[group] the backend has no umbrella target, skipped", "backend", be)
+		return
+	}
+	if cmd == Install {
+		return ti.installTarget(ctx, g)
+	}
+	return ti.uninstallTarget(ctx, g)
+}
+
+// sortByDependencies sorts configs topologically, keeping the given
+// order among independent services.
+func sortByDependencies(configs []*Config) (sorted []*Config, err error) {
+	byName := make(map[string]*Config, len(configs))
+	for _, c := range configs {
+		name := c.BaseName()
+		if _, ok := byName[name]; ok {
+			return nil, errors.New("duplicated service %q in group", name)
+		}
+		byName[name] = c
+	}
+
+	const (
+		unvisited = iota
+		visiting
+		visited
+	)
+	state := make(map[string]int, len(configs))
+	var stack []string
+	var visit func(c *Config) error
+	visit = func(c *Config) error {
+		name := c.BaseName()
+		switch state[name] {
+		case visited:
+			return nil
+		case visiting:
+			i := len(stack) - 1
+			for i > 0 && stack[i] != name {
+				i--
+			}
+			return errors.New("dependency cycle: %s", strings.Join(append(stack[i:], name), " -> "))
+		}
+		state[name] = visiting
+		stack = append(stack, name)
+		for _, dep := range c.allDependencies() {
+			if d, ok := byName[dep]; ok {
+				if e := visit(d); e != nil {
+					return e
+				}
+			}
+		}
+		stack = stack[:len(stack)-1]
+		state[name] = visited
+		sorted = append(sorted, c)
+		return nil
+	}
+
+	for _, c := range configs {
+		if err = visit(c); err != nil {
+			return nil, err
+		}
+	}
+	return
+}
+
+// allDependencies returns Dependencies and WeakDependencies, both of
+// them order the services.
+func (e *Config) allDependencies() []string {
+	return append(append([]string(nil), e.Dependencies...), e.WeakDependencies...)
+}
+
+// unitName returns the systemd unit of a dependency, ".service" is
+// appended if it has no unit suffix.
+func unitName(dep string) string { return render.UnitName(dep) }
+
+func unitNames(deps []string) (units []string) {
+	for _, dep := range deps {
+		units = append(units, unitName(dep))
+	}
+	return
+}
@@ -0,0 +1,69 @@
+package service
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func groupNames(configs []*Config) (names []string) {
+	for _, c := range configs {
+		names = append(names, c.Name)
+	}
+	return
+}
+
+func TestGroup_order(t *testing.T) {
+	m := &mgmtS{}
+	g, err := m.Group("product",
+		&Config{Name: "web", Dependencies: []string{"api"}},
+		&Config{Name: "api", Dependencies: []string{"db", "postgresql"}, WeakDependencies: []string{"cache"}},
+		&Config{Name: "cache"},
+		&Config{Name: "db"},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.Join(groupNames(g.Configs()), ","); got != "db,cache,api,web" {
+		t.Fatalf("bad order: %s", got)
+	}
+	if got := strings.Join(g.Units(), " "); got != "db.service cache.service api.service web.service" {
+		t.Fatalf("bad units: %s", got)
+	}
+	if got := unitNames([]string{"postgresql", "network-online.target"}); got[0] != "postgresql.service" || got[1] != "network-online.target" {
+		t.Fatalf("bad unit names: %v", got)
+	}
+}
+
+func TestGroup_cycle(t *testing.T) {
+	m := &mgmtS{}
+	_, err := m.Group("product",
+		&Config{Name: "a", Dependencies: []string{"b"}},
+		&Config{Name: "b", WeakDependencies: []string{"c"}},
+		&Config{Name: "c", Dependencies: []string{"a"}},
+	)
+	if err == nil || !strings.Contains(err.Error(), "a -> b -> c -> a") {
+		t.Fatalf("expect a dependency cycle, got %v", err)
+	}
+
+	if _, err = m.Group("product", &Config{Name: "a"}, &Config{Name: "a"}); err == nil {
+		t.Fatal("expect an error for duplicated services")
+	}
+}
+
+func TestGroupReport(t *testing.T) {
+	r := GroupReport{Cmd: Start, Results: []GroupResult{
+		{Name: "db"},
+		{Name: "api", Err: errors.New("boom")},
+		{Name: "web", Skipped: true, Err: errors.New(`dependency "api" failed`)},
+	}}
+	if r.Err() == nil {
+		t.Fatal("expect an error")
+	}
+	if s := r.String(); !strings.Contains(s, "api: failed, boom") || !strings.Contains(s, "web: skipped") {
+		t.Fatalf("bad report: %s", s)
+	}
+	if (GroupReport{Cmd: Stop, Results: []GroupResult{{Name: "db"}}}).Err() != nil {
+		t.Fatal("expect no error")
+	}
+}
@@ -0,0 +1,360 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+
+	"gopkg.in/hedzr/errors.v3"
+
+	"github.com/hedzr/cmdr-addons/v2/tool/dbglog"
+)
+
+// EntityHealthAware is implemented by the entity which can report its
+// own health. The checks returned are merged with the probes of
+// Config.Health.
+type EntityHealthAware interface {
+	Health(ctx context.Context, config *Config, logger Logger) (report HealthReport, err error)
+}
+
+// ProbeKind tells whether a failed check means the service should be
+// restarted (liveness), or just not be sent traffic (readiness).
+type ProbeKind string
+
+const (
+	Liveness  ProbeKind = "liveness"
+	Readiness ProbeKind = "readiness"
+)
+
+// Probe is a built-in health check. Exactly one of HTTP, TCP and Exec
+// should be specified.
+type Probe struct {
+	Name    string        `json:"name,omitempty" yaml:"name,omitempty" toml:"name,omitempty"`
+	HTTP    string        `json:"http,omitempty" yaml:"http,omitempty" toml:"http,omitempty"`          // GET the url, 2xx and 3xx are healthy
+	TCP     string        `json:"tcp,omitempty" yaml:"tcp,omitempty" toml:"tcp,omitempty"`             // dial the address, such as "localhost:8080"
+	Exec    []string      `json:"exec,omitempty" yaml:"exec,omitempty" toml:"exec,omitempty"`          // run the command, exit code 0 is healthy
+	Timeout time.Duration `json:"timeout,omitempty" yaml:"timeout,omitempty" toml:"timeout,omitempty"` // default HealthConfig.Timeout
+}
+
+// HealthConfig configures the health checks and the health monitor
+// which runs in service mode.
+type HealthConfig struct {
+	Liveness  []Probe `json:"liveness,omitempty" yaml:"liveness,omitempty" toml:"liveness,omitempty"`
+	Readiness []Probe `json:"readiness,omitempty" yaml:"readiness,omitempty" toml:"readiness,omitempty"`
+
+	Interval time.Duration `json:"interval,omitempty" yaml:"interval,omitempty" toml:"interval,omitempty"` // the monitor checks every Interval, default 30s
+	Timeout  time.Duration `json:"timeout,omitempty" yaml:"timeout,omitempty" toml:"timeout,omitempty"`    // the default timeout of a probe, default 5s
+
+	// RestartOnFailure quits the service with a failure code after
+	// FailureThreshold (default 3) consecutive liveness failures, so
+	// that the init system or the supervisor restarts it.
+	RestartOnFailure bool `json:"restart_on_failure,omitempty" yaml:"restart_on_failure,omitempty" toml:"restart_on_failure,omitempty"`
+	FailureThreshold int  `json:"failure_threshold,omitempty" yaml:"failure_threshold,omitempty" toml:"failure_threshold,omitempty"`
+
+	// WatchdogSec generates WatchdogSec= for systemd. The monitor
+	// pings the watchdog only while the service is live.
+	WatchdogSec string `json:"watchdog_sec,omitempty" yaml:"watchdog_sec,omitempty" toml:"watchdog_sec,omitempty"`
+}
+
+// HealthCheck is the result of a single check.
+type HealthCheck struct {
+	Name    string        `json:"name"`
+	Kind    ProbeKind     `json:"kind"`
+	OK      bool          `json:"ok"`
+	Message string        `json:"message,omitempty"`
+	Elapsed time.Duration `json:"elapsed"`
+}
+
+// HealthReport is the structured health of the service.
+//
+// The service is live if all liveness checks passed, and ready if it
+// is live and all readiness checks passed.
+type HealthReport struct {
+	Pid    int           `json:"pid,omitempty"` // the process reported it
+	Time   time.Time     `json:"time"`
+	Live   bool          `json:"live"`
+	Ready  bool          `json:"ready"`
+	Checks []HealthCheck `json:"checks,omitempty"`
+}
+
+// Healthy reports whether the service is live and ready.
+func (r HealthReport) Healthy() bool { return r.Live && r.Ready }
+
+func (r HealthReport) String() string {
+	var sb strings.Builder
+	state := "healthy"
+	if !r.Live {
+		state = "unhealthy"
+	} else if !r.Ready {
+		state = "not ready"
+	}
+	fmt.Fprintf(&sb, "health: %s (checked at %s)", state, r.Time.Format(time.RFC3339))
+	for _, c := range r.Checks {
+		mark := "ok"
+		if !c.OK {
+			mark = "FAIL"
+		}
+		fmt.Fprintf(&sb, "\n  [%s] %s (%s, %v)", mark, c.Name, c.Kind, c.Elapsed.Round(time.Millisecond))
+		if c.Message != "" {
+			fmt.Fprintf(&sb, ": %s", c.Message)
+		}
+	}
+	return sb.String()
+}
+
+// summarize recomputes Live and Ready from the checks.
+func (r *HealthReport) summarize() {
+	r.Live, r.Ready = true, true
+	for _, c := range r.Checks {
+		if !c.OK {
+			if c.Kind == Readiness {
+				r.Ready = false
+			} else {
+				r.Live = false
+			}
+		}
+	}
+	r.Ready = r.Ready && r.Live
+}
+
+// ErrServiceIsUnhealthy is returned by the Health command if any
+// check failed.
+var ErrServiceIsUnhealthy = errors.New("service is unhealthy")
+
+const (
+	defaultHealthInterval  = 30 * time.Second
+	defaultProbeTimeout    = 5 * time.Second
+	defaultHealthThreshold = 3
+)
+
+// CheckHealth asks EntityHealthAware, and runs the probes of
+// Config.Health. A failure of EntityHealthAware counts as a failed
+// liveness check.
+func CheckHealth(ctx context.Context, config *Config, logger Logger) (report HealthReport) {
+	if fn, ok := config.Entity.(EntityHealthAware); ok {
+		started := time.Now()
+		r, err := fn.Health(ctx, config, logger)
+		report.Checks = append(report.Checks, r.Checks...)
+		if err != nil {
+			report.Checks = append(report.Checks, HealthCheck{Name: "entity", Kind: Liveness, Message: err.Error(), Elapsed: time.Since(started)})
+		}
+	}
+
+	if hc := config.Health; hc != nil {
+		for _, p := range hc.Liveness {
+			report.Checks = append(report.Checks, p.run(ctx, Liveness, hc.Timeout))
+		}
+		for _, p := range hc.Readiness {
+			report.Checks = append(report.Checks, p.run(ctx, Readiness, hc.Timeout))
+		}
+	}
+
+	report.Pid, report.Time = os.Getpid(), time.Now()
+	report.summarize()
+	return
+}
+
+func (p Probe) run(ctx context.Context, kind ProbeKind, def time.Duration) (c HealthCheck) {
+	c.Name, c.Kind = p.Name, kind
+	timeout := p.Timeout
+	if timeout <= 0 {
+		timeout = def
+	}
+	if timeout <= 0 {
+		timeout = defaultProbeTimeout
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	started := time.Now()
+	var err error
+	switch {
+	case p.HTTP != "":
+		if c.Name == "" {
+			c.Name = "http " + p.HTTP
+		}
+		err = probeHTTP(ctx, p.HTTP)
+	case p.TCP != "":
+		if c.Name == "" {
+			c.Name = "tcp " + p.TCP
+		}
+		var conn net.Conn
+		if conn, err = (&net.Dialer{}).DialContext(ctx, "tcp", p.TCP); err == nil {
+			_ = conn.Close()
+		}
+	case len(p.Exec) > 0:
+		if c.Name == "" {
+			c.Name = "exec " + p.Exec[0]
+		}
+		var out []byte
+		if out, err = exec.CommandContext(ctx, p.Exec[0], p.Exec[1:]...).CombinedOutput(); err != nil {
+			if msg := strings.TrimSpace(string(out)); msg != "" {
+				err = errors.New("%v: %s", err, msg)
+			}
+		}
+	default:
+		err = errors.New("empty probe, specify HTTP, TCP or Exec")
+	}
+	c.Elapsed = time.Since(started)
+	c.OK = err == nil
+	if err != nil {
+		c.Message = err.Error()
+	}
+	return
+}
+
+func probeHTTP(ctx context.Context, url string) (err error) {
+	var req *http.Request
+	if req, err = http.NewRequestWithContext(ctx, http.MethodGet, url, nil); err != nil {
+		return
+	}
+	var resp *http.Response
+	if resp, err = http.DefaultClient.Do(req); err != nil {
+		return
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 400 {
+		err = errors.New("http status %d", resp.StatusCode)
+	}
+	return
+}
+
+// health implements the Health command: it prints the report and
+// fails with ErrServiceIsUnhealthy and RetCode 1 if unhealthy.
+func (s *mgmtS) health(ctx context.Context, config *Config) (err error) {
+	report := CheckHealth(ctx, config, dbglog.ZLogger())
+	if len(report.Checks) == 0 {
+		// nothing to check, fall back to the report of the running
+		// service.
+		if saved, e := ReadHealthStatus(config); e == nil {
+			report = saved
+		}
+	}
+	println(report.String())
+	if !report.Healthy() {
+		config.RetCode = 1
+		err = ErrServiceIsUnhealthy
+	}
+	return
+}
+
+// ReadHealthStatus loads the last report saved by the health monitor
+// of the running service.
+func ReadHealthStatus(config *Config) (report HealthReport, err error) {
+	var data []byte
+	if data, err = os.ReadFile(healthStatusPath(config)); err != nil {
+		return
+	}
+	err = json.Unmarshal(data, &report)
+	return
+}
+
+func healthStatusPath(config *Config) string {
+	return strings.TrimSuffix(config.PidfilePath(), ".pid") + ".health.json"
+}
+
+// printHealthStatus prints the report of the health monitor if the
+// service is running.
+func printHealthStatus(config *Config) {
+	if r, err := ReadHealthStatus(config); err == nil && processAlive(r.Pid) {
+		fmt.Println(r.String())
+	}
+}
+
+// monitorHealth checks the health periodically in service mode, till
+// the returned func is called. The report is saved beside the
+// pidfile, the systemd watchdog is pinged while the service is live.
+func (s *mgmtS) monitorHealth(ctx context.Context, config *Config, logger Logger) (stop func()) {
+	_, aware := config.Entity.(EntityHealthAware)
+	watchdog := sdWatchdogInterval()
+	if !aware && config.Health == nil && watchdog == 0 {
+		return func() {}
+	}
+
+	var hc HealthConfig
+	if config.Health != nil {
+		hc = *config.Health
+	}
+	if hc.Interval <= 0 {
+		hc.Interval = defaultHealthInterval
+	}
+	if hc.FailureThreshold <= 0 {
+		hc.FailureThreshold = defaultHealthThreshold
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	go func() {
+		defer func() { _ = os.Remove(healthStatusPath(config)) }()
+
+		ticker := time.NewTicker(hc.Interval)
+		defer ticker.Stop()
+		var ping <-chan time.Time
+		if watchdog > 0 {
+			t := time.NewTicker(watchdog / 2)
+			defer t.Stop()
+			ping = t.C
+		}
+
+		live, failures := true, 0
+		check := func() {
+			if !aware && config.Health == nil {
+				return
+			}
+			report := CheckHealth(ctx, config, logger)
+			if data, err := json.Marshal(report); err == nil {
+				if err = writeFileAtomic(healthStatusPath(config), data, 0o644); err != nil {
+					dbglog.DebugContext(ctx, "[health] cannot save report", "err", err)
+				}
+			}
+			if live = report.Live; live {
+				failures = 0
+				return
+			}
+			failures++
+			dbglog.WarnContext(ctx, "[health] liveness check failed", "failures", failures, "report", report.String())
+			if hc.RestartOnFailure && failures == hc.FailureThreshold {
+				if logger != nil {
+					_ = logger.Errorf("[health] unhealthy after %d checks, quit for restarting\n", failures)
+				}
+				config.RetCode = 1
+				if err := quitSelf(config); err != nil {
+					dbglog.ErrorContext(ctx, "[health] cannot quit", "err", err)
+				}
+			}
+		}
+
+		check()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				check()
+			case <-ping:
+				if live {
+					_ = sdNotify("WATCHDOG=1")
+				}
+			}
+		}
+	}()
+	return cancel
+}
+
+// sdWatchdogInterval returns the watchdog timeout requested by systemd
+// for this process, or zero.
+func sdWatchdogInterval() time.Duration {
+	var usec int64
+	if _, err := fmt.Sscan(os.Getenv("WATCHDOG_USEC"), &usec); err != nil || usec <= 0 {
+		return 0
+	}
+	if pid := os.Getenv("WATCHDOG_PID"); pid != "" && pid != fmt.Sprint(os.Getpid()) {
+		return 0
+	}
+	return time.Duration(usec) * time.Microsecond
+}
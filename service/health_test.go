@@ -0,0 +1,112 @@
+//go:build !windows && !plan9 && !js
+// +build !windows,!plan9,!js
+
+package service
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"path"
+	"testing"
+	"time"
+)
+
+type healthEntity struct {
+	shutdownEntity
+	err error
+}
+
+func (e *healthEntity) Health(ctx context.Context, config *Config, logger Logger) (report HealthReport, err error) {
+	report.Checks = append(report.Checks, HealthCheck{Name: "queue", Kind: Readiness, OK: true})
+	err = e.err
+	return
+}
+
+func TestCheckHealth_probes(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/ready" {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	defer srv.Close()
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	config := &Config{Name: "demo", Health: &HealthConfig{
+		Liveness: []Probe{
+			{HTTP: srv.URL + "/live"},
+			{TCP: ln.Addr().String()},
+			{Exec: []string{"/bin/sh", "-c", "exit 0"}},
+		},
+		Readiness: []Probe{{Name: "ready", HTTP: srv.URL + "/ready"}},
+		Timeout:   time.Second,
+	}}
+	report := CheckHealth(context.Background(), config, nil)
+	if !report.Live || report.Ready || report.Healthy() || len(report.Checks) != 4 {
+		t.Fatalf("expect live but not ready, got %v", report)
+	}
+	if c := report.Checks[3]; c.OK || c.Name != "ready" || c.Message == "" {
+		t.Fatalf("bad readiness check: %+v", c)
+	}
+
+	config.Health.Liveness = append(config.Health.Liveness, Probe{Exec: []string{"/bin/sh", "-c", "echo boom; exit 1"}})
+	if report = CheckHealth(context.Background(), config, nil); report.Live || report.Ready {
+		t.Fatalf("expect not live, got %v", report)
+	}
+}
+
+func TestCheckHealth_entity(t *testing.T) {
+	entity := &healthEntity{}
+	config := &Config{Name: "demo", Entity: entity}
+	if report := CheckHealth(context.Background(), config, nil); !report.Healthy() || len(report.Checks) != 1 {
+		t.Fatalf("expect healthy, got %v", report)
+	}
+	entity.err = errors.New("db lost")
+	if report := CheckHealth(context.Background(), config, nil); report.Live {
+		t.Fatalf("expect not live, got %v", report)
+	}
+}
+
+func TestHealthCommand(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := ln.Addr().String()
+	config := &Config{Name: "demo", PIDFile: path.Join(t.TempDir(), "demo.pid"), Health: &HealthConfig{
+		Liveness: []Probe{{TCP: addr}},
+	}}
+
+	m := &mgmtS{}
+	if err = m.health(context.Background(), config); err != nil || config.RetCode != 0 {
+		t.Fatalf("expect healthy, got %v, RetCode %d", err, config.RetCode)
+	}
+	_ = ln.Close()
+	if err = m.health(context.Background(), config); !errors.Is(err, ErrServiceIsUnhealthy) || config.RetCode != 1 {
+		t.Fatalf("expect unhealthy, got %v, RetCode %d", err, config.RetCode)
+	}
+}
+
+func TestMonitorHealth(t *testing.T) {
+	config := &Config{Name: "demo", PIDFile: path.Join(t.TempDir(), "demo.pid"), Entity: &healthEntity{}}
+	stop := (&mgmtS{}).monitorHealth(context.Background(), config, nil)
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		if r, err := ReadHealthStatus(config); err == nil && r.Healthy() {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("expect the health report saved")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	stop()
+}
@@ -0,0 +1,56 @@
+package service
+
+import (
+	"os"
+)
+
+// InitMode tells how the service acts when it runs as PID 1, such as
+// the entrypoint of a docker or lxc container.
+//
+// PID 1 has two extra duties: the orphaned processes are re-parented
+// to it and must be reaped, and the kernel does not apply the default
+// actions of signals to it, so SIGTERM won't stop it unless handled.
+type InitMode string
+
+const (
+	// InitModeAuto acts as InitModeFork if running as PID 1, or else
+	// InitModeOff.
+	InitModeAuto InitMode = ""
+	// InitModeOff disables the init mode.
+	InitModeOff InitMode = "off"
+	// InitModeFork re-executes the service as a child process, reaps
+	// the zombies, forwards the signals to the child, and exits with
+	// the exit code of the child. It works like tini.
+	InitModeFork InitMode = "fork"
+	// InitModeInProcess keeps the service entity in PID 1, reaps the
+	// zombies, and bounds the graceful shutdown by TimeoutStopSec.
+	//
+	// The reaper may steal the exit status of the processes started
+	// by the entity via os/exec, use InitModeFork if that matters.
+	InitModeInProcess InitMode = "in-process"
+)
+
+// initChildEnv marks the child process created by InitModeFork, so
+// that it won't act as init again.
+const initChildEnv = "CMDR_SERVICE_INIT_CHILD"
+
+// initMode resolves Config.InitMode for the current process.
+//
+// The explicit modes also work if we are not PID 1: on linux, the
+// process becomes a child subreaper so the orphans are re-parented to
+// it.
+func (e *Config) initMode() InitMode {
+	if os.Getenv(initChildEnv) != "" {
+		return InitModeOff
+	}
+	switch e.InitMode {
+	case InitModeAuto:
+		if os.Getpid() == 1 {
+			return InitModeFork
+		}
+		return InitModeOff
+	case InitModeFork, InitModeInProcess:
+		return e.InitMode
+	}
+	return InitModeOff
+}
@@ -0,0 +1,19 @@
+//go:build windows || plan9 || js
+// +build windows plan9 js
+
+package service
+
+import (
+	"context"
+
+	"gopkg.in/hedzr/errors.v3"
+)
+
+// runInitFork is not supported, there is no PID 1 on this platform.
+func (s *mgmtS) runInitFork(ctx context.Context, config *Config) (err error) {
+	return errors.Unavailable
+}
+
+func (s *mgmtS) runInitInProcess(ctx context.Context, config *Config) (release func()) {
+	return func() {}
+}
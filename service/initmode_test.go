@@ -0,0 +1,71 @@
+//go:build linux
+// +build linux
+
+package service
+
+import (
+	"context"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestInitFork_exitCode(t *testing.T) {
+	code, err := initFork(context.Background(), &Config{Name: "demo"}, "/bin/sh", []string{"-c", "exit 7"})
+	if err != nil || code != 7 {
+		t.Fatalf("expect exit code 7, got %d, err: %v", code, err)
+	}
+}
+
+func TestInitFork_stop(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 300*time.Millisecond)
+	defer cancel()
+
+	// the child exits with 3 on SIGTERM; its orphaned sleep is killed
+	// by the trap too.
+	config := &Config{Name: "demo", TimeoutStopSec: "5"}
+	started := time.Now()
+	code, err := initFork(ctx, config, "/bin/sh", []string{"-c", `trap 'kill $!; exit 3' TERM; sleep 30 & wait`})
+	if err != nil || code != 3 {
+		t.Fatalf("expect exit code 3, got %d, err: %v", code, err)
+	}
+	if elapsed := time.Since(started); elapsed > 3*time.Second {
+		t.Fatalf("stopping took too long: %v", elapsed)
+	}
+}
+
+func TestInitFork_killed(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+
+	// the child ignores SIGTERM, so it is killed after TimeoutStopSec.
+	config := &Config{Name: "demo", TimeoutStopSec: "300ms"}
+	code, err := initFork(ctx, config, "/bin/sh", []string{"-c", `trap '' TERM; while :; do sleep 0.05; done`})
+	if err != nil || code != 128+9 {
+		t.Fatalf("expect exit code 137, got %d, err: %v", code, err)
+	}
+}
+
+func TestConfig_initMode(t *testing.T) {
+	if os.Getpid() == 1 {
+		t.Skip("running as PID 1")
+	}
+	for _, c := range []struct {
+		mode, expect InitMode
+	}{
+		{InitModeAuto, InitModeOff},
+		{InitModeOff, InitModeOff},
+		{InitModeFork, InitModeFork},
+		{InitModeInProcess, InitModeInProcess},
+		{"bad", InitModeOff},
+	} {
+		if got := (&Config{InitMode: c.mode}).initMode(); got != c.expect {
+			t.Fatalf("InitMode %q: expect %q, got %q", c.mode, c.expect, got)
+		}
+	}
+
+	t.Setenv(initChildEnv, "1")
+	if got := (&Config{InitMode: InitModeFork}).initMode(); got != InitModeOff {
+		t.Fatalf("expect off in the child process, got %q", got)
+	}
+}
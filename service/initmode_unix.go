@@ -0,0 +1,220 @@
+//go:build !windows && !plan9 && !js
+// +build !windows,!plan9,!js
+
+package service
+
+import (
+	"context"
+	"os"
+	"os/exec"
+	"os/signal"
+	"strconv"
+	"syscall"
+	"time"
+
+	"github.com/hedzr/is"
+	"gopkg.in/hedzr/errors.v3"
+
+	"github.com/hedzr/cmdr-addons/v2/tool/dbglog"
+)
+
+// forwardedSignals are relayed to the child by InitModeFork.
+var forwardedSignals = []os.Signal{
+	syscall.SIGTERM, syscall.SIGINT, syscall.SIGQUIT, syscall.SIGHUP,
+	syscall.SIGUSR1, syscall.SIGUSR2, syscall.SIGWINCH,
+}
+
+// runInitFork runs the service as a child of the current process and
+// acts as its init till it exited. config.RetCode is set to the exit
+// code of the child.
+func (s *mgmtS) runInitFork(ctx context.Context, config *Config) (err error) {
+	becomeReaper(ctx)
+	dbglog.InfoContext(ctx, "[init] running as init", "pid", os.Getpid())
+
+	// re-execute ourselves with the same arguments, config.Executable
+	// may be another program, such as the one wrapped by svcctl.
+	var exe string
+	if exe, err = os.Executable(); err != nil {
+		config.RetCode = 3
+		return errors.New("cannot locate the executable of the current process").WithErrors(err)
+	}
+
+	var code int
+	code, err = initFork(ctx, config, exe, os.Args[1:])
+	config.RetCode = code
+	if err != nil {
+		if code == 0 {
+			config.RetCode = 3
+		}
+		return
+	}
+	if code != 0 {
+		err = errors.New("service exited with code %d", code)
+	}
+	return
+}
+
+// initFork starts exe as a child, reaps all zombies and forwards the
+// signals to the child.
+//
+// A stop signal (SIGTERM, SIGINT or SIGQUIT), or cancelling ctx,
+// starts the graceful shutdown: the child is stopped by StopProcess
+// with that signal, so it is bounded by TimeoutStopSec and escalates
+// to FinalStopSignal and SIGKILL.
+//
+// The exit code of the child is returned, or 128+n if it was killed
+// by signal n.
+func initFork(ctx context.Context, config *Config, exe string, args []string) (code int, err error) {
+	// subscribe SIGCHLD before starting the child, so its exiting
+	// won't be missed.
+	chld := make(chan os.Signal, 1)
+	signal.Notify(chld, syscall.SIGCHLD)
+	defer signal.Stop(chld)
+	sigs := make(chan os.Signal, 8)
+	signal.Notify(sigs, forwardedSignals...)
+	defer signal.Stop(sigs)
+
+	cmd := exec.Command(exe, args...)
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = os.Stdin, os.Stdout, os.Stderr
+	cmd.Env = append(os.Environ(), initChildEnv+"=1")
+	if err = cmd.Start(); err != nil {
+		err = errors.New("cannot start the service process %q", exe).WithErrors(err)
+		return
+	}
+	pid := cmd.Process.Pid
+	dbglog.InfoContext(ctx, "[init] service process started", "pid", pid, "exe", exe, "args", args)
+
+	// the child is waited by the reaper rather than cmd.Wait.
+	r := &reaper{child: pid, exited: make(chan syscall.WaitStatus, 1)}
+	r.reap()
+
+	stopCtx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	stopping := false
+	stop := func(sig syscall.Signal) {
+		if stopping {
+			_ = syscall.Kill(pid, sig)
+			return
+		}
+		stopping = true
+		c := *config
+		c.StopSignal = strconv.Itoa(int(sig))
+		go StopProcess(stopCtx, &c, pid, nil)
+	}
+
+	done := ctx.Done()
+	for {
+		select {
+		case <-chld:
+			r.reap()
+		case ws := <-r.exited:
+			code = exitCodeOf(ws)
+			dbglog.InfoContext(ctx, "[init] service process exited", "pid", pid, "code", code)
+			return
+		case sig := <-sigs:
+			ssig := sig.(syscall.Signal)
+			dbglog.DebugContext(ctx, "[init] forward signal", "sig", sig, "pid", pid)
+			switch ssig {
+			case syscall.SIGTERM, syscall.SIGINT, syscall.SIGQUIT:
+				stop(ssig)
+			default:
+				_ = syscall.Kill(pid, ssig)
+			}
+		case <-done:
+			done = nil
+			stop(config.stopSignal())
+		}
+	}
+}
+
+// runInitInProcess takes the duties of PID 1 while the service entity
+// runs in the current process. The returned func releases them.
+//
+// The first quit signal (see SignalActions) starts a countdown of the shutdown
+// budget (TimeoutStopSec plus the timeouts of the shutdown hooks), the
+// process exits with 128+signal if it has not stopped in time.
+func (s *mgmtS) runInitInProcess(ctx context.Context, config *Config) (release func()) {
+	becomeReaper(ctx)
+	dbglog.InfoContext(ctx, "[init] running as init, in-process", "pid", os.Getpid())
+
+	chld := make(chan os.Signal, 1)
+	signal.Notify(chld, syscall.SIGCHLD)
+	sigs := make(chan os.Signal, 4)
+	signal.Notify(sigs, config.quitSignals()...)
+
+	ctx, cancel := context.WithCancel(ctx)
+	r := &reaper{}
+	go func() {
+		var deadline <-chan time.Time
+		code := 0
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-chld:
+				r.reap()
+			case sig := <-sigs:
+				ssig := sig.(syscall.Signal)
+				if deadline == nil {
+					timeout := s.shutdownBudget(config)
+					dbglog.InfoContext(ctx, "[init] shutting down", "sig", sig, "timeout", timeout)
+					deadline, code = time.After(timeout), 128+int(ssig)
+				}
+			case <-deadline:
+				dbglog.ErrorContext(ctx, "[init] graceful shutdown timed out, exit now", "code", code)
+				is.Closers().Close()
+				os.Exit(code)
+			}
+		}
+	}()
+
+	return func() {
+		cancel()
+		signal.Stop(chld)
+		signal.Stop(sigs)
+	}
+}
+
+// becomeReaper makes the current process a child subreaper if it is
+// not PID 1, so that the orphans of its descendants are re-parented
+// to it.
+func becomeReaper(ctx context.Context) {
+	if os.Getpid() == 1 {
+		return
+	}
+	if err := setSubreaper(); err != nil {
+		dbglog.WarnContext(ctx, "[init] cannot become a subreaper, orphans won't be reaped", "err", err)
+	}
+}
+
+// reaper collects the exit status of all terminated children. The
+// status of child is delivered to exited.
+type reaper struct {
+	child  int
+	exited chan syscall.WaitStatus
+}
+
+func (r *reaper) reap() {
+	for {
+		var ws syscall.WaitStatus
+		pid, err := syscall.Wait4(-1, &ws, syscall.WNOHANG, nil)
+		if err == syscall.EINTR {
+			continue
+		}
+		if err != nil || pid <= 0 {
+			return
+		}
+		if pid == r.child && r.child > 0 {
+			r.exited <- ws
+			continue
+		}
+		dbglog.Debug("[init] zombie reaped", "pid", pid, "code", exitCodeOf(ws))
+	}
+}
+
+func exitCodeOf(ws syscall.WaitStatus) int {
+	if ws.Signaled() {
+		return 128 + int(ws.Signal())
+	}
+	return ws.ExitStatus()
+}
@@ -0,0 +1,192 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/hedzr/is/dir"
+
+	"github.com/hedzr/cmdr-addons/v2/tool/dbglog"
+)
+
+// InstallStep is a change made by an installing.
+type InstallStep struct {
+	Action string // "write", "mkdir", "enable", ...
+	Target string // the file, the directory or the unit
+	Err    error  // the failure of this step, or of its undoing
+}
+
+func (st InstallStep) String() string {
+	if st.Err != nil {
+		return fmt.Sprintf("%s %s: %v", st.Action, st.Target, st.Err)
+	}
+	return st.Action + " " + st.Target
+}
+
+// InstallReport lists what an installing applied, and what was rolled
+// back if it failed.
+type InstallReport struct {
+	Applied    []InstallStep // in the order applied
+	RolledBack []InstallStep // in the order undone, Err is set if the undoing failed
+	Err        error         // the failure which triggered the rollback
+}
+
+func (r InstallReport) String() string {
+	var sb strings.Builder
+	sb.WriteString("applied:")
+	for _, st := range r.Applied {
+		fmt.Fprintf(&sb, "\n  %s", st.String())
+	}
+	if r.Err != nil {
+		fmt.Fprintf(&sb, "\nfailed: %v\nrolled back:", r.Err)
+		for _, st := range r.RolledBack {
+			fmt.Fprintf(&sb, "\n  %s", st.String())
+		}
+	}
+	return sb.String()
+}
+
+// InstallError is returned if an installing failed and was rolled
+// back, use errors.As to get the report.
+type InstallError struct {
+	Report InstallReport
+}
+
+func (e *InstallError) Error() string { return "install failed and rolled back:\n" + e.Report.String() }
+func (e *InstallError) Unwrap() error { return e.Report.Err }
+
+// installTx records the changes of an installing, so that they can be
+// undone in the reverse order if a later step failed. The privileged
+// operations run by the current Elevator.
+type installTx struct {
+	ctx     context.Context
+	backup  string // the directory keeping the previous contents
+	steps   []txStep
+	finally [][]string // run at the end of a rollback, such as daemon-reload
+	report  InstallReport
+}
+
+type txStep struct {
+	InstallStep
+	undo func() error
+}
+
+func newInstallTx(ctx context.Context) (tx *installTx, err error) {
+	tx = &installTx{ctx: ctx}
+	tx.backup, err = os.MkdirTemp("", "install-backup-")
+	return
+}
+
+func (tx *installTx) applied(action, target string, undo func() error) {
+	dbglog.DebugContext(tx.ctx, "[install] applied", "action", action, "target", target)
+	tx.steps = append(tx.steps, txStep{InstallStep{Action: action, Target: target}, undo})
+	tx.report.Applied = append(tx.report.Applied, InstallStep{Action: action, Target: target})
+}
+
+// mkdirAll creates d and its missing parents, they are removed at
+// rollback if still empty.
+func (tx *installTx) mkdirAll(d string) (err error) {
+	var missing []string
+	for p := d; p != "/" && p != "." && !dir.FileExists(p); p = path.Dir(p) {
+		missing = append(missing, p)
+	}
+	if len(missing) == 0 {
+		return
+	}
+	if err = tx.elevate("mkdir", "-p", d); err != nil {
+		return
+	}
+	for i := len(missing) - 1; i >= 0; i-- {
+		p := missing[i]
+		tx.applied("mkdir", p, func() error { return tx.elevate("rmdir", p) })
+	}
+	return
+}
+
+// writeFile writes data into file, see copyFile.
+func (tx *installTx) writeFile(file string, data []byte, mode os.FileMode) (err error) {
+	tmpFile := path.Join(tx.backup, "new-"+path.Base(file))
+	if err = os.WriteFile(tmpFile, data, 0o600); err != nil {
+		return
+	}
+	defer dir.DeleteFile(tmpFile)
+	return tx.copyFile(tmpFile, file, mode)
+}
+
+// copyFile copies src to file, the previous content is backed up and
+// restored at rollback. The mode of an existing file is kept.
+func (tx *installTx) copyFile(src, file string, mode os.FileMode) (err error) {
+	if err = tx.mkdirAll(path.Dir(file)); err != nil {
+		return
+	}
+
+	existed := dir.FileExists(file)
+	var backup string
+	if existed {
+		if info, e := os.Stat(file); e == nil {
+			mode = info.Mode().Perm()
+		}
+		backup = path.Join(tx.backup, fmt.Sprintf("%d-%s", len(tx.steps), path.Base(file)))
+		if err = tx.elevate("cp", "-p", file, backup); err != nil {
+			return
+		}
+	}
+
+	if err = tx.elevate("install", "-m", fmt.Sprintf("%04o", mode), src, file); err != nil {
+		return
+	}
+
+	if existed {
+		tx.applied("write", file, func() error { return tx.elevate("cp", "-p", backup, file) })
+	} else {
+		tx.applied("create", file, func() error { return tx.elevate("rm", "-f", file) })
+	}
+	return
+}
+
+// exec runs cmd, and undo at rollback if it is not nil.
+func (tx *installTx) exec(action, target string, cmd, undo []string) (err error) {
+	if err = tx.elevate(cmd[0], cmd[1:]...); err != nil {
+		return
+	}
+	var fn func() error
+	if undo != nil {
+		fn = func() error { return tx.elevate(undo[0], undo[1:]...) }
+	}
+	tx.applied(action, target, fn)
+	return
+}
+
+// atRollbackEnd runs cmd after all changes were undone.
+func (tx *installTx) atRollbackEnd(cmd ...string) { tx.finally = append(tx.finally, cmd) }
+
+func (tx *installTx) elevate(cmd string, args ...string) (err error) { return elevateE(cmd, args...) }
+
+// done finishes the transaction: the changes are rolled back if err is
+// not nil, and an InstallError with the report is returned.
+func (tx *installTx) done(err error) (report InstallReport, rerr error) {
+	defer os.RemoveAll(tx.backup)
+	if err == nil {
+		return tx.report, nil
+	}
+
+	tx.report.Err = err
+	dbglog.WarnContext(tx.ctx, "[install] failed, rolling back", "err", err)
+	for i := len(tx.steps) - 1; i >= 0; i-- {
+		st := tx.steps[i]
+		if st.undo == nil {
+			continue
+		}
+		st.Err = st.undo()
+		tx.report.RolledBack = append(tx.report.RolledBack, st.InstallStep)
+	}
+	for _, cmd := range tx.finally {
+		if e := tx.elevate(cmd[0], cmd[1:]...); e != nil {
+			dbglog.WarnContext(tx.ctx, "[install] rollback command failed", "cmd", cmd, "err", e)
+		}
+	}
+	return tx.report, &InstallError{tx.report}
+}
@@ -0,0 +1,84 @@
+//go:build !windows && !plan9 && !js
+// +build !windows,!plan9,!js
+
+package service
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path"
+	"strings"
+	"testing"
+)
+
+func TestInstallTx_rollback(t *testing.T) {
+	SetElevator(rootElevator{}) // the files are ours
+	defer SetElevator(nil)
+
+	root := t.TempDir()
+	existing := path.Join(root, "existing.conf")
+	if err := os.WriteFile(existing, []byte("old"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	created := path.Join(root, "a", "b", "new.conf")
+	marker := path.Join(root, "enabled")
+
+	tx, err := newInstallTx(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = tx.writeFile(existing, []byte("new"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if info, _ := os.Stat(existing); info.Mode().Perm() != 0o600 {
+		t.Fatalf("the mode is not kept: %v", info.Mode())
+	}
+	if err = tx.writeFile(created, []byte("x"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err = tx.exec("enable", "demo", []string{"touch", marker}, []string{"rm", marker}); err != nil {
+		t.Fatal(err)
+	}
+
+	report, err := tx.done(errors.New("boom"))
+	var ie *InstallError
+	if !errors.As(err, &ie) || len(report.Applied) != 5 || len(report.RolledBack) != 5 {
+		t.Fatalf("bad report (%v):\n%v", err, report)
+	}
+	if st := report.RolledBack[0]; st.Action != "enable" || st.Err != nil {
+		t.Fatalf("expect enable undone first, got %v", st)
+	}
+	if data, _ := os.ReadFile(existing); string(data) != "old" {
+		t.Fatalf("existing file is not restored: %q", data)
+	}
+	for _, f := range []string{created, path.Join(root, "a"), marker, tx.backup} {
+		if _, e := os.Stat(f); !os.IsNotExist(e) {
+			t.Fatalf("%q is not removed: %v", f, e)
+		}
+	}
+	if s := report.String(); !strings.Contains(s, "failed: boom") || !strings.Contains(s, "rolled back:\n  enable demo") {
+		t.Fatalf("bad report:\n%s", s)
+	}
+}
+
+func TestInstallTx_commit(t *testing.T) {
+	SetElevator(rootElevator{})
+	defer SetElevator(nil)
+
+	file := path.Join(t.TempDir(), "demo.service")
+	tx, err := newInstallTx(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = tx.writeFile(file, []byte("[Unit]\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	report, err := tx.done(nil)
+	if err != nil || len(report.Applied) != 1 || report.Applied[0].Action != "create" {
+		t.Fatalf("bad report (%v):\n%v", err, report)
+	}
+	if info, e := os.Stat(file); e != nil || info.Mode().Perm() != 0o644 {
+		t.Fatalf("bad file: %v, %v", info, e)
+	}
+}
@@ -0,0 +1,239 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/hedzr/cmdr-addons/v2/tool/dbglog"
+)
+
+// MetricsConfig exposes the metrics of the running service in the
+// Prometheus text format. They are served by the "metrics" command of
+// the control socket, and by an HTTP listener if Listen is specified.
+type MetricsConfig struct {
+	Listen string `json:"listen,omitempty" yaml:"listen,omitempty" toml:"listen,omitempty"` // such as "127.0.0.1:9464"
+	Path   string `json:"path,omitempty" yaml:"path,omitempty" toml:"path,omitempty"`       // default "/metrics"
+}
+
+// serviceMetrics records the lifecycle of the service process.
+type serviceMetrics struct {
+	mu             sync.Mutex
+	starts         int
+	reloads        int
+	reloadFailures int
+	lastReload     time.Time
+	lastReloadOK   bool
+	hooks          map[Command]*hookMetrics
+}
+
+type hookMetrics struct {
+	count    int
+	failures int
+	sum      time.Duration
+}
+
+// record counts an operation observed by mgmtS.observe.
+func (sm *serviceMetrics) record(cmd Command, d time.Duration, err error) {
+	sm.mu.Lock()
+	defer sm.mu.Unlock()
+	if sm.hooks == nil {
+		sm.hooks = make(map[Command]*hookMetrics)
+	}
+	h := sm.hooks[cmd]
+	if h == nil {
+		h = &hookMetrics{}
+		sm.hooks[cmd] = h
+	}
+	h.count++
+	h.sum += d
+	if err != nil {
+		h.failures++
+	}
+	if cmd == HotReload {
+		sm.reloads++
+		sm.lastReload, sm.lastReloadOK = time.Now(), err == nil
+		if err != nil {
+			sm.reloadFailures++
+		}
+	}
+}
+
+func (sm *serviceMetrics) started() {
+	sm.mu.Lock()
+	defer sm.mu.Unlock()
+	sm.starts++
+}
+
+// metricsWriter writes the Prometheus text exposition format.
+type metricsWriter struct {
+	w io.Writer
+}
+
+func (mw metricsWriter) head(name, typ, help string) {
+	fmt.Fprintf(mw.w, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, typ)
+}
+
+func (mw metricsWriter) value(name string, v float64, labels ...string) {
+	var sb strings.Builder
+	sb.WriteString(name)
+	if len(labels) > 0 {
+		sb.WriteByte('{')
+		for i := 0; i+1 < len(labels); i += 2 {
+			if i > 0 {
+				sb.WriteByte(',')
+			}
+			fmt.Fprintf(&sb, "%s=%q", labels[i], labels[i+1])
+		}
+		sb.WriteByte('}')
+	}
+	fmt.Fprintf(mw.w, "%s %s\n", sb.String(), strconv.FormatFloat(v, 'g', -1, 64))
+}
+
+func (mw metricsWriter) metric(name, typ, help string, v float64, labels ...string) {
+	mw.head(name, typ, help)
+	mw.value(name, v, labels...)
+}
+
+func boolValue(b bool) float64 {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+// writeMetrics writes the metrics of this service process.
+func (s *mgmtS) writeMetrics(w io.Writer, config *Config) {
+	mw := metricsWriter{w}
+	pid := os.Getpid()
+
+	mw.metric("service_info", "gauge", "The service and its backend.", 1,
+		"service", config.ServiceName(), "backend", s.backend, "pid", strconv.Itoa(pid))
+	if !s.started.IsZero() {
+		mw.metric("service_start_time_seconds", "gauge", "Start time of the service loop since unix epoch.", float64(s.started.UnixNano())/1e9)
+		mw.metric("service_uptime_seconds", "gauge", "Seconds since the service loop started.", time.Since(s.started).Seconds())
+	}
+
+	sm := &s.metrics
+	sm.mu.Lock()
+	mw.metric("service_starts_total", "counter", "Times the service loop started in this process.", float64(sm.starts))
+	mw.metric("service_reloads_total", "counter", "Hot reloads of the entity.", float64(sm.reloads))
+	mw.metric("service_reload_failures_total", "counter", "Failed or vetoed hot reloads of the entity.", float64(sm.reloadFailures))
+	if !sm.lastReload.IsZero() {
+		mw.metric("service_last_reload_success", "gauge", "Whether the last hot reload succeeded.", boolValue(sm.lastReloadOK))
+		mw.metric("service_last_reload_timestamp_seconds", "gauge", "Time of the last hot reload since unix epoch.", float64(sm.lastReload.UnixNano())/1e9)
+	}
+	if len(sm.hooks) > 0 {
+		cmds := make([]Command, 0, len(sm.hooks))
+		for cmd := range sm.hooks {
+			cmds = append(cmds, cmd)
+		}
+		sort.Slice(cmds, func(i, j int) bool { return cmds[i] < cmds[j] })
+		mw.head("service_hook_duration_seconds", "summary", "Durations of the entity hooks and the operations, by command.")
+		for _, cmd := range cmds {
+			mw.value("service_hook_duration_seconds_sum", sm.hooks[cmd].sum.Seconds(), "command", cmd.String())
+			mw.value("service_hook_duration_seconds_count", float64(sm.hooks[cmd].count), "command", cmd.String())
+		}
+		mw.head("service_hook_failures_total", "counter", "Failed or vetoed entity hooks and operations, by command.")
+		for _, cmd := range cmds {
+			mw.value("service_hook_failures_total", float64(sm.hooks[cmd].failures), "command", cmd.String())
+		}
+	}
+	sm.mu.Unlock()
+
+	restarts := 0
+	if st, err := ReadSupervisorStatus(config); err == nil && st.Pid == pid {
+		restarts = st.Restarts
+	}
+	mw.metric("service_restarts_total", "counter", "Restarts of the entity by the supervisor.", float64(restarts))
+
+	if h, err := ReadHealthStatus(config); err == nil && h.Pid == pid {
+		mw.metric("service_health_live", "gauge", "Whether the last liveness checks passed.", boolValue(h.Live))
+		mw.metric("service_health_ready", "gauge", "Whether the last readiness checks passed.", boolValue(h.Ready))
+		mw.head("service_health_probe_success", "gauge", "Whether the probe passed at the last check.")
+		for _, c := range h.Checks {
+			mw.value("service_health_probe_success", boolValue(c.OK), "kind", string(c.Kind), "probe", c.Name)
+		}
+		mw.head("service_health_probe_duration_seconds", "gauge", "Duration of the probe at the last check.")
+		for _, c := range h.Checks {
+			mw.value("service_health_probe_duration_seconds", c.Elapsed.Seconds(), "kind", string(c.Kind), "probe", c.Name)
+		}
+	}
+
+	if ps, ok := readProcessStats(); ok {
+		mw.metric("process_resident_memory_bytes", "gauge", "Resident memory size in bytes.", float64(ps.rss))
+		mw.metric("process_cpu_seconds_total", "counter", "Total user and system CPU time spent in seconds.", ps.cpu.Seconds())
+		if ps.fds >= 0 {
+			mw.metric("process_open_fds", "gauge", "Number of open file descriptors.", float64(ps.fds))
+		}
+	}
+	mw.metric("go_goroutines", "gauge", "Number of goroutines that currently exist.", float64(runtime.NumGoroutine()))
+}
+
+// processStats is the resource usage of this process.
+type processStats struct {
+	rss uint64
+	cpu time.Duration
+	fds int // -1 if unknown
+}
+
+const metricsContentType = "text/plain; version=0.0.4; charset=utf-8"
+
+// serveMetrics serves the metrics by HTTP if Config.Metrics.Listen is
+// specified, till the returned func is called.
+func (s *mgmtS) serveMetrics(ctx context.Context, config *Config) (stop func()) {
+	if config.Metrics == nil || config.Metrics.Listen == "" {
+		return func() {}
+	}
+	ln, err := net.Listen("tcp", config.Metrics.Listen)
+	if err != nil {
+		dbglog.ErrorContext(ctx, "[metrics] cannot listen", "addr", config.Metrics.Listen, "err", err)
+		return func() {}
+	}
+	dbglog.InfoContext(ctx, "[metrics] serving", "addr", ln.Addr().String())
+	return s.serveMetricsOn(ctx, config, ln)
+}
+
+func (s *mgmtS) serveMetricsOn(ctx context.Context, config *Config, ln net.Listener) (stop func()) {
+	p := "/metrics"
+	if config.Metrics != nil && config.Metrics.Path != "" {
+		p = config.Metrics.Path
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc(p, func(w http.ResponseWriter, r *http.Request) {
+		var buf bytes.Buffer
+		s.writeMetrics(&buf, config)
+		w.Header().Set("Content-Type", metricsContentType)
+		_, _ = w.Write(buf.Bytes())
+	})
+	srv := &http.Server{Handler: mux, ReadHeaderTimeout: 5 * time.Second}
+	go func() {
+		if err := srv.Serve(ln); err != nil && err != http.ErrServerClosed {
+			dbglog.ErrorContext(ctx, "[metrics] serve failed", "err", err)
+		}
+	}()
+	return func() {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		_ = srv.Shutdown(ctx)
+	}
+}
+
+// controlMetrics returns the metrics text for the "metrics" command of
+// the control socket.
+func controlMetrics(ctx context.Context, config *Config, m *mgmtS, logger Logger, args json.RawMessage) (data any, err error) {
+	var sb strings.Builder
+	m.writeMetrics(&sb, config)
+	return sb.String(), nil
+}
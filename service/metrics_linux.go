@@ -0,0 +1,41 @@
+package service
+
+import (
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// clockTicks is USER_HZ, 100 on all the Linux architectures we run on.
+const clockTicks = 100
+
+// readProcessStats reads the usage of this process from /proc.
+func readProcessStats() (ps processStats, ok bool) {
+	data, err := os.ReadFile("/proc/self/stat")
+	if err != nil {
+		return
+	}
+	// the fields after the command name, which may contain spaces
+	s := string(data)
+	i := strings.LastIndexByte(s, ')')
+	if i < 0 {
+		return
+	}
+	fields := strings.Fields(s[i+1:])
+	if len(fields) < 22 {
+		return
+	}
+	// fields[0] is the 3rd field of stat: state
+	utime, _ := strconv.ParseUint(fields[11], 10, 64)
+	stime, _ := strconv.ParseUint(fields[12], 10, 64)
+	rss, _ := strconv.ParseUint(fields[21], 10, 64)
+	ps.cpu = time.Duration(utime+stime) * time.Second / clockTicks
+	ps.rss = rss * uint64(os.Getpagesize())
+
+	ps.fds = -1
+	if entries, err := os.ReadDir("/proc/self/fd"); err == nil {
+		ps.fds = len(entries)
+	}
+	return ps, true
+}
@@ -0,0 +1,8 @@
+//go:build !linux
+// +build !linux
+
+package service
+
+// readProcessStats is only implemented on Linux, the process metrics
+// are omitted on the others.
+func readProcessStats() (ps processStats, ok bool) { return }
@@ -0,0 +1,61 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMetricsHTTP(t *testing.T) {
+	ctx := context.Background()
+	config := &Config{Name: "demo", Metrics: &MetricsConfig{Path: "/m"}}
+	m := &mgmtS{backend: "daemon", started: time.Now().Add(-time.Minute)}
+	m.metrics.started()
+	m.metrics.record(HotReload, 20*time.Millisecond, nil)
+	m.metrics.record(HotReload, 10*time.Millisecond, errors.New("bad config"))
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	stop := m.serveMetricsOn(ctx, config, ln)
+	defer stop()
+
+	resp, err := http.Get("http://" + ln.Addr().String() + "/m")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	data, _ := io.ReadAll(resp.Body)
+	text := string(data)
+	if resp.StatusCode != http.StatusOK || !strings.HasPrefix(resp.Header.Get("Content-Type"), "text/plain; version=0.0.4") {
+		t.Fatalf("bad response %v: %s", resp.Status, text)
+	}
+
+	want := []string{
+		"# TYPE service_uptime_seconds gauge\n",
+		`service_info{service="demo.service",backend="daemon",pid="`,
+		"service_starts_total 1\n",
+		"service_reloads_total 2\n",
+		"service_reload_failures_total 1\n",
+		"service_last_reload_success 0\n",
+		`service_hook_duration_seconds_sum{command="HotReload"} 0.03` + "\n",
+		`service_hook_duration_seconds_count{command="HotReload"} 2` + "\n",
+		"service_restarts_total 0\n",
+		"go_goroutines ",
+	}
+	if runtime.GOOS == "linux" {
+		want = append(want, "process_resident_memory_bytes ", "process_cpu_seconds_total ", "process_open_fds ")
+	}
+	for _, w := range want {
+		if !strings.Contains(text, w) {
+			t.Errorf("expect %q in:\n%s", w, text)
+		}
+	}
+}
@@ -0,0 +1,33 @@
+//go:build darwin || freebsd
+// +build darwin freebsd
+
+package service
+
+import (
+	"net"
+
+	"golang.org/x/sys/unix"
+)
+
+// peerCred returns the credentials of the peer by LOCAL_PEERCRED.
+func peerCred(conn *net.UnixConn) (uid int, gids []int, err error) {
+	var raw interface {
+		Control(f func(fd uintptr)) error
+	}
+	if raw, err = conn.SyscallConn(); err != nil {
+		return
+	}
+	var cred *unix.Xucred
+	if e := raw.Control(func(fd uintptr) {
+		cred, err = unix.GetsockoptXucred(int(fd), 0, unix.LOCAL_PEERCRED) // SOL_LOCAL
+	}); e != nil {
+		err = e
+	}
+	if err == nil {
+		uid = int(cred.Uid)
+		for _, g := range cred.Groups[:cred.Ngroups] {
+			gids = append(gids, int(g))
+		}
+	}
+	return
+}
@@ -0,0 +1,28 @@
+package service
+
+import (
+	"net"
+
+	"golang.org/x/sys/unix"
+)
+
+// peerCred returns the credentials of the peer by SO_PEERCRED. Only the
+// primary group is known.
+func peerCred(conn *net.UnixConn) (uid int, gids []int, err error) {
+	var raw interface {
+		Control(f func(fd uintptr)) error
+	}
+	if raw, err = conn.SyscallConn(); err != nil {
+		return
+	}
+	var cred *unix.Ucred
+	if e := raw.Control(func(fd uintptr) {
+		cred, err = unix.GetsockoptUcred(int(fd), unix.SOL_SOCKET, unix.SO_PEERCRED)
+	}); e != nil {
+		err = e
+	}
+	if err == nil {
+		uid, gids = int(cred.Uid), []int{int(cred.Gid)}
+	}
+	return
+}
@@ -0,0 +1,17 @@
+//go:build !linux && !darwin && !freebsd && !windows && !plan9 && !js
+// +build !linux,!darwin,!freebsd,!windows,!plan9,!js
+
+package service
+
+import (
+	"net"
+
+	"gopkg.in/hedzr/errors.v3"
+)
+
+// peerCred is not supported, the permissions of the socket file are
+// relied on.
+func peerCred(conn *net.UnixConn) (uid int, gids []int, err error) {
+	err = errors.Unavailable
+	return
+}
@@ -3,161 +3,376 @@ package service
 import (
 	"context"
 	"fmt"
-	"io"
 	"os"
 	"os/user"
 	"path"
+	"runtime"
 	"strconv"
-	"syscall"
+	"strings"
+	"time"
 
 	"github.com/hedzr/is"
 	"github.com/hedzr/is/dir"
-	"github.com/hedzr/is/exec"
 	"gopkg.in/hedzr/errors.v3"
 
 	"github.com/hedzr/cmdr-addons/service/v2/filelock"
 	"github.com/hedzr/cmdr-addons/v2/tool/dbglog"
 )
 
+// PidfileInfo holds the contents of a pidfile.
+//
+// A pidfile written by this package has two lines: the pid, and the
+// start time of that process (in clock ticks since boot, read from
+// /proc/<pid>/stat). The start time is used to defeat PID reuse, it
+// is zero if the platform cannot provide it.
+type PidfileInfo struct {
+	Pid       int
+	StartTime uint64
+}
+
+// ReadPidfile loads and parses a pidfile.
+//
+// Both the two-lines form written by WritePidfile and the classic
+// single-line form (just the pid) are accepted.
+func ReadPidfile(file string) (info PidfileInfo, err error) {
+	var data []byte
+	if data, err = os.ReadFile(file); err != nil {
+		return
+	}
+
+	lines := strings.Fields(string(data))
+	if len(lines) == 0 {
+		err = errors.New("pidfile %q is empty", file)
+		return
+	}
+	if info.Pid, err = strconv.Atoi(lines[0]); err != nil || info.Pid <= 0 {
+		err = errors.New("pidfile %q has an invalid pid %q", file, lines[0]).WithErrors(err)
+		return
+	}
+	if len(lines) > 1 {
+		info.StartTime, _ = strconv.ParseUint(lines[1], 10, 64)
+	}
+	return
+}
+
+// WritePidfile writes pid and its start time into file atomically.
+//
+// The contents are written into a temporary file in the same
+// directory at first, and renamed to file later. So a reader never
+// sees a partial pidfile.
+func WritePidfile(file string, pid int) (err error) {
+	startTime, _ := processStartTime(pid)
+	return writeFileAtomic(file, []byte(fmt.Sprintf("%d\n%d\n", pid, startTime)), 0o644)
+}
+
+// writeFileAtomic writes data into a temporary file in the same
+// directory and renames it to file.
+func writeFileAtomic(file string, data []byte, perm os.FileMode) (err error) {
+	var tmp *os.File
+	if tmp, err = os.CreateTemp(path.Dir(file), "."+path.Base(file)+".*"); err != nil {
+		return
+	}
+	defer func() {
+		if err != nil {
+			_ = os.Remove(tmp.Name())
+		}
+	}()
+
+	if _, err = tmp.Write(data); err != nil {
+		_ = tmp.Close()
+		return
+	}
+	if err = tmp.Sync(); err != nil {
+		_ = tmp.Close()
+		return
+	}
+	if err = tmp.Close(); err != nil {
+		return
+	}
+	if err = os.Chmod(tmp.Name(), perm); err != nil {
+		return
+	}
+	err = os.Rename(tmp.Name(), file)
+	return
+}
+
+// PidfileAlive reports whether the process recorded in file is still
+// alive.
+//
+// A process is treated as alive only if it exists and, when the
+// pidfile carries a start time, its start time matches. So a pidfile
+// left by a crashed process whose pid has been reused by another
+// program is reported as not alive.
+//
+// A missing pidfile is not an error: it returns pid 0 and false.
+func PidfileAlive(file string) (pid int, alive bool, err error) {
+	var info PidfileInfo
+	if info, err = ReadPidfile(file); err != nil {
+		if os.IsNotExist(err) {
+			err = nil
+		}
+		return
+	}
+	pid, alive = info.Pid, info.alive()
+	return
+}
+
+func (info PidfileInfo) alive() bool {
+	if !processAlive(info.Pid) {
+		return false
+	}
+	if info.StartTime == 0 {
+		return true
+	}
+	st, err := processStartTime(info.Pid)
+	if err != nil || st == 0 {
+		return true
+	}
+	return st == info.StartTime
+}
+
+// processStartTime returns the start time of a process, in clock
+// ticks since boot, from /proc/<pid>/stat.
+//
+// It returns zero without error on platforms without procfs.
+func processStartTime(pid int) (st uint64, err error) {
+	var data []byte
+	if data, err = os.ReadFile(fmt.Sprintf("/proc/%d/stat", pid)); err != nil {
+		if os.IsNotExist(err) {
+			err = nil
+		}
+		return
+	}
+
+	// the comm field may contain spaces and parentheses, so skip to
+	// the last ')' at first. the rest fields begin at field #3, and
+	// starttime is field #22.
+	content := string(data)
+	pos := strings.LastIndexByte(content, ')')
+	if pos < 0 {
+		err = errors.New("bad /proc/%d/stat", pid)
+		return
+	}
+	fields := strings.Fields(content[pos+1:])
+	const startTimeIndex = 22 - 3
+	if len(fields) <= startTimeIndex {
+		err = errors.New("bad /proc/%d/stat, too few fields", pid)
+		return
+	}
+	st, err = strconv.ParseUint(fields[startTimeIndex], 10, 64)
+	return
+}
+
+//
+
+//
+
 func newpidfile(ctx context.Context, s *mgmtS, c *Config) (p *pidFileS, err error) {
 	p = new(pidFileS)
 	err = p.init(ctx, s, c)
 	return
 }
 
+// pidfileOf returns a read-only pidFileS for querying the state of
+// the service described by c. It never creates or removes the file.
+func pidfileOf(c *Config) *pidFileS {
+	return &pidFileS{file: c.PidfilePath()}
+}
+
 type pidFileS struct {
-	file string
-	f    *os.File
-	flck *filelock.Filelock
+	file   string
+	pid    int
+	owned  bool               // the file was written by us and should be removed at closing
+	flck   *filelock.Filelock // <file>.lock, held exclusively till closing
+	cancel context.CancelFunc // stops waiting for the lock of the old process at upgrading
 }
 
 func (p *pidFileS) Close() {
-	if f := p.f; f != nil {
-		if err := f.Close(); err != nil {
-			dbglog.Error("Failed to close pidfile", "err", err)
-		}
-		if err := os.Remove(p.file); err != nil {
-			dbglog.Error("Failed to remove pidfile", "err", err)
-		} else {
-			dbglog.Info("pid file removed", "file", p.file)
-		}
+	if p == nil || !p.owned {
+		return
 	}
-	if p.flck != nil {
-		if err := p.flck.Close(); err != nil {
-			dbglog.Error("Failed to close pidfile (flck)", "err", err)
-		}
+	p.owned = false
+	defer p.unlock()
+
+	// don't remove a pidfile which had been taken over by another
+	// instance.
+	if info, err := ReadPidfile(p.file); err == nil && info.Pid != p.pid {
+		dbglog.Warn("pidfile was taken over, keep it", "file", p.file, "pid", info.Pid)
+		return
+	}
+	if err := os.Remove(p.file); err != nil && !os.IsNotExist(err) {
+		dbglog.Error("Failed to remove pidfile", "err", err)
+	} else {
+		dbglog.Info("pid file removed", "file", p.file)
 	}
 }
 
 func (p *pidFileS) init(ctx context.Context, s *mgmtS, c *Config) (err error) {
-	p.file = path.Join(c.RunDir, c.Name+".pid")
 	_ = s
+	p.file = c.PidfilePath()
+	p.pid = os.Getpid()
 
-	currentUser, err := user.Current()
-	if err != nil {
-		dbglog.Error("Error getting current user", "err", err)
+	if err = p.prepareDir(ctx); err != nil {
+		dbglog.ErrorContext(ctx, "Failed to prepare pidfile directory", "err", err, "pidfile", p.file)
+		return
 	}
 
-	needSudo := currentUser.Username == "root"
-	pid := os.Getpid()
-
-	if dir.FileExists(path.Dir(p.file)) {
-		var locked bool
-		f := filelock.New(p.file)
-		locked, err = f.TryLock()
+	// the lock serializes the instances from reading the pidfile to
+	// writing it.
+	if err = p.lock(ctx); err != nil {
+		return
+	}
+	defer func() {
 		if err != nil {
-			var pass bool
-			var ep *os.PathError
-			if errors.As(err, &ep) {
-				if ep.Err == syscall.EACCES {
-					dbglog.DebugContext(ctx, "[pidFileS] retry pidfile initializing with sudo", "pidfile", p.file)
-					exec.Sudo("rm", "-f", p.file)
-					needSudo, pass = true, true
-					err = nil
-				}
-			}
-			if !pass {
-				dbglog.Error("Failed to init (and lock) pidfile (flck)", "err", err, "pidfile", p.file)
-				return
-			}
+			p.unlock()
 		}
+	}()
 
-		if !locked {
-			if err = f.Unlock(); err != nil {
-				return
-			}
-			if needSudo {
-				exec.Sudo("sh", "-c", fmt.Sprintf("echo > %s && chown %s: %s", p.file, currentUser.Username, p.file))
-				p.f, err = os.Open(p.file)
-				if err != nil {
-					dbglog.Error("Error open pidfile", "err", err)
-				}
-			} else {
-				if p.f, err = os.Create(p.file); err != nil {
-					return
-				}
-			}
-			if _, err = p.f.WriteString(strconv.Itoa(pid)); err != nil {
-				dbglog.Error("Error write pidfile", "err", err)
-				return
-			}
-			is.Closers().RegisterPeripheral(p)
-			dbglog.InfoContext(ctx, "pid file created", "file", p.file, "pid", pid)
-		} else {
-			// update pid
-			p.flck = f
-			if _, err = p.flck.WriteString(strconv.Itoa(pid)); err != nil {
-				dbglog.Error("Error write pidfile", "err", err)
-				return
-			}
-			is.Closers().RegisterPeripheral(p)
-			dbglog.InfoContext(ctx, "pid file updated", "file", p.file, "pid", pid)
+	var info PidfileInfo
+	if info, err = ReadPidfile(p.file); err == nil {
+		// the old process at upgrading hands the pidfile over to us.
+		if info.Pid != p.pid && info.Pid != upgradingFrom() && info.alive() {
+			err = errors.New("pidfile %q is held by a running process %d", p.file, info.Pid).WithErrors(ErrServiceIsRunning)
+			return
+		}
+		dbglog.InfoContext(ctx, "removing stale pidfile", "file", p.file, "pid", info.Pid)
+		if err = os.Remove(p.file); err != nil && !os.IsNotExist(err) {
+			dbglog.WarnContext(ctx, "Failed to remove stale pidfile", "file", p.file, "err", err)
 		}
 	}
+
+	if err = WritePidfile(p.file, p.pid); err != nil {
+		dbglog.ErrorContext(ctx, "Failed to write pidfile", "err", err, "pidfile", p.file)
+		return
+	}
+
+	p.owned = true
+	is.Closers().RegisterPeripheral(p)
+	dbglog.InfoContext(ctx, "pid file created", "file", p.file, "pid", p.pid)
 	return
 }
 
-func (p *pidFileS) isRunning() (running bool) {
-	if p != nil {
-		pid, err := p.ReadPid()
-		if err != nil {
-			return
-		}
+// lock takes the exclusive lock of the pidfile, which is held till
+// Close. At upgrading, the old process holds it till it quits, so it
+// is taken over in background.
+func (p *pidFileS) lock(ctx context.Context) (err error) {
+	switch runtime.GOOS {
+	case "js", "plan9":
+		return // no file lock
+	}
 
-		var proc *os.Process
-		if proc, err = os.FindProcess(pid); err != nil {
-			return
-		}
-		running = proc != nil
+	p.flck = filelock.New(p.file + ".lock")
+
+	var locked bool
+	if locked, err = p.flck.TryLock(); err != nil {
+		p.flck = nil
+		err = errors.New("cannot lock pidfile %q", p.file).WithErrors(err)
+		return
+	}
+	if locked {
+		return
 	}
+
+	if from := upgradingFrom(); from > 0 && processAlive(from) {
+		var c context.Context
+		c, p.cancel = context.WithCancel(context.WithoutCancel(ctx))
+		go func() {
+			if _, e := p.flck.TryLockContext(c, pidfileLockRetry); e == nil {
+				dbglog.DebugContext(c, "[pidFileS] lock taken over from the old process", "pidfile", p.file, "old", from)
+			}
+		}()
+		return
+	}
+	err = errors.New("pidfile %q is locked by another instance", p.file).WithErrors(ErrServiceIsRunning)
 	return
 }
 
-func (p *pidFileS) ReadPid() (pid int, err error) {
-	if p != nil {
-		if p.f == nil {
-			var data []byte
-			data, err = io.ReadAll(p.f)
-			if err != nil {
-				return
-			}
+const pidfileLockRetry = 100 * time.Millisecond
 
-			if pid, err = strconv.Atoi(string(data)); err == nil {
-				return
-			}
-		}
+// unlock releases the lock. The lock file is kept, removing it would
+// let two instances lock the different files of the same name.
+func (p *pidFileS) unlock() {
+	if p.cancel != nil {
+		p.cancel()
+		p.cancel = nil
+	}
+	if p.flck != nil {
+		_ = p.flck.Unlock()
+		p.flck = nil
+	}
+}
 
-		if p.flck != nil {
-			var data string
-			data, err = p.flck.ReadAll()
-			if err != nil {
-				return
-			}
+// prepareDir makes the directory of the pidfile. If it is not
+// writable for us, a directory of the service is created and chowned
+// to us with sudo, a shared one such as /var/run is never chowned.
+func (p *pidFileS) prepareDir(ctx context.Context) (err error) {
+	d := path.Dir(p.file)
+	if dir.FileExists(d) && dirWritable(d) {
+		return
+	}
+	if err = dir.EnsureDir(d); err == nil && dirWritable(d) {
+		return
+	}
 
-			if pid, err = strconv.Atoi(data); err == nil {
-				return
-			}
+	currentUser, e := user.Current()
+	if e != nil || currentUser.Uid == "0" {
+		if err == nil {
+			err = errors.New("pidfile directory %q is not writable", d)
 		}
+		return
+	}
+	if isSharedDir(d) {
+		err = errors.New("pidfile directory %q is a shared one and not writable, use a directory of the service such as %q", d, path.Join(d, strings.TrimSuffix(path.Base(p.file), ".pid")))
+		return
+	}
+
+	dbglog.DebugContext(ctx, "[pidFileS] prepare pidfile directory with sudo", "dir", d)
+	if err = elevateE("mkdir", "-p", d); err == nil {
+		err = elevateE("chown", currentUser.Username+":", d)
+	}
+	return
+}
+
+// dirWritable tests if we can create files in d.
+func dirWritable(d string) bool {
+	f, err := os.CreateTemp(d, ".probe.*")
+	if err != nil {
+		return false
+	}
+	_ = f.Close()
+	_ = os.Remove(f.Name())
+	return true
+}
+
+// dirCreatable tests if we can create files in d, or create d in its
+// nearest existing parent.
+func dirCreatable(d string) bool {
+	if dir.FileExists(d) {
+		return dirWritable(d)
+	}
+	if p := path.Dir(d); p != d {
+		return dirCreatable(p)
+	}
+	return false
+}
+
+func (p *pidFileS) isRunning() (running bool) {
+	if p != nil {
+		_, running, _ = PidfileAlive(p.file)
+	}
+	return
+}
+
+// ReadPid returns the pid recorded in the pidfile.
+func (p *pidFileS) ReadPid() (pid int, err error) {
+	if p == nil {
+		err = os.ErrNotExist
+		return
+	}
+
+	var info PidfileInfo
+	if info, err = ReadPidfile(p.file); err == nil {
+		pid = info.Pid
 	}
 	return
 }
@@ -0,0 +1,24 @@
+//go:build windows || plan9 || js
+// +build windows plan9 js
+
+package service
+
+import (
+	"os"
+)
+
+// processAlive checks pid by opening it.
+//
+// On Windows os.FindProcess opens a process handle, so it fails for
+// a non-existent process.
+func processAlive(pid int) bool {
+	if pid <= 0 {
+		return false
+	}
+	proc, err := os.FindProcess(pid)
+	if err != nil {
+		return false
+	}
+	_ = proc.Release()
+	return true
+}
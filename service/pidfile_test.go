@@ -0,0 +1,106 @@
+//go:build linux
+// +build linux
+
+package service
+
+import (
+	"context"
+	"os"
+	"path"
+	"testing"
+)
+
+func TestWritePidfile(t *testing.T) {
+	file := path.Join(t.TempDir(), "demo.pid")
+	if err := WritePidfile(file, os.Getpid()); err != nil {
+		t.Fatal(err)
+	}
+
+	info, err := ReadPidfile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Pid != os.Getpid() {
+		t.Fatalf("expect pid %d, but got %d", os.Getpid(), info.Pid)
+	}
+	if info.StartTime == 0 {
+		t.Fatalf("expect a start time from procfs")
+	}
+
+	pid, alive, err := PidfileAlive(file)
+	if err != nil || !alive || pid != os.Getpid() {
+		t.Fatalf("expect self alive, got pid=%d alive=%v err=%v", pid, alive, err)
+	}
+}
+
+func TestPidfileAlive_stale(t *testing.T) {
+	d := t.TempDir()
+
+	// a missing pidfile is not an error
+	if _, alive, err := PidfileAlive(path.Join(d, "none.pid")); err != nil || alive {
+		t.Fatalf("expect not alive without error, got alive=%v err=%v", alive, err)
+	}
+
+	// a pid beyond pid_max never exists
+	file := path.Join(d, "exited.pid")
+	if err := os.WriteFile(file, []byte("999999999\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, alive, _ := PidfileAlive(file); alive {
+		t.Fatalf("expect an exited process is not alive")
+	}
+
+	// the pid was reused by another process
+	file = path.Join(d, "reused.pid")
+	if err := os.WriteFile(file, []byte("1\n1\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if st, _ := processStartTime(1); st != 1 {
+		if _, alive, _ := PidfileAlive(file); alive {
+			t.Fatalf("expect a reused pid is not alive")
+		}
+	}
+}
+
+func TestReadPidfile_legacy(t *testing.T) {
+	file := path.Join(t.TempDir(), "legacy.pid")
+	if err := os.WriteFile(file, []byte("1234"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	info, err := ReadPidfile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Pid != 1234 || info.StartTime != 0 {
+		t.Fatalf("bad pidfile info: %+v", info)
+	}
+}
+
+func TestPidfileLock(t *testing.T) {
+	ctx := context.Background()
+	config := &Config{Name: "demo", PIDFile: path.Join(t.TempDir(), "demo.pid")}
+
+	p1 := new(pidFileS)
+	if err := p1.init(ctx, nil, config); err != nil {
+		t.Fatal(err)
+	}
+
+	// the pidfile holds our own pid, only the lock keeps the second
+	// instance out.
+	p2 := new(pidFileS)
+	if err := p2.init(ctx, nil, config); err == nil {
+		p2.Close()
+		t.Fatal("expect the second instance refused while the lock is held")
+	}
+
+	p1.Close()
+	if _, err := os.Stat(config.PIDFile); !os.IsNotExist(err) {
+		t.Fatalf("expect pidfile removed at closing, got %v", err)
+	}
+
+	p3 := new(pidFileS)
+	if err := p3.init(ctx, nil, config); err != nil {
+		t.Fatalf("expect the lock released at closing, got %v", err)
+	}
+	p3.Close()
+}
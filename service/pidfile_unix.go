@@ -0,0 +1,41 @@
+//go:build !windows && !plan9 && !js
+// +build !windows,!plan9,!js
+
+package service
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"syscall"
+)
+
+// processAlive probes pid with kill(pid, 0).
+//
+// EPERM means the process exists but belongs to another user, so it
+// is alive too. A zombie is treated as dead.
+func processAlive(pid int) bool {
+	if pid <= 0 {
+		return false
+	}
+	err := syscall.Kill(pid, 0)
+	if err != nil && err != syscall.EPERM {
+		return false
+	}
+	return !processZombie(pid)
+}
+
+// processZombie checks the state field of /proc/<pid>/stat.
+func processZombie(pid int) bool {
+	data, err := os.ReadFile(fmt.Sprintf("/proc/%d/stat", pid))
+	if err != nil {
+		return false
+	}
+	content := string(data)
+	if pos := strings.LastIndexByte(content, ')'); pos >= 0 {
+		if fields := strings.Fields(content[pos+1:]); len(fields) > 0 {
+			return fields[0] == "Z"
+		}
+	}
+	return false
+}
@@ -0,0 +1,238 @@
+package service
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"os/user"
+	"path"
+	"slices"
+	"strings"
+
+	"github.com/hedzr/cmdr-addons/service/v2/render"
+)
+
+// ServiceDir is a directory of the service provisioned at installing.
+type ServiceDir struct {
+	Kind string // "work", "state", "log" or "run"
+	Path string
+	Mode os.FileMode
+}
+
+// StateDirPath returns the state directory of this service, it is
+// StateDir if specified, or else /var/lib/<name>.
+func (e *Config) StateDirPath() string {
+	if e.StateDir != "" {
+		return e.StateDir
+	}
+	return path.Join("/var/lib", e.BaseName())
+}
+
+// ServiceDirs returns the directories owned by the service: WorkDir,
+// the state directory, LogDir and RunDir. The shared ones, such as
+// /var/log by default, are not included.
+func (e *Config) ServiceDirs() (dirs []ServiceDir) {
+	seen := make(map[string]bool)
+	for _, d := range []ServiceDir{
+		{"work", e.WorkDir, 0o750},
+		{"state", e.StateDirPath(), 0o750},
+		{"log", e.LogDir, 0o750},
+		{"run", e.RunDir, 0o755},
+	} {
+		if d.Path == "" || isSharedDir(d.Path) || seen[path.Clean(d.Path)] {
+			continue
+		}
+		d.Path = path.Clean(d.Path)
+		seen[d.Path] = true
+		dirs = append(dirs, d)
+	}
+	return
+}
+
+// isSharedDir tells whether d is a system directory shared by the
+// services, which must never be chowned.
+func isSharedDir(d string) bool {
+	switch path.Clean(d) {
+	case "/", "/var", "/var/lib", "/var/log", "/var/run", "/run", "/tmp", "/usr", "/usr/local", "/opt", "/home", path.Clean(os.TempDir()):
+		return true
+	}
+	return false
+}
+
+// systemdDirectories returns the directories which systemd creates
+// and chowns to User/Group at starting, by StateDirectory=,
+// LogsDirectory= and RuntimeDirectory=. They are relative to
+// /var/lib, /var/log and /run.
+func systemdDirectories(config *Config) (state, logs, runtime []string) {
+	for _, d := range config.ServiceDirs() {
+		if rel, ok := strings.CutPrefix(d.Path, "/var/lib/"); ok && d.Kind != "run" {
+			state = append(state, rel)
+		} else if rel, ok = strings.CutPrefix(d.Path, "/var/log/"); ok && d.Kind != "run" {
+			logs = append(logs, rel)
+		} else if rel, ok = strings.CutPrefix(d.Path, "/run/"); ok {
+			runtime = append(runtime, rel)
+		} else if rel, ok = strings.CutPrefix(d.Path, "/var/run/"); ok {
+			runtime = append(runtime, rel)
+		}
+	}
+	// the directory of the default pidfile, <RunDir>/<name>
+	if d := path.Dir(config.PidfilePath()); !isSharedDir(d) {
+		for _, prefix := range []string{"/run/", "/var/run/"} {
+			if rel, ok := strings.CutPrefix(d, prefix); ok && !slices.Contains(runtime, rel) {
+				runtime = append(runtime, rel)
+			}
+		}
+	}
+	return
+}
+
+// provisionDirs creates the missing directories of the service with
+// their modes, owned by User and Group. The existing ones are not
+// touched. The directories managed by systemd are skipped if
+// bySystemd is true, see systemdDirectories.
+func provisionDirs(config *Config, tx *installTx, bySystemd bool) (err error) {
+	managed := make(map[string]bool)
+	if bySystemd {
+		state, logs, runtime := systemdDirectories(config)
+		for _, rel := range state {
+			managed[path.Join("/var/lib", rel)] = true
+		}
+		for _, rel := range logs {
+			managed[path.Join("/var/log", rel)] = true
+		}
+		for _, rel := range runtime {
+			managed[path.Join("/run", rel)], managed[path.Join("/var/run", rel)] = true, true
+		}
+	}
+
+	owner := config.User
+	if config.Group != "" {
+		owner += ":" + config.Group
+	}
+	for _, d := range config.ServiceDirs() {
+		if managed[d.Path] || dirExists(d.Path) {
+			continue
+		}
+		if err = tx.mkdirAll(d.Path); err != nil {
+			return
+		}
+		if err = tx.elevate("chmod", fmt.Sprintf("%04o", d.Mode), d.Path); err != nil {
+			return
+		}
+		if owner != "" {
+			if err = tx.elevate("chown", owner, d.Path); err != nil {
+				return
+			}
+		}
+	}
+	return
+}
+
+func dirExists(d string) bool {
+	info, err := os.Stat(d)
+	return err == nil && info.IsDir()
+}
+
+// sysusersFile returns the sysusers.d(5) snippet of the service.
+func sysusersFile(config *Config) string {
+	return path.Join("/etc/sysusers.d", config.BaseName()+".conf")
+}
+
+// provisionUser creates the system user and group of the service if
+// they don't exist. With Config.SysUsers and systemd-sysusers found, a
+// sysusers.d snippet is written and applied, or else useradd/groupadd
+// (adduser/addgroup of busybox) are used. The created accounts are
+// deleted at rollback; the ones created by systemd-sysusers are kept.
+func provisionUser(config *Config, tx *installTx) (err error) {
+	if config.User == "" && config.Group == "" {
+		return
+	}
+	groupMissing := config.Group != "" && !groupExists(config.Group)
+	userMissing := config.User != "" && !userExists(config.User)
+	if !groupMissing && !userMissing {
+		return
+	}
+
+	if config.SysUsers {
+		if p, e := exec.LookPath("systemd-sysusers"); e == nil {
+			// the same snippet as the one shipped by the packages
+			var data []byte
+			if data, err = render.Sysusers(config.RenderConfig()); err != nil {
+				return
+			}
+			file := sysusersFile(config)
+			if err = tx.writeFile(file, data, 0o644); err != nil {
+				return
+			}
+			return tx.exec("sysusers", file, []string{p, file}, nil)
+		}
+	}
+
+	tool := "useradd"
+	if _, e := exec.LookPath("useradd"); e != nil {
+		if _, e = exec.LookPath("adduser"); e == nil {
+			tool = "adduser"
+		}
+	}
+	for _, c := range userCommands(config, tool, groupMissing, userMissing) {
+		if err = tx.exec(c.action, c.target, c.cmd, c.undo); err != nil {
+			return
+		}
+	}
+	return
+}
+
+type userCommand struct {
+	action, target string
+	cmd, undo      []string
+}
+
+// userCommands returns the commands creating the missing group and
+// user by tool: "useradd" of shadow-utils, or "adduser" of busybox.
+func userCommands(config *Config, tool string, groupMissing, userMissing bool) (cmds []userCommand) {
+	home, shell := config.StateDirPath(), nologinShell()
+	if groupMissing {
+		if tool == "adduser" {
+			cmds = append(cmds, userCommand{"addgroup", config.Group, []string{"addgroup", "-S", config.Group}, []string{"delgroup", config.Group}})
+		} else {
+			cmds = append(cmds, userCommand{"groupadd", config.Group, []string{"groupadd", "--system", config.Group}, []string{"groupdel", config.Group}})
+		}
+	}
+	if userMissing {
+		if tool == "adduser" {
+			args := []string{"adduser", "-S", "-D", "-H", "-h", home, "-s", shell}
+			if config.Group != "" {
+				args = append(args, "-G", config.Group)
+			}
+			cmds = append(cmds, userCommand{"adduser", config.User, append(args, config.User), []string{"deluser", config.User}})
+		} else {
+			args := []string{"useradd", "--system", "--no-create-home", "--home-dir", home, "--shell", shell}
+			if config.Group != "" {
+				args = append(args, "--gid", config.Group)
+			} else {
+				args = append(args, "--user-group")
+			}
+			cmds = append(cmds, userCommand{"useradd", config.User, append(args, config.User), []string{"userdel", config.User}})
+		}
+	}
+	return
+}
+
+func userExists(name string) bool {
+	_, err := user.Lookup(name)
+	return err == nil
+}
+
+func groupExists(name string) bool {
+	_, err := user.LookupGroup(name)
+	return err == nil
+}
+
+func nologinShell() string {
+	for _, sh := range []string{"/usr/sbin/nologin", "/sbin/nologin"} {
+		if _, err := os.Stat(sh); err == nil {
+			return sh
+		}
+	}
+	return "/bin/false"
+}
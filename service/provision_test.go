@@ -0,0 +1,89 @@
+//go:build !windows && !plan9 && !js
+// +build !windows,!plan9,!js
+
+package service
+
+import (
+	"context"
+	"os"
+	"path"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/hedzr/cmdr-addons/service/v2/render"
+)
+
+func TestServiceDirs(t *testing.T) {
+	config := &Config{Name: "demo", WorkDir: "/opt/demo", LogDir: "/var/log", RunDir: "/run/demo"}
+	var kinds []string
+	for _, d := range config.ServiceDirs() {
+		kinds = append(kinds, d.Kind+":"+d.Path)
+	}
+	if want := []string{"work:/opt/demo", "state:/var/lib/demo", "run:/run/demo"}; !reflect.DeepEqual(kinds, want) {
+		t.Fatalf("expect %v, got %v", want, kinds)
+	}
+
+	state, logs, runtime := systemdDirectories(config)
+	if !reflect.DeepEqual(state, []string{"demo"}) || logs != nil || !reflect.DeepEqual(runtime, []string{"demo"}) {
+		t.Fatalf("bad directories: %v, %v, %v", state, logs, runtime)
+	}
+}
+
+func TestProvisionDirs(t *testing.T) {
+	SetElevator(rootElevator{})
+	defer SetElevator(nil)
+
+	root := t.TempDir()
+	config := &Config{Name: "demo", WorkDir: path.Join(root, "work"), StateDir: path.Join(root, "state"), LogDir: root}
+	tx, err := newInstallTx(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = provisionDirs(config, tx, false); err != nil {
+		t.Fatal(err)
+	}
+	for _, d := range []string{config.WorkDir, config.StateDir} {
+		if info, e := os.Stat(d); e != nil || info.Mode().Perm() != 0o750 {
+			t.Fatalf("bad dir %q: %v, %v", d, info, e)
+		}
+	}
+
+	// the created ones are removed at rollback, the existing root is kept
+	if _, err = tx.done(os.ErrInvalid); err == nil {
+		t.Fatal("expect an InstallError")
+	}
+	if _, e := os.Stat(config.WorkDir); !os.IsNotExist(e) {
+		t.Fatalf("%q is not removed: %v", config.WorkDir, e)
+	}
+	if _, e := os.Stat(root); e != nil {
+		t.Fatal(e)
+	}
+}
+
+func TestUserCommands(t *testing.T) {
+	config := &Config{Name: "demo", User: "demo", Group: "daemons"}
+	shell := nologinShell()
+
+	cmds := userCommands(config, "useradd", true, true)
+	if len(cmds) != 2 || strings.Join(cmds[0].cmd, " ") != "groupadd --system daemons" ||
+		strings.Join(cmds[1].cmd, " ") != "useradd --system --no-create-home --home-dir /var/lib/demo --shell "+shell+" --gid daemons demo" ||
+		strings.Join(cmds[1].undo, " ") != "userdel demo" {
+		t.Fatalf("bad commands: %+v", cmds)
+	}
+
+	cmds = userCommands(config, "adduser", false, true)
+	if len(cmds) != 1 || strings.Join(cmds[0].cmd, " ") != "adduser -S -D -H -h /var/lib/demo -s "+shell+" -G daemons demo" {
+		t.Fatalf("bad commands: %+v", cmds)
+	}
+
+	data, err := render.Sysusers(config.RenderConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+	text := string(data)
+	if !strings.Contains(text, "\ng daemons -\n") || !strings.Contains(text, "\nu demo - \"demo service\" /var/lib/demo -\n") ||
+		!strings.Contains(text, "\nm demo daemons\n") {
+		t.Fatalf("bad sysusers.d snippet:\n%s", text)
+	}
+}
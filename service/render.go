@@ -0,0 +1,71 @@
+package service
+
+import (
+	"strings"
+
+	"github.com/hedzr/cmdr-addons/service/v2/render"
+)
+
+// RenderConfig returns the data of the service files rendered by
+// render.Render, so the files of all the targets can be generated on
+// any platform:
+//
+//	files, err := render.Render(render.OpenRC, config.RenderConfig())
+func (e *Config) RenderConfig() *render.Config {
+	rc := &render.Config{
+		Name:              e.ServiceBareName(),
+		ScreenName:        e.ScreenName(),
+		ServiceName:       e.ServiceName(),
+		Desc:              e.Desc(),
+		ExecutablePath:    e.ExecutablePath(),
+		StartArgs:         e.startArgs(),
+		StopArgs:          SplitArgs(e.ExecStopArgs),
+		ArgsForInstall:    e.ArgsForInstall,
+		WorkDir:           e.WorkDir,
+		User:              e.User,
+		Group:             e.Group,
+		Env:               e.Env,
+		EnvFilePath:       e.EnvFilePath(),
+		PIDFile:           e.PidfilePath(),
+		LogDir:            e.LogDir,
+		StandardOutPath:   e.StandardOutPath,
+		StandardErrorPath: e.StandardErrorPath,
+		Type:              e.Type,
+		TimeoutStartSec:   e.TimeoutStartSec,
+		TimeoutStopSec:    e.TimeoutStopSec,
+		RestartSec:        e.RestartSec,
+		ReloadSignal:      strings.TrimPrefix(signalName(e.reloadSignal()), "SIG"),
+		Dependencies:      e.Dependencies,
+		WeakDependencies:  e.WeakDependencies,
+		Target:            e.Target,
+		UserLevel:         e.UserLevel,
+		AutoLoad:          e.AutoEnable,
+		TemplateDir:       e.TemplateDir,
+		Home:              e.StateDirPath(),
+	}
+	for _, d := range e.ServiceDirs() {
+		rc.Dirs = append(rc.Dirs, render.Dir{Path: d.Path, Mode: d.Mode})
+	}
+	if e.Health != nil {
+		rc.WatchdogSec = e.Health.WatchdogSec
+	}
+	state, logs, runtime := systemdDirectories(e)
+	rc.StateDirectory = strings.Join(state, " ")
+	rc.LogsDirectory = strings.Join(logs, " ")
+	rc.RuntimeDirectory = strings.Join(runtime, " ")
+	return rc
+}
+
+// WritePackage writes the files of the deb or rpm package of the
+// service into the staging directory destDir, and its maintainer
+// scripts into scriptDir, see render.RenderPackage. The executable is
+// the installed one, such as /usr/bin/myapp.
+//
+//	config.WritePackage(render.Deb, "build/root", "build/root/DEBIAN")
+func (e *Config) WritePackage(format render.PackageFormat, destDir, scriptDir string) (err error) {
+	var pkg *render.Package
+	if pkg, err = render.RenderPackage(format, e.RenderConfig()); err != nil {
+		return
+	}
+	return pkg.Write(destDir, scriptDir)
+}
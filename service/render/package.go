@@ -0,0 +1,144 @@
+package render
+
+import (
+	"maps"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+
+	"gopkg.in/hedzr/errors.v3"
+)
+
+// PackageFormat is the format of the package shipping the service.
+type PackageFormat string
+
+const (
+	Deb PackageFormat = "deb"
+	RPM PackageFormat = "rpm"
+)
+
+// PackageTemplates are the names of the templates of the packages,
+// a custom template <TemplateDir>/share/<name> replaces the builtin
+// one. The unit file and the env file are rendered by the templates
+// of Systemd.
+var PackageTemplates = map[PackageFormat][]string{
+	Deb: {"postinst.deb.tpl", "prerm.deb.tpl", "postrm.deb.tpl", "sysusers.tpl", "tmpfiles.tpl"},
+	RPM: {"post.rpm.tpl", "preun.rpm.tpl", "postun.rpm.tpl", "sysusers.tpl", "tmpfiles.tpl"},
+}
+
+// vendorUnitDir is the directory of the unit files shipped by the
+// packages, /etc/systemd/system is left to the administrator.
+const vendorUnitDir = "/usr/lib/systemd/system"
+
+// Package is the layout of a deb or rpm package of the service, it
+// could feed dpkg-deb, rpmbuild or nfpm.
+type Package struct {
+	Format PackageFormat
+	// Files is the payload keyed by the install paths: the unit file in
+	// /usr/lib/systemd/system, the env file, and the sysusers.d(5) and
+	// tmpfiles.d(5) snippets in /usr/lib if User, Group or Dirs are
+	// specified.
+	Files map[string][]byte
+	// ConfigFiles are the paths in Files kept at upgrading, the
+	// conffiles of deb or %config(noreplace) of rpm.
+	ConfigFiles []string
+	// Scripts are the maintainer scripts keyed by their names:
+	// postinst, prerm and postrm of deb, with the "conffiles" list of
+	// dpkg-deb, or post, preun and postun of rpm.
+	Scripts map[string][]byte
+}
+
+// RenderPackage renders the package of the service in format. The
+// service is enabled and started at the first installing if AutoLoad,
+// restarted at upgrading if it is running (or enabled, for deb), and
+// stopped at removing. The removed but not purged deb masks the unit,
+// till it is reinstalled or purged.
+//
+// The env file is placed in /etc/default for deb and /etc/sysconfig
+// for rpm, unless config.EnvFilePath is out of the well-known env
+// directories.
+func RenderPackage(format PackageFormat, config *Config) (pkg *Package, err error) {
+	if _, ok := PackageTemplates[format]; !ok {
+		return nil, errors.New("unknown package format %q", format)
+	}
+
+	c := *config
+	c.Dir = vendorUnitDir
+	switch path.Dir(c.EnvFilePath) {
+	case "/etc/default", "/etc/sysconfig", "/etc/conf.d", ".":
+		c.EnvFilePath = path.Join("/etc/default", c.Name)
+		if format == RPM {
+			c.EnvFilePath = path.Join("/etc/sysconfig", c.Name)
+		}
+	}
+
+	pkg = &Package{Format: format, ConfigFiles: []string{c.EnvFilePath}, Scripts: make(map[string][]byte)}
+	if pkg.Files, err = Render(Systemd, &c); err != nil {
+		return nil, err
+	}
+
+	d := newData(&c)
+	add := func(m map[string][]byte, key, tpl string) {
+		if err == nil {
+			m[key], err = execute(c.TemplateDir, tpl, d)
+		}
+	}
+	if c.User != "" || c.Group != "" {
+		pkg.Files[path.Join("/usr/lib/sysusers.d", c.Name+".conf")], err = Sysusers(&c)
+	}
+	if len(c.Dirs) > 0 {
+		add(pkg.Files, path.Join("/usr/lib/tmpfiles.d", c.Name+".conf"), "tmpfiles.tpl")
+	}
+	if format == Deb {
+		add(pkg.Scripts, "postinst", "postinst.deb.tpl")
+		add(pkg.Scripts, "prerm", "prerm.deb.tpl")
+		add(pkg.Scripts, "postrm", "postrm.deb.tpl")
+		pkg.Scripts["conffiles"] = []byte(c.EnvFilePath + "\n")
+	} else {
+		add(pkg.Scripts, "post", "post.rpm.tpl")
+		add(pkg.Scripts, "preun", "preun.rpm.tpl")
+		add(pkg.Scripts, "postun", "postun.rpm.tpl")
+	}
+	if err != nil {
+		pkg = nil
+	}
+	return
+}
+
+// Sysusers renders the sysusers.d(5) snippet declaring User and Group
+// of the service, it is nil if neither is specified. The snippet is
+// shipped by the packages, and applied by systemd-sysusers at
+// installing.
+func Sysusers(config *Config) (data []byte, err error) {
+	if config.User == "" && config.Group == "" {
+		return
+	}
+	return execute(config.TemplateDir, "sysusers.tpl", newData(config))
+}
+
+// Write writes the payload into the staging directory destDir, and
+// the maintainer scripts into scriptDir, such as <destDir>/DEBIAN for
+// dpkg-deb. The scripts are skipped if scriptDir is empty.
+func (p *Package) Write(destDir, scriptDir string) (err error) {
+	write := func(file string, data []byte) error {
+		if err := os.MkdirAll(filepath.Dir(file), 0o755); err != nil {
+			return err
+		}
+		return os.WriteFile(file, data, Mode(data))
+	}
+	for _, file := range slices.Sorted(maps.Keys(p.Files)) {
+		if err = write(filepath.Join(destDir, filepath.FromSlash(file)), p.Files[file]); err != nil {
+			return
+		}
+	}
+	if scriptDir == "" {
+		return
+	}
+	for _, name := range slices.Sorted(maps.Keys(p.Scripts)) {
+		if err = write(filepath.Join(scriptDir, name), p.Scripts[name]); err != nil {
+			return
+		}
+	}
+	return
+}
@@ -0,0 +1,388 @@
+// Package render renders the service files of the service managers:
+// the systemd unit, the launchd plist, the sysv, OpenRC and rc.d init
+// scripts, and the runit service directory.
+//
+// It has no build tags and reads nothing but the custom templates, so
+// a build server can generate the files of all the targets, and the
+// deb and rpm packages by RenderPackage:
+//
+//	files, err := render.Render(render.Systemd, config.RenderConfig())
+//	for file, data := range files {
+//	    os.WriteFile(path.Join(destDir, file), data, render.Mode(data))
+//	}
+//
+// The builtin templates can be replaced by the ones in
+// <TemplateDir>/share, see Templates.
+package render
+
+import (
+	"bytes"
+	"io/fs"
+	"maps"
+	"os"
+	"path"
+	"slices"
+	"strings"
+	"text/template"
+
+	"gopkg.in/hedzr/errors.v3"
+)
+
+// Target is a service manager which the files are rendered for.
+type Target string
+
+const (
+	Systemd Target = "systemd"
+	Launchd Target = "launchd"
+	SysV    Target = "sysv"
+	OpenRC  Target = "openrc"
+	RcD     Target = "rc.d"
+	Runit   Target = "runit"
+)
+
+// Targets are all the supported targets.
+var Targets = []Target{Systemd, Launchd, SysV, OpenRC, RcD, Runit}
+
+// Config is the data of the templates, service.Config.RenderConfig
+// makes it from a service.Config. Only Name and ExecutablePath are
+// required, the others are optional.
+type Config struct {
+	Name           string   // the bare name of the service
+	ScreenName     string   // default Name
+	ServiceName    string   // the systemd unit, default <Name>.service
+	Desc           string   //
+	ExecutablePath string   //
+	StartArgs      []string // default "server start -foreground -service"
+	StopArgs       []string // the stop command of systemd, optional
+	ArgsForInstall []string // the ProgramArguments of launchd, default ExecutablePath and StartArgs
+	ExecStartCmd   string   // the ExecStart of systemd, default ExecutablePath and StartArgs
+	ExecStopCmd    string   // the ExecStop of systemd, default ExecutablePath and StopArgs
+
+	WorkDir           string            //
+	User              string            //
+	Group             string            //
+	Env               map[string]string //
+	EnvFilePath       string            // the env file, rendered for systemd, sysv, rc.d and runit if not empty
+	PIDFile           string            // written by the service itself, default to <run>/<Name>/<Name>.pid as Config.PidfilePath
+	LogDir            string            // the logs of runit, default /var/log
+	StandardOutPath   string            //
+	StandardErrorPath string            //
+
+	Type            string // the service type of systemd, default "exec"
+	TimeoutStartSec string //
+	TimeoutStopSec  string //
+	RestartSec      string //
+	WatchdogSec     string //
+	ReloadSignal    string // without "SIG", default "HUP"
+
+	Dependencies     []string // the services required, the units for systemd
+	WeakDependencies []string // the services wanted
+	Target           string   // the umbrella target unit of systemd
+
+	// the directories managed by systemd, relative to /var/lib,
+	// /var/log and /run, separated by spaces.
+	StateDirectory, LogsDirectory, RuntimeDirectory string
+
+	Home string // the home of User declared by the packages, default /var/lib/<Name>
+	Dirs []Dir  // the directories created by the packages, see RenderPackage
+
+	UserLevel   bool   // a per-user launchd agent
+	AutoLoad    bool   // RunAtLoad of launchd
+	Dir         string // the directory of the service file, default by the target, see ServiceFile
+	TemplateDir string // the custom templates are looked up in <TemplateDir>/share
+}
+
+// Dir is a directory owned by the service, created with its mode and
+// owned by User and Group by the tmpfiles.d snippet of the packages.
+type Dir struct {
+	Path string
+	Mode fs.FileMode // default 0755
+}
+
+// Templates are the names of the templates of the targets, a custom
+// template <TemplateDir>/share/<name> replaces the builtin one.
+var Templates = map[Target][]string{
+	Systemd: {"service.tpl", "default.tpl"},
+	Launchd: {"service.darwin.tpl"},
+	SysV:    {"service.sysv.tpl", "default.tpl"},
+	OpenRC:  {"service.openrc.tpl", "conf.openrc.tpl"},
+	RcD:     {"service.rc.d.tpl", "default.tpl"},
+	Runit:   {"run.runit.tpl", "log.runit.tpl", "default.tpl"},
+}
+
+var builtin = map[string]string{
+	"service.tpl":        tplSystemdService,
+	"default.tpl":        tplEtcDefault,
+	"service.darwin.tpl": tplLaunchdService,
+	"service.sysv.tpl":   tplSysVScript,
+	"service.openrc.tpl": tplOpenRCScript,
+	"conf.openrc.tpl":    tplOpenRCConf,
+	"service.rc.d.tpl":   tplRcDScript,
+	"run.runit.tpl":      tplRunitRun,
+	"log.runit.tpl":      tplRunitLog,
+	"sysusers.tpl":       tplSysusers,
+	"tmpfiles.tpl":       tplTmpfiles,
+	"postinst.deb.tpl":   tplDebPostinst,
+	"prerm.deb.tpl":      tplDebPrerm,
+	"postrm.deb.tpl":     tplDebPostrm,
+	"post.rpm.tpl":       tplRPMPost,
+	"preun.rpm.tpl":      tplRPMPreun,
+	"postun.rpm.tpl":     tplRPMPostun,
+}
+
+// Render renders the files of target for config. The files are keyed
+// by their install paths, ServiceFile returns the main one of them.
+func Render(target Target, config *Config) (files map[string][]byte, err error) {
+	if _, ok := Templates[target]; !ok {
+		return nil, errors.New("unknown render target %q", target)
+	}
+	if config.Name == "" || config.ExecutablePath == "" {
+		return nil, errors.New("render %s: the name and the executable of the service are required", target)
+	}
+
+	d := newData(config)
+	files = make(map[string][]byte)
+	add := func(file, tpl string) {
+		if err == nil {
+			files[file], err = execute(config.TemplateDir, tpl, d)
+		}
+	}
+
+	main := ServiceFile(target, config)
+	switch target {
+	case Systemd:
+		add(main, "service.tpl")
+	case Launchd:
+		add(main, "service.darwin.tpl")
+	case SysV:
+		add(main, "service.sysv.tpl")
+	case OpenRC:
+		add(main, "service.openrc.tpl")
+		add(path.Join("/etc/conf.d", config.Name), "conf.openrc.tpl")
+	case RcD:
+		add(main, "service.rc.d.tpl")
+	case Runit:
+		add(main, "run.runit.tpl")
+		add(path.Join(path.Dir(main), "log", "run"), "log.runit.tpl")
+	}
+	// the env file, OpenRC has its conf.d instead
+	if config.EnvFilePath != "" && target != Launchd && target != OpenRC {
+		add(config.EnvFilePath, "default.tpl")
+	}
+	if err != nil {
+		files = nil
+	}
+	return
+}
+
+// ServiceFile returns the path of the main file of target, that is,
+// the unit file, the plist, the init script or the run script, in
+// config.Dir, or else:
+//
+//	systemd: /etc/systemd/system/<ServiceName>
+//	launchd: /Library/LaunchAgents/<Name>.plist, $HOME/Library/LaunchAgents if UserLevel
+//	sysv:    /etc/init.d/<Name>
+//	openrc:  /etc/init.d/<Name>
+//	rc.d:    /usr/local/etc/rc.d/<Name>
+//	runit:   /etc/sv/<Name>/run
+func ServiceFile(target Target, config *Config) string {
+	d := config.Dir
+	switch target {
+	case Systemd:
+		if d == "" {
+			d = "/etc/systemd/system"
+		}
+		return path.Join(d, serviceName(config))
+	case Launchd:
+		if d == "" {
+			d = "/Library/LaunchAgents"
+			if config.UserLevel {
+				d = "$HOME/Library/LaunchAgents"
+			}
+		}
+		return path.Join(d, config.Name+".plist")
+	case SysV, OpenRC:
+		if d == "" {
+			d = "/etc/init.d"
+		}
+	case RcD:
+		if d == "" {
+			d = "/usr/local/etc/rc.d"
+		}
+	case Runit:
+		if d == "" {
+			d = "/etc/sv"
+		}
+		return path.Join(d, config.Name, "run")
+	}
+	return path.Join(d, config.Name)
+}
+
+// Mode returns the file mode of a rendered file, 0755 for the scripts
+// and 0644 for the others.
+func Mode(data []byte) fs.FileMode {
+	if bytes.HasPrefix(data, []byte("#!")) {
+		return 0o755
+	}
+	return 0o644
+}
+
+// UnitName returns the systemd unit of a dependency, ".service" is
+// appended if it has no unit suffix.
+func UnitName(dep string) string {
+	if path.Ext(dep) == "" {
+		return dep + ".service"
+	}
+	return dep
+}
+
+func serviceName(config *Config) string {
+	if config.ServiceName != "" {
+		return config.ServiceName
+	}
+	return UnitName(config.Name)
+}
+
+// data is the data of the templates, the defaults of config are
+// filled and the dependencies are named for the targets.
+type data struct {
+	*Config
+	DefaultDir string   // the directory of the env file
+	After      []string // the units of Dependencies and WeakDependencies
+	Requires   []string // the units of Dependencies
+	Wants      []string // the units of WeakDependencies
+	Needs      []string // the bare names of Dependencies
+	Uses       []string // the bare names of WeakDependencies
+	RcName     string   // the name of the rc.d variables
+	EnvPairs   []string // the sorted KEY=VALUE of Env
+}
+
+func newData(config *Config) *data {
+	c := *config
+	if c.ScreenName == "" {
+		c.ScreenName = c.Name
+	}
+	c.ServiceName = serviceName(&c)
+	if c.Type == "" {
+		c.Type = "exec"
+	}
+	if c.ReloadSignal == "" {
+		c.ReloadSignal = "HUP"
+	}
+	if c.Home == "" {
+		c.Home = path.Join("/var/lib", c.Name)
+	}
+	c.Dirs = slices.Clone(c.Dirs)
+	for i := range c.Dirs {
+		if c.Dirs[i].Mode == 0 {
+			c.Dirs[i].Mode = 0o755
+		}
+	}
+	if len(c.StartArgs) == 0 {
+		c.StartArgs = []string{"server", "start", "-foreground", "-service"}
+	}
+	if len(c.ArgsForInstall) == 0 {
+		c.ArgsForInstall = append([]string{c.ExecutablePath}, c.StartArgs...)
+	}
+	if c.ExecStartCmd == "" {
+		c.ExecStartCmd = strings.Join([]string{unitArgs(c.ExecutablePath), "$GLOBAL_OPTIONS", unitArgs(c.StartArgs...), "$OPTIONS"}, " ")
+	}
+	if c.ExecStopCmd == "" && len(c.StopArgs) > 0 {
+		c.ExecStopCmd = strings.Join([]string{unitArgs(c.ExecutablePath), "$GLOBAL_OPTIONS", unitArgs(c.StopArgs...), "$OPTIONS", "$MAINPID"}, " ")
+	}
+
+	d := &data{Config: &c, RcName: rcName(c.Name)}
+	if c.EnvFilePath != "" {
+		d.DefaultDir = path.Dir(c.EnvFilePath)
+	}
+	for _, dep := range c.Dependencies {
+		d.Requires = append(d.Requires, UnitName(dep))
+		d.Needs = append(d.Needs, strings.TrimSuffix(dep, ".service"))
+	}
+	for _, dep := range c.WeakDependencies {
+		d.Wants = append(d.Wants, UnitName(dep))
+		d.Uses = append(d.Uses, strings.TrimSuffix(dep, ".service"))
+	}
+	d.After = append(append([]string(nil), d.Requires...), d.Wants...)
+	for _, k := range slices.Sorted(maps.Keys(c.Env)) {
+		d.EnvPairs = append(d.EnvPairs, k+"="+c.Env[k])
+	}
+	return d
+}
+
+// rcName replaces the characters not allowed in the shell variables.
+func rcName(name string) string {
+	return strings.Map(func(r rune) rune {
+		if r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '_' {
+			return r
+		}
+		return '_'
+	}, name)
+}
+
+// execute executes the template name, <templateDir>/share/<name> if
+// exists, or else the builtin one.
+func execute(templateDir, name string, d *data) (text []byte, err error) {
+	src := builtin[name]
+	if templateDir != "" {
+		if b, e := os.ReadFile(path.Join(templateDir, "share", name)); e == nil {
+			src = string(b)
+		}
+	}
+
+	var tmpl *template.Template
+	if tmpl, err = template.New(name).Funcs(funcs).Parse(src); err != nil {
+		return
+	}
+	var buf bytes.Buffer
+	if err = tmpl.Execute(&buf, d); err != nil {
+		return
+	}
+	text = buf.Bytes()
+	return
+}
+
+var funcs = template.FuncMap{
+	"quote": quote,
+	"shell": shell,
+}
+
+// shell joins args into a shell command line, each one is quoted if
+// necessary.
+func shell(args []string) string {
+	var sb strings.Builder
+	for i, a := range args {
+		if i > 0 {
+			sb.WriteByte(' ')
+		}
+		sb.WriteString(quote(a))
+	}
+	return sb.String()
+}
+
+// unitArgs joins args into a command line of systemd.exec(5), each
+// one is double quoted if necessary, '%' and '$' are escaped.
+func unitArgs(args ...string) string {
+	quoted := make([]string, len(args))
+	for i, a := range args {
+		a = strings.NewReplacer("%", "%%", "$", "$$").Replace(a)
+		if a == "" || strings.ContainsAny(a, " \t\n'\"\\;") {
+			a = `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(a) + `"`
+		}
+		quoted[i] = a
+	}
+	return strings.Join(quoted, " ")
+}
+
+// quote quotes s for the shell if it has special characters.
+func quote(s string) string {
+	if s == "" {
+		return "''"
+	}
+	for _, r := range s {
+		if !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || strings.ContainsRune("_-+=.,:/@%", r)) {
+			return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+		}
+	}
+	return s
+}
@@ -0,0 +1,267 @@
+package render
+
+import (
+	"bytes"
+	"flag"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+var update = flag.Bool("update", false, "update the golden files in testdata")
+
+func demo() *Config {
+	return &Config{
+		Name:              "demo",
+		ScreenName:        "Demo",
+		Desc:              "demo service",
+		ExecutablePath:    "/usr/local/bin/demo",
+		StartArgs:         []string{"server", "start", "--foreground", "--service"},
+		StopArgs:          []string{"server", "stop", "--service"},
+		WorkDir:           "/var/lib/demo",
+		User:              "demo",
+		Group:             "demo",
+		Env:               map[string]string{"PORT": "8080", "GREETING": "hello world"},
+		EnvFilePath:       "/etc/default/demo",
+		LogDir:            "/var/log",
+		StandardOutPath:   "/var/log/demo/stdout.log",
+		StandardErrorPath: "/var/log/demo/stderr.log",
+		WatchdogSec:       "30s",
+		Dependencies:      []string{"postgresql"},
+		WeakDependencies:  []string{"redis.service"},
+		StateDirectory:    "demo",
+		LogsDirectory:     "demo",
+		RuntimeDirectory:  "demo",
+		AutoLoad:          true,
+	}
+}
+
+// TestRender compares the rendered files with the golden ones in
+// testdata/<target>, run "go test -update" to regenerate them.
+func TestRender(t *testing.T) {
+	for _, target := range Targets {
+		t.Run(string(target), func(t *testing.T) {
+			files, err := Render(target, demo())
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, ok := files[ServiceFile(target, demo())]; !ok {
+				t.Fatalf("no service file %q in %v", ServiceFile(target, demo()), keys(files))
+			}
+
+			dir := filepath.Join("testdata", string(target))
+			if *update {
+				_ = os.RemoveAll(dir)
+			}
+			for file, data := range files {
+				golden := filepath.Join(dir, filepath.FromSlash(file))
+				if *update {
+					if err = os.MkdirAll(filepath.Dir(golden), 0o755); err == nil {
+						err = os.WriteFile(golden, data, 0o644)
+					}
+					if err != nil {
+						t.Fatal(err)
+					}
+					continue
+				}
+				want, err := os.ReadFile(golden)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if string(data) != string(want) {
+					t.Errorf("%s differs from %s:\n%s", file, golden, data)
+				}
+				checkScript(t, file, data)
+			}
+
+			// no golden file is missing in the result
+			_ = filepath.Walk(dir, func(p string, info os.FileInfo, err error) error {
+				if err == nil && !info.IsDir() {
+					file := "/" + filepath.ToSlash(strings.TrimPrefix(p, dir+string(filepath.Separator)))
+					if _, ok := files[file]; !ok {
+						t.Errorf("%s is not rendered", file)
+					}
+				}
+				return err
+			})
+		})
+	}
+}
+
+func keys(files map[string][]byte) (ks []string) {
+	for k := range files {
+		ks = append(ks, k)
+	}
+	slices.Sort(ks)
+	return
+}
+
+func TestRender_defaults(t *testing.T) {
+	config := &Config{Name: "app", ExecutablePath: "/opt/app/bin/app"}
+	files, err := Render(Systemd, config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := keys(files); !slices.Equal(got, []string{"/etc/systemd/system/app.service"}) {
+		t.Fatalf("unexpected files: %v", got)
+	}
+	text := string(files["/etc/systemd/system/app.service"])
+	for _, want := range []string{
+		"Type=exec\n",
+		"ExecStart=/opt/app/bin/app $GLOBAL_OPTIONS server start -foreground -service $OPTIONS\n",
+		"ExecReload=/bin/kill -HUP $MAINPID\n",
+	} {
+		if !strings.Contains(text, want) {
+			t.Fatalf("expect %q in:\n%s", want, text)
+		}
+	}
+	if config.Type != "" || config.StartArgs != nil {
+		t.Fatal("config is modified by Render")
+	}
+
+	config.Dir, config.UserLevel = "/usr/lib/systemd/system", true
+	if file := ServiceFile(Systemd, config); file != "/usr/lib/systemd/system/app.service" {
+		t.Fatalf("unexpected service file %q", file)
+	}
+	config.Dir = ""
+	if file := ServiceFile(Launchd, config); file != "$HOME/Library/LaunchAgents/app.plist" {
+		t.Fatalf("unexpected service file %q", file)
+	}
+}
+
+func TestRender_errors(t *testing.T) {
+	if _, err := Render("upstart", demo()); err == nil {
+		t.Fatal("expect an error for an unknown target")
+	}
+	if _, err := Render(Systemd, &Config{Name: "app"}); err == nil {
+		t.Fatal("expect an error without the executable")
+	}
+}
+
+func TestRender_customTemplate(t *testing.T) {
+	config := demo()
+	config.TemplateDir = t.TempDir()
+	share := path.Join(config.TemplateDir, "share")
+	if err := os.MkdirAll(share, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	tpl := "#!/bin/sh\nexec {{quote .ExecutablePath}} {{shell .StartArgs}}\n"
+	if err := os.WriteFile(path.Join(share, "service.sysv.tpl"), []byte(tpl), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	files, err := Render(SysV, config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := files["/etc/init.d/demo"]
+	if want := "#!/bin/sh\nexec /usr/local/bin/demo server start --foreground --service\n"; string(data) != want {
+		t.Fatalf("expect %q, got %q", want, data)
+	}
+	if Mode(data) != 0o755 || Mode(files["/etc/default/demo"]) != 0o644 {
+		t.Fatal("bad file modes")
+	}
+}
+
+func TestQuote(t *testing.T) {
+	for in, want := range map[string]string{
+		"":             "''",
+		"/usr/bin/app": "/usr/bin/app",
+		"--port=8080":  "--port=8080",
+		"hello world":  "'hello world'",
+		"it's":         `'it'\''s'`,
+		"$HOME":        "'$HOME'",
+	} {
+		if got := quote(in); got != want {
+			t.Errorf("quote(%q) = %q, want %q", in, got, want)
+		}
+	}
+	if got := shell([]string{"a", "b c"}); got != "a 'b c'" {
+		t.Errorf("shell = %q", got)
+	}
+}
+
+// TestRenderPackage compares the package written by Package.Write with
+// the golden tree in testdata/<format>: the payload in root and the
+// maintainer scripts in scripts.
+func TestRenderPackage(t *testing.T) {
+	for _, format := range []PackageFormat{Deb, RPM} {
+		t.Run(string(format), func(t *testing.T) {
+			config := demo()
+			config.EnvFilePath = "/etc/sysconfig/demo" // replaced by the one of format
+			config.Dirs = []Dir{{Path: "/var/lib/demo", Mode: 0o750}, {Path: "/var/log/demo", Mode: 0o750}}
+			pkg, err := RenderPackage(format, config)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, ok := pkg.Files["/usr/lib/systemd/system/demo.service"]; !ok {
+				t.Fatalf("no vendor unit file in %v", keys(pkg.Files))
+			}
+
+			golden := filepath.Join("testdata", string(format))
+			dir := t.TempDir()
+			if *update {
+				_ = os.RemoveAll(golden)
+				dir = golden
+			}
+			if err = pkg.Write(filepath.Join(dir, "root"), filepath.Join(dir, "scripts")); err != nil {
+				t.Fatal(err)
+			}
+			if *update {
+				return
+			}
+			if got, want := tree(t, dir), tree(t, golden); !slices.Equal(keys(got), keys(want)) {
+				t.Fatalf("expect files %v, got %v", keys(want), keys(got))
+			} else {
+				for file, data := range got {
+					if string(data) != string(want[file]) {
+						t.Errorf("%s differs from the golden one:\n%s", file, data)
+					}
+				}
+			}
+			for name, data := range pkg.Scripts {
+				checkScript(t, name, data)
+			}
+		})
+	}
+
+	if _, err := RenderPackage("apk", demo()); err == nil {
+		t.Fatal("expect an error for an unknown format")
+	}
+}
+
+// tree returns the files under dir, keyed by their relative paths.
+func tree(t *testing.T, dir string) (files map[string][]byte) {
+	files = make(map[string][]byte)
+	err := filepath.Walk(dir, func(p string, info os.FileInfo, err error) error {
+		if err == nil && !info.IsDir() {
+			var data []byte
+			if data, err = os.ReadFile(p); err == nil {
+				rel, _ := filepath.Rel(dir, p)
+				files[filepath.ToSlash(rel)] = data
+			}
+		}
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return
+}
+
+// checkScript checks the syntax of a shell script by "sh -n".
+func checkScript(t *testing.T, name string, data []byte) {
+	sh, err := exec.LookPath("sh")
+	if err != nil || Mode(data) != 0o755 {
+		return
+	}
+	cmd := exec.Command(sh, "-n")
+	cmd.Stdin = bytes.NewReader(data)
+	if out, err := cmd.CombinedOutput(); err != nil {
+		t.Errorf("%s: %v\n%s", name, err, out)
+	}
+}
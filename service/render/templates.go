@@ -0,0 +1,561 @@
+package render
+
+const (
+	// tplEtcDefault is the env file sourced by the service.
+	tplEtcDefault = `### {{.ScreenName}} configurations
+### executable: {{.ExecutablePath}}
+
+# PORT=3211
+
+# OPTIONS="--port 3211"
+
+#
+# the service startup command line is like:
+#
+#	$ service-app [global-options] server start [options]
+#
+GLOBAL_OPTIONS=""
+OPTIONS=""
+
+`
+
+	// tplSystemdService is the unit file of systemd.
+	tplSystemdService = `### {{.ScreenName}} services
+### {{.ServiceName}}
+### executable: {{.ExecutablePath}}
+
+[Unit]
+Description={{.ScreenName}} Service for %i - {{.Desc}}
+# Documentation=man:sshd(8) man:sshd_config(5) man:{{.Name}}(1)
+After=network.target{{range .After}} {{.}}{{end}}
+{{range .Requires}}Requires={{.}}
+{{end}}{{range .Wants}}Wants={{.}}
+{{end}}{{if .Target}}PartOf={{.Target}}
+{{end -}}
+# Wants=syslog.service
+ConditionPathExists={{.ExecutablePath}}
+
+[Install]
+WantedBy=multi-user.target{{if .Target}} {{.Target}}{{end}}
+
+[Service]
+Type={{.Type}}
+{{if .User}}User={{.User}}{{else}}# User=%i{{end}}
+{{if .Group}}Group={{.Group}}{{else}}# Group=%i{{end}}
+LimitNOFILE=65535
+{{if .TimeoutStartSec}}TimeoutStartSec={{.TimeoutStartSec}}{{else}}TimeoutStartSec=60s{{end}}
+{{if .TimeoutStopSec}}TimeoutStopSec={{.TimeoutStopSec}}{{else}}TimeoutStopSec=60s{{end}}
+{{if .PIDFile}}PIDFile={{.PIDFile}}{{else}}PIDFile=/run/{{.Name}}/{{.Name}}.pid{{end}}
+
+{{if .WatchdogSec}}WatchdogSec={{.WatchdogSec}}
+{{end -}}
+KillMode=process
+# allow the new process to take over MainPID at upgrading
+NotifyAccess=all
+Restart=on-failure
+{{if .RestartSec}}RestartSec={{.RestartSec}}{{else}}RestartSec=23s{{end}}
+# RestartLimitIntervalSec=60
+
+EnvironmentFile={{.EnvFilePath}}
+{{range $k, $v := .Env -}}
+Environment={{$k}}={{$v}}
+{{end -}}
+
+{{if .WorkDir}}WorkingDirectory={{.WorkDir}}{{else}}WorkingDirectory=%h{{end}}
+
+#          start: --addr, --port,
+#           todo: --pid
+# global options: --verbose, --debug,
+{{if .ExecStartCmd}}ExecStart={{.ExecStartCmd}}{{else}}ExecStart={{.ExecutablePath}} $GLOBAL_OPTIONS server start -foreground -service $OPTIONS{{end}}
+#           stop: -1/--hup, -9/--kill,
+{{if .ExecStopCmd}}ExecStop={{.ExecStopCmd}}{{else}}ExecStop={{.ExecutablePath}} $GLOBAL_OPTIONS server stop -3 $MAINPID{{end}}
+ExecReload=/bin/kill -{{.ReloadSignal}} $MAINPID
+
+# the directories created and owned by User/Group at starting
+{{if .StateDirectory}}StateDirectory={{.StateDirectory}}
+{{end}}{{if .LogsDirectory}}LogsDirectory={{.LogsDirectory}}
+{{end}}{{if .RuntimeDirectory}}RuntimeDirectory={{.RuntimeDirectory}}
+{{end}}
+# # enable coredump
+# ExecStartPre=ulimit -c unlimited
+
+SyslogIdentifier={{.Name}}
+StandardOutput=append:{{.StandardOutPath}}
+StandardError=append:{{.StandardErrorPath}}
+
+
+
+
+`
+
+	// tplLaunchdService is the plist of launchd.
+	tplLaunchdService = `<?xml version="1.0" encoding="UTF-8"?>
+<!DOCTYPE plist PUBLIC "-//Apple//DTD PLIST 1.0//EN" "http://www.apple.com/DTDs/PropertyList-1.0.dtd">
+<plist version="1.0">
+<dict>
+    <key>Label</key>
+    <string>{{.Name}}</string>
+    <key>Program</key>
+    <string>{{.ExecutablePath}}</string>
+    <key>ProgramArguments</key>
+    <array>
+        {{range $k, $v := .ArgsForInstall -}}
+        <string>{{$v}}</string>
+        {{end -}}
+    </array>
+    <key>KeepAlive</key>
+    <true/>
+    <key>RunAtLoad</key>
+	{{if .AutoLoad}}
+	<true/>
+	{{else}}
+    <false/>
+	{{end}}
+    <key>OnDemand</key>
+    <true/>
+    <key>LaunchOnlyOnce</key>
+    <false/>
+    <key>StandardOutPath</key>
+    <string>{{.StandardOutPath}}</string>
+    <key>StandardErrorPath</key>
+    <string>{{.StandardErrorPath}}</string>
+</dict>
+</plist>`
+
+	// tplSysVScript is the LSB init script of sysvinit. The service
+	// writes its pidfile itself, which the script waits for.
+	tplSysVScript = `#!/bin/sh
+### BEGIN INIT INFO
+# Provides:          {{.Name}}
+# Required-Start:    $remote_fs $network $syslog{{range .Needs}} {{.}}{{end}}
+# Required-Stop:     $remote_fs $network $syslog{{range .Needs}} {{.}}{{end}}
+{{if .Uses}}# Should-Start:     {{range .Uses}} {{.}}{{end}}
+# Should-Stop:      {{range .Uses}} {{.}}{{end}}
+{{end -}}
+# Default-Start:     2 3 4 5
+# Default-Stop:      0 1 6
+# Short-Description: {{.ScreenName}}
+# Description:       {{.Desc}}
+### END INIT INFO
+
+### {{.ScreenName}} services
+### executable: {{.ExecutablePath}}
+
+NAME={{quote .Name}}
+DAEMON={{quote .ExecutablePath}}
+PIDFILE={{if .PIDFile}}{{quote .PIDFile}}{{else}}/var/run/{{quote .Name}}/{{quote .Name}}.pid{{end}}
+RUN_AS={{quote .User}}
+WORK_DIR={{if .WorkDir}}{{quote .WorkDir}}{{else}}/{{end}}
+STDOUT={{if .StandardOutPath}}{{quote .StandardOutPath}}{{else}}/dev/null{{end}}
+STDERR={{if .StandardErrorPath}}{{quote .StandardErrorPath}}{{else}}/dev/null{{end}}
+
+GLOBAL_OPTIONS=""
+OPTIONS=""
+{{if .EnvFilePath}}set -a
+[ -r {{quote .EnvFilePath}} ] && . {{quote .EnvFilePath}}
+set +a
+{{end -}}
+{{range $k, $v := .Env -}}
+export {{$k}}={{quote $v}}
+{{end}}
+is_running() {
+	[ -r "$PIDFILE" ] || return 1
+	pid=$(head -n 1 "$PIDFILE")
+	[ -n "$pid" ] && kill -0 "$pid" 2>/dev/null
+}
+
+do_run() {
+	cd "$WORK_DIR" || exit 1
+	exec "$DAEMON" $GLOBAL_OPTIONS {{shell .StartArgs}} $OPTIONS >>"$STDOUT" 2>>"$STDERR" </dev/null
+}
+
+do_start() {
+	if is_running; then
+		echo "$NAME is running"
+		return 0
+	fi
+	echo "Starting $NAME"
+	if [ -n "$RUN_AS" ] && [ "$(id -u)" = 0 ]; then
+		# the pidfile is written by the service as $RUN_AS
+		piddir=$(dirname "$PIDFILE")
+		case "$piddir" in
+		/run | /var/run) ;;
+		*) mkdir -p "$piddir" && chown "$RUN_AS" "$piddir" ;;
+		esac
+		su -s /bin/sh -c "exec \"$0\" run" "$RUN_AS" >/dev/null 2>&1 &
+	else
+		do_run >/dev/null 2>&1 &
+	fi
+	i=0
+	until is_running; do
+		i=$((i + 1))
+		if [ $i -gt 30 ]; then
+			echo "$NAME failed to start, see $STDERR"
+			return 1
+		fi
+		sleep 1
+	done
+}
+
+do_stop() {
+	if ! is_running; then
+		echo "$NAME is not running"
+		return 0
+	fi
+	echo "Stopping $NAME"
+	kill -TERM "$pid"
+	i=0
+	while kill -0 "$pid" 2>/dev/null; do
+		i=$((i + 1))
+		if [ $i -gt 60 ]; then
+			kill -KILL "$pid"
+			break
+		fi
+		sleep 1
+	done
+	rm -f "$PIDFILE"
+}
+
+case "$1" in
+start) do_start ;;
+stop) do_stop ;;
+restart | force-reload)
+	do_stop
+	do_start
+	;;
+reload)
+	is_running && kill -{{.ReloadSignal}} "$pid"
+	;;
+status)
+	if is_running; then
+		echo "$NAME is running, pid $pid"
+	else
+		echo "$NAME is not running"
+		exit 3
+	fi
+	;;
+run) do_run ;;
+*)
+	echo "Usage: $0 {start|stop|restart|reload|force-reload|status}" >&2
+	exit 2
+	;;
+esac
+`
+
+	// tplOpenRCScript is the init script of OpenRC, the service is
+	// supervised by supervise-daemon.
+	tplOpenRCScript = `#!/sbin/openrc-run
+### {{.ScreenName}} services
+### executable: {{.ExecutablePath}}
+
+name={{quote .Name}}
+description={{quote .Desc}}
+
+supervisor=supervise-daemon
+command={{quote .ExecutablePath}}
+command_args="$GLOBAL_OPTIONS {{shell .StartArgs}} $OPTIONS"
+{{if .User}}command_user={{quote .User}}{{if .Group}}:{{quote .Group}}{{end}}
+{{end -}}
+directory={{if .WorkDir}}{{quote .WorkDir}}{{else}}/{{end}}
+{{if .StandardOutPath}}output_log={{quote .StandardOutPath}}
+{{end -}}
+{{if .StandardErrorPath}}error_log={{quote .StandardErrorPath}}
+{{end -}}
+respawn_delay=5
+
+extra_started_commands="reload"
+
+depend() {
+	need net{{range .Needs}} {{.}}{{end}}
+	use logger{{range .Uses}} {{.}}{{end}}
+}
+
+reload() {
+	ebegin "Reloading $RC_SVCNAME"
+	supervise-daemon "$RC_SVCNAME" --signal {{.ReloadSignal}}
+	eend $?
+}
+`
+
+	// tplOpenRCConf is /etc/conf.d/<name> of OpenRC, the env file
+	// sourced by the init script.
+	tplOpenRCConf = `### {{.ScreenName}} configurations
+### executable: {{.ExecutablePath}}
+
+#
+# the service startup command line is like:
+#
+#	$ service-app [global-options] server start [options]
+#
+GLOBAL_OPTIONS=""
+OPTIONS=""
+{{range $k, $v := .Env}}
+export {{$k}}={{quote $v}}
+{{- end}}
+`
+
+	// tplRcDScript is the rc.d script of FreeBSD, run by daemon(8). The
+	// service writes its pidfile itself.
+	tplRcDScript = `#!/bin/sh
+#
+# PROVIDE: {{.RcName}}
+# REQUIRE: LOGIN NETWORKING{{range .Needs}} {{.}}{{end}}
+# KEYWORD: shutdown
+#
+### {{.ScreenName}} services
+### executable: {{.ExecutablePath}}
+#
+# Add the following line to /etc/rc.conf to enable {{.Name}}:
+#
+# {{.RcName}}_enable="YES"
+#
+
+. /etc/rc.subr
+
+name={{.RcName}}
+rcvar={{.RcName}}_enable
+
+load_rc_config $name
+
+: ${ {{- .RcName}}_enable:="NO"}
+{{if .User}}: ${ {{- .RcName}}_user:={{quote .User}}}
+{{end -}}
+{{if .Group}}: ${ {{- .RcName}}_group:={{quote .Group}}}
+{{end -}}
+{{if .WorkDir}}: ${ {{- .RcName}}_chdir:={{quote .WorkDir}}}
+{{end -}}
+{{if .EnvFilePath}}: ${ {{- .RcName}}_env_file:={{quote .EnvFilePath}}}
+{{end -}}
+{{if .Env}}: ${ {{- .RcName}}_env:="{{range $i, $kv := .EnvPairs}}{{if $i}} {{end}}{{$kv}}{{end}}"}
+{{end}}
+pidfile={{if .PIDFile}}{{quote .PIDFile}}{{else}}/var/run/{{quote .Name}}/{{quote .Name}}.pid{{end}}
+procname={{quote .ExecutablePath}}
+command=/usr/sbin/daemon
+command_args="-f{{if .StandardOutPath}} -o {{quote .StandardOutPath}}{{end}} -- ${procname} {{shell .StartArgs}}"
+sig_reload={{.ReloadSignal}}
+extra_commands="reload"
+start_precmd="${name}_prestart"
+
+# the pidfile is written by the service as ${name}_user
+{{.RcName}}_prestart()
+{
+	piddir=$(dirname "$pidfile")
+	case "$piddir" in
+	/run | /var/run) ;;
+	*) install -d ${ {{- .RcName}}_user:+-o "${ {{- .RcName}}_user}"} "$piddir" ;;
+	esac
+}
+
+run_rc_command "$1"
+`
+
+	// tplRunitRun is the run script of runit, the service runs in
+	// foreground as the child of runsv.
+	tplRunitRun = `#!/bin/sh
+### {{.ScreenName}} services
+### executable: {{.ExecutablePath}}
+
+exec 2>&1
+{{range .Needs -}}
+sv -w 30 check {{quote .}} >/dev/null || exit 1
+{{end}}
+GLOBAL_OPTIONS=""
+OPTIONS=""
+{{if .EnvFilePath}}set -a
+[ -r {{quote .EnvFilePath}} ] && . {{quote .EnvFilePath}}
+set +a
+{{end -}}
+{{range $k, $v := .Env -}}
+export {{$k}}={{quote $v}}
+{{end}}
+cd {{if .WorkDir}}{{quote .WorkDir}}{{else}}/{{end}} || exit 1
+exec {{if .User}}chpst -u {{quote .User}}{{if .Group}}:{{quote .Group}}{{end}} {{end}}{{quote .ExecutablePath}} $GLOBAL_OPTIONS {{shell .StartArgs}} $OPTIONS
+`
+
+	// tplRunitLog is the log/run script of runit, the output of the
+	// service is kept by svlogd.
+	tplRunitLog = `#!/bin/sh
+LOG_DIR={{if .LogDir}}{{quote .LogDir}}{{else}}/var/log{{end}}/{{quote .Name}}
+mkdir -p "$LOG_DIR"{{if .User}} && chown {{quote .User}} "$LOG_DIR"{{end}}
+exec {{if .User}}chpst -u {{quote .User}} {{end}}svlogd -tt "$LOG_DIR"
+`
+
+	// tplSysusers is the sysusers.d(5) snippet declaring User and
+	// Group in the packages.
+	tplSysusers = `# system user and group of {{.ServiceName}}
+{{if and .Group (ne .Group .User)}}g {{.Group}} -
+{{end}}{{if .User}}u {{.User}} - "{{.ScreenName}} service" {{.Home}} -
+{{if and .Group (ne .Group .User)}}m {{.User}} {{.Group}}
+{{end}}{{end}}`
+
+	// tplTmpfiles is the tmpfiles.d(5) snippet creating the
+	// directories of the service in the packages.
+	tplTmpfiles = `# the directories of {{.ServiceName}}
+{{range .Dirs}}d {{.Path}} {{printf "%04o" .Mode}} {{or $.User "-"}} {{or $.Group "-"}} -
+{{end}}`
+
+	// tplProvision creates User, Group and Dirs in the maintainer
+	// scripts, by systemd-sysusers and systemd-tmpfiles if available.
+	tplProvision = `{{if or .User .Group}}
+	if command -v systemd-sysusers >/dev/null 2>&1; then
+		systemd-sysusers {{quote (printf "%s.conf" .Name)}}
+	else
+{{- if and .Group (ne .Group .User)}}
+		getent group {{quote .Group}} >/dev/null || groupadd --system {{quote .Group}}
+{{- end}}
+{{- if .User}}
+		getent passwd {{quote .User}} >/dev/null ||
+			useradd --system --no-create-home --home-dir {{quote .Home}} --shell /usr/sbin/nologin {{- if and .Group (ne .Group .User)}} --gid {{quote .Group}}{{else}} --user-group{{end}} {{quote .User}}
+{{- end}}
+	fi
+{{end}}
+{{- if .Dirs}}
+	if command -v systemd-tmpfiles >/dev/null 2>&1; then
+		systemd-tmpfiles --create {{quote (printf "%s.conf" .Name)}} || true
+	else
+{{- range .Dirs}}
+		install -d -m {{printf "%04o" .Mode}} {{- if $.User}} -o {{quote $.User}}{{end}}{{if $.Group}} -g {{quote $.Group}}{{end}} {{quote .Path}}
+{{- end}}
+	fi
+{{end}}`
+
+	// tplDebPostinst is the postinst of deb: the service is enabled
+	// and started at the first installing if AutoLoad, or restarted at
+	// upgrading if it is enabled or running.
+	tplDebPostinst = `#!/bin/sh
+# postinst of {{.ServiceName}}
+set -e
+
+UNIT={{quote .ServiceName}}
+
+case "$1" in
+configure | abort-upgrade | abort-deconfigure | abort-remove)` + tplProvision + `
+	if command -v systemctl >/dev/null 2>&1; then
+		# masked at removing
+		systemctl unmask "$UNIT" >/dev/null 2>&1 || true
+{{- if .AutoLoad}}
+		if [ -z "$2" ]; then
+			systemctl enable "$UNIT" || true
+		fi
+{{- end}}
+	fi
+	if [ -d /run/systemd/system ]; then
+		systemctl daemon-reload || true
+		if [ -n "$2" ]; then
+			# upgrading, or reinstalling after removed
+			if systemctl is-enabled --quiet "$UNIT"; then
+				systemctl restart "$UNIT" || true
+			else
+				systemctl try-restart "$UNIT" || true
+			fi
+{{- if .AutoLoad}}
+		else
+			systemctl start "$UNIT" || true
+{{- end}}
+		fi
+	fi
+	;;
+esac
+
+exit 0
+`
+
+	// tplDebPrerm is the prerm of deb, the service is stopped at
+	// removing, and restarted by postinst at upgrading.
+	tplDebPrerm = `#!/bin/sh
+# prerm of {{.ServiceName}}
+set -e
+
+UNIT={{quote .ServiceName}}
+
+if [ "$1" = remove ] && [ -d /run/systemd/system ]; then
+	systemctl stop "$UNIT" || true
+fi
+
+exit 0
+`
+
+	// tplDebPostrm is the postrm of deb. The unit is masked at
+	// removing, since the conffiles are left, and unmasked and
+	// forgotten at purging.
+	tplDebPostrm = `#!/bin/sh
+# postrm of {{.ServiceName}}
+set -e
+
+UNIT={{quote .ServiceName}}
+
+case "$1" in
+remove)
+	if command -v systemctl >/dev/null 2>&1; then
+		systemctl mask "$UNIT" >/dev/null 2>&1 || true
+	fi
+	;;
+purge)
+	if command -v systemctl >/dev/null 2>&1; then
+		systemctl unmask "$UNIT" >/dev/null 2>&1 || true
+	fi
+	rm -f /etc/systemd/system/*.wants/"$UNIT" /etc/systemd/system/*.requires/"$UNIT"
+	;;
+esac
+if [ -d /run/systemd/system ]; then
+	systemctl daemon-reload || true
+fi
+
+exit 0
+`
+
+	// tplRPMPost is the %post of rpm, $1 is the number of the
+	// installed instances: 1 at the first installing, 2 at upgrading.
+	tplRPMPost = `#!/bin/sh
+# %post of {{.ServiceName}}
+
+UNIT={{quote .ServiceName}}
+
+if [ "$1" -ge 1 ]; then` + tplProvision + `
+	if [ -d /run/systemd/system ]; then
+		systemctl daemon-reload || true
+	fi
+fi
+{{- if .AutoLoad}}
+if [ "$1" -eq 1 ]; then
+	systemctl enable "$UNIT" || true
+	if [ -d /run/systemd/system ]; then
+		systemctl start "$UNIT" || true
+	fi
+fi
+{{- end}}
+
+exit 0
+`
+
+	// tplRPMPreun is the %preun of rpm, the service is disabled and
+	// stopped at removing, $1 is 0.
+	tplRPMPreun = `#!/bin/sh
+# %preun of {{.ServiceName}}
+
+UNIT={{quote .ServiceName}}
+
+if [ "$1" -eq 0 ]; then
+	systemctl --no-reload disable --now "$UNIT" >/dev/null 2>&1 || true
+fi
+
+exit 0
+`
+
+	// tplRPMPostun is the %postun of rpm, the service is restarted at
+	// upgrading if it is running, $1 is 1.
+	tplRPMPostun = `#!/bin/sh
+# %postun of {{.ServiceName}}
+
+UNIT={{quote .ServiceName}}
+
+if [ -d /run/systemd/system ]; then
+	systemctl daemon-reload || true
+	if [ "$1" -ge 1 ]; then
+		systemctl try-restart "$UNIT" || true
+	fi
+fi
+
+exit 0
+`
+)
@@ -0,0 +1,88 @@
+package service
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/hedzr/cmdr-addons/service/v2/render"
+)
+
+func TestRenderConfig(t *testing.T) {
+	config := &Config{
+		Name:         "demo",
+		Executable:   "/usr/local/bin/demo",
+		ExecStopArgs: "server stop --service",
+		User:         "demo",
+		LogDir:       "/var/log/demo",
+		EnvFile:      "/etc/default/demo",
+		Health:       &HealthConfig{WatchdogSec: "30s"},
+	}
+	rc := config.RenderConfig()
+	if rc.ServiceName != "demo.service" || rc.EnvFilePath != "/etc/default/demo" || rc.WatchdogSec != "30s" || rc.ReloadSignal != "HUP" {
+		t.Fatalf("bad render config: %+v", rc)
+	}
+	if !slices.Equal(rc.StopArgs, []string{"server", "stop", "--service"}) || rc.LogsDirectory != "demo" {
+		t.Fatalf("bad render config: %+v", rc)
+	}
+
+	for _, target := range render.Targets {
+		if _, err := render.Render(target, rc); err != nil {
+			t.Fatalf("%s: %v", target, err)
+		}
+	}
+}
+
+func TestWritePackage(t *testing.T) {
+	config := &Config{Name: "demo", Executable: "/usr/bin/demo", User: "demo", LogDir: "/var/log/demo"}
+	dest := t.TempDir()
+	if err := config.WritePackage(render.RPM, dest, filepath.Join(dest, "scripts")); err != nil {
+		t.Fatal(err)
+	}
+	for _, file := range []string{
+		"usr/lib/systemd/system/demo.service",
+		"etc/sysconfig/demo",
+		"usr/lib/sysusers.d/demo.conf",
+		"usr/lib/tmpfiles.d/demo.conf",
+		"scripts/post",
+	} {
+		if _, err := os.Stat(filepath.Join(dest, file)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	data, err := os.ReadFile(filepath.Join(dest, "usr/lib/tmpfiles.d/demo.conf"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{"d /var/lib/demo 0750 demo - -\n", "d /var/log/demo 0750 demo - -\n"} {
+		if !strings.Contains(string(data), want) {
+			t.Fatalf("expect %q in:\n%s", want, data)
+		}
+	}
+}
+
+func TestSplitArgs(t *testing.T) {
+	for s, want := range map[string][]string{
+		"server start -foreground":        {"server", "start", "-foreground"},
+		`run -f '/etc/my app/a.yaml'`:     {"run", "-f", "/etc/my app/a.yaml"},
+		`--name "it's \"ok\"" a\ b ''`:    {"--name", `it's "ok"`, "a b", ""},
+		"  spaced\t\targs  ":              {"spaced", "args"},
+		JoinArgs("x y", "it's", "", "$v"): {"x y", "it's", "", "$v"},
+	} {
+		if got := SplitArgs(s); !slices.Equal(got, want) {
+			t.Fatalf("SplitArgs(%q) = %q, want %q", s, got, want)
+		}
+	}
+
+	config := &Config{Name: "demo", Executable: "/opt/my app/demo", ExecStartArgs: JoinArgs("run", "-f", "/etc/my app/100%.yaml")}
+	files, err := render.Render(render.Systemd, config.RenderConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `ExecStart="/opt/my app/demo" $GLOBAL_OPTIONS run -f "/etc/my app/100%%.yaml" $OPTIONS` + "\n"
+	if text := string(files[render.ServiceFile(render.Systemd, config.RenderConfig())]); !strings.Contains(text, want) {
+		t.Fatalf("expect %q in:\n%s", want, text)
+	}
+}
@@ -2,8 +2,9 @@ package service
 
 import (
 	"context"
-	"os"
-	"syscall"
+	"fmt"
+	"sync"
+	"time"
 
 	"github.com/hedzr/is"
 	"github.com/hedzr/is/basics"
@@ -43,6 +44,14 @@ type mgmtS struct {
 	closeSelfCB func()
 	pidfile     *pidFileS
 
+	hooksMu         sync.Mutex
+	shutdownHooks   []shutdownHookS
+	controlHandlers map[string]ControlHandler
+	observers       []EventObserver
+	started         time.Time
+	backend         string // the name of the backend chose
+	metrics         serviceMetrics
+
 	fore          bool
 	serviceMode   bool
 	colorModeSave bool
@@ -77,8 +86,10 @@ func (s *mgmtS) Run(ctx context.Context, config *Config) (err error) {
 	return
 }
 
+// IsRunning reports whether the service process recorded in its
+// pidfile is alive.
 func (s *mgmtS) IsRunning() bool {
-	if s.pidfile == nil {
+	if s.pidfile != nil {
 		return s.pidfile.isRunning()
 	}
 	return false
@@ -92,6 +103,7 @@ func (s *mgmtS) Control(ctx context.Context, config *Config, cmd Command) (err e
 
 		if be.IsValid(ctx) {
 			dbglog.DebugContext(ctx, "[mgmtS] backend is valid", "backend", be)
+			s.backend = fmt.Sprint(be)
 
 			// if a backend needs to be cleanup at shutting down...
 			if c, ok := be.(basics.Peripheral); ok {
@@ -102,20 +114,77 @@ func (s *mgmtS) Control(ctx context.Context, config *Config, cmd Command) (err e
 			if cmd > MinCommand && cmd < MaxCommand {
 				dbglog.DebugContext(ctx, "[mgmtS] execute control command", "command", cmd, "backend", be)
 
+				if !s.serviceMode {
+					finish := s.audit(ctx, config, cmd)
+					defer func() {
+						if e := finish(err); e != nil && err == nil {
+							err = e
+						}
+					}()
+					if privilegedCommands[cmd] {
+						if err = config.validate(ctx, cmd, s.backend); err != nil {
+							return
+						}
+					}
+				}
+
 				config.makeSafety()
+				if p, ok := be.(configPreparer); ok {
+					p.prepareConfig(ctx, config)
+				}
 
+				if cmd == Start && s.fore && s.serviceMode && !systems.HasNTService {
+					switch config.initMode() {
+					case InitModeFork:
+						// the child process will create the pidfile.
+						return s.runInitFork(ctx, config)
+					case InitModeInProcess:
+						defer s.runInitInProcess(ctx, config)()
+					}
+				}
+
+				s.pidfile = pidfileOf(config)
 				if cmd == Start && s.serviceMode && !systems.HasNTService {
-					s.pidfile, err = newpidfile(ctx, s, config)
-					if err != nil {
-						var ep *os.PathError
-						if errors.As(err, &ep) {
-							if ep.Err == syscall.EACCES {
-								dbglog.DebugContext(ctx, "[mgmtS] [TODO] retry pidfile initializing with sudo", "pidfile", s.pidfile.file)
-							}
+					var pf *pidFileS
+					if pf, err = newpidfile(ctx, s, config); err != nil {
+						if errors.Is(err, ErrServiceIsRunning) {
+							return
 						}
+						// a service can run without its pidfile
+						dbglog.WarnContext(ctx, "[mgmtS] pidfile initializing failed", "pidfile", pf.file, "err", err)
+						err = nil
+					} else {
+						s.pidfile = pf
 					}
 				}
 
+				var done func(err error)
+				if done, err = s.observe(ctx, config, cmd); err != nil {
+					return
+				}
+				defer func() { done(err) }()
+
+				if !s.serviceMode {
+					// ask the running service first
+					if handled, e := s.controlRunning(ctx, config, be, cmd); handled {
+						return e
+					}
+				}
+
+				if cmd == Health {
+					// the probes don't depend on the backend
+					return s.health(ctx, config)
+				}
+				if cmd >= EnvGet && cmd <= EnvList {
+					return s.env(ctx, config, cmd)
+				}
+				if cmd == Restore || cmd == ListBackups {
+					return s.backups(ctx, config, be, cmd)
+				}
+				if cmd == AuditLog {
+					return s.auditLog(ctx, config)
+				}
+
 				if systems.HasNTService {
 					dbglog.InfoContext(ctx, "[mgmtS] control backend", "backend", be, "cmd", cmd)
 				}
@@ -136,7 +205,10 @@ func (s *mgmtS) Control(ctx context.Context, config *Config, cmd Command) (err e
 			return
 		}
 
-		dbglog.WarnContext(ctx, "[mgmtS] backend is invalid", "backend", be)
+		// the daemon backend is the fallback only if no init system
+		// is detected, a broken one is reported.
+		err = errors.New("backend %v is detected but not usable", be)
+		dbglog.WarnContext(ctx, "[mgmtS] backend is invalid", "backend", be, "err", err)
 		return
 	}
 
@@ -145,7 +217,37 @@ func (s *mgmtS) Control(ctx context.Context, config *Config, cmd Command) (err e
 }
 
 func (s *mgmtS) chooseBackend(ctx context.Context) (be Backend, err error) {
-	return ChooseBackend(ctx)
+	if be, err = ChooseBackend(ctx); err == nil && be == nil {
+		be = fallbackBackend(ctx)
+	}
+	return
+}
+
+// serveLoop prepares the service loop of the managed process: it
+// dispatches the signals not mapped to SignalQuit, serves the control
+// socket, reports the readiness, monitors the health and serves the
+// metrics, till the returned func is called.
+func (s *mgmtS) serveLoop(ctx context.Context, config *Config, logger Logger) (leave func()) {
+	s.started = time.Now()
+	stopSignals := s.dispatchSignals(ctx, config, logger)
+	stopControl := s.serveControl(ctx, config, logger)
+	notifyReady()
+	_ = s.emit(ctx, Event{Kind: Started, Command: Start, Config: config, Backend: s.backend})
+	s.metrics.started()
+	stopHealth := s.monitorHealth(ctx, config, logger)
+	stopMetrics := s.serveMetrics(ctx, config)
+	return func() {
+		stopMetrics()
+		stopHealth()
+		stopControl()
+		stopSignals()
+	}
+}
+
+// configPreparer is implemented by the backends which adjust Config
+// after makeSafety and before the pidfile is created.
+type configPreparer interface {
+	prepareConfig(ctx context.Context, config *Config)
 }
 
 func (s *mgmtS) NotifyLoggerCreated(logger ZLogger) {
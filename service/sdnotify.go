@@ -0,0 +1,25 @@
+package service
+
+import (
+	"net"
+	"os"
+)
+
+// sdNotify sends state to systemd if NOTIFY_SOCKET is set, such as
+// "READY=1" or "MAINPID=123". See sd_notify(3).
+//
+// It does nothing if we are not running under systemd.
+func sdNotify(state string) (err error) {
+	sock := os.Getenv("NOTIFY_SOCKET")
+	if sock == "" {
+		return
+	}
+
+	var conn net.Conn
+	if conn, err = net.Dial("unixgram", sock); err != nil {
+		return
+	}
+	defer conn.Close()
+	_, err = conn.Write([]byte(state))
+	return
+}
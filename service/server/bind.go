@@ -0,0 +1,69 @@
+package server
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+
+	"github.com/hedzr/store"
+
+	"github.com/hedzr/cmdr-addons/service/v2"
+)
+
+// bindConfig overrides the fields of config by the values in st. The
+// keys are the json names of the fields, such as "work_dir", or the
+// flag names such as "work-dir" if dashed. The string, bool and
+// string slice fields are bound, the zero values don't override.
+func bindConfig(config *service.Config, st store.Store, dashed bool) {
+	v := reflect.ValueOf(config).Elem()
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		key, _, _ := strings.Cut(f.Tag.Get("json"), ",")
+		if key == "" || key == "-" || !f.IsExported() {
+			continue
+		}
+		if dashed {
+			key = strings.ReplaceAll(key, "_", "-")
+		}
+		if !st.Has(key) {
+			continue
+		}
+
+		fv := v.Field(i)
+		switch {
+		case fv.Kind() == reflect.String:
+			if s := st.MustString(key); s != "" {
+				fv.SetString(s)
+			}
+		case fv.Kind() == reflect.Bool:
+			if st.MustBool(key) {
+				fv.SetBool(true)
+			}
+		case fv.Kind() == reflect.Slice && fv.Type().Elem().Kind() == reflect.String:
+			if ss := st.MustStringSlice(key); len(ss) > 0 {
+				fv.Set(reflect.ValueOf(ss).Convert(fv.Type()))
+			}
+		}
+	}
+}
+
+// bindEnv adds the KEY=VALUE pairs into Config.Env.
+func bindEnv(config *service.Config, pairs []string) {
+	for _, kv := range pairs {
+		k, v, ok := strings.Cut(kv, "=")
+		if k = strings.TrimSpace(k); !ok || k == "" {
+			continue
+		}
+		if config.Env == nil {
+			config.Env = make(map[string]string)
+		}
+		config.Env[k] = v
+	}
+}
+
+// appName is the default service name, the base name of this app.
+func appName() string {
+	return strings.TrimSuffix(filepath.Base(os.Args[0]), ".exe")
+}
@@ -0,0 +1,27 @@
+module github.com/hedzr/cmdr-addons/service/v2/server
+
+go 1.25.0
+
+replace github.com/hedzr/cmdr-addons/v2 => ../../
+
+replace github.com/hedzr/cmdr-addons/service/v2 => ../
+
+require (
+	github.com/hedzr/cmdr-addons/service/v2 v2.2.0
+	github.com/hedzr/cmdr/v2 v2.2.3
+	github.com/hedzr/store v1.4.3
+	gopkg.in/hedzr/errors.v3 v3.3.5
+)
+
+require (
+	github.com/hedzr/cmdr-addons/v2 v2.2.3 // indirect
+	github.com/hedzr/evendeep v1.4.3 // indirect
+	github.com/hedzr/is v0.9.5 // indirect
+	github.com/hedzr/logg v0.9.3 // indirect
+	github.com/pelletier/go-toml/v2 v2.3.1 // indirect
+	golang.org/x/exp v0.0.0-20260611194520-c48552f49976 // indirect
+	golang.org/x/net v0.57.0 // indirect
+	golang.org/x/sys v0.47.0 // indirect
+	golang.org/x/term v0.45.0 // indirect
+	gopkg.in/yaml.v3 v3.0.1 // indirect
+)
@@ -0,0 +1,352 @@
+// Package server attaches a ready-made "server" command tree to a
+// cmdr v2 app, to install and control the app as a system service
+// with the service package.
+//
+// How to use it:
+//
+//	app := cmdr.New(opts...).Info("myservice", "1.0.0")
+//	server.Attach(app,
+//	    server.WithConfig(&service.Config{
+//	        Name:        "myservice",
+//	        Description: "my service",
+//	    }),
+//	    server.WithEntity(&myEntity{}),
+//	)
+//	if err := app.Run(ctx); err != nil {
+//	    ...
+//	}
+//
+// It adds these commands:
+//
+//	server start [--foreground] [--service]
+//	server stop [--service] | restart | reload | status
+//	server install [--force] [--user USER] [--group GROUP] [--env KEY=VALUE] ...
+//	server uninstall | enable | disable | logs
+//	server verify [--dump yaml]
+//	server package --format deb|rpm --destdir DIR [--scripts DIR] [--executable FILE]
+//
+// The fields of service.Config are bound from the "app.service"
+// section of the cmdr store, that is, the config files and the env
+// vars loaded by cmdr, with the keys of their json names, such as
+// "app.service.work_dir". The flags override them. "--config FILE"
+// loads the whole Config from a yaml, toml or json file instead, see
+// service.LoadConfig.
+package server
+
+import (
+	"context"
+	"path"
+	"path/filepath"
+
+	"github.com/hedzr/cmdr/v2"
+	"github.com/hedzr/cmdr/v2/cli"
+	"github.com/hedzr/store"
+	"gopkg.in/hedzr/errors.v3"
+
+	"github.com/hedzr/cmdr-addons/service/v2"
+	"github.com/hedzr/cmdr-addons/service/v2/render"
+)
+
+// Opt customizes the server command tree.
+type Opt func(s *serverS)
+
+// SetupFunc prepares the manager before each command runs, such as
+// registering the shutdown hooks and the event observers.
+type SetupFunc func(ctx context.Context, m service.Manager, config *service.Config) (err error)
+
+// WithConfig specifies the base Config of the service, the store and
+// the flags override its fields.
+func WithConfig(config *service.Config) Opt {
+	return func(s *serverS) { s.config = config }
+}
+
+// WithEntity specifies the entity run by the service.
+func WithEntity(entity service.Entity) Opt {
+	return func(s *serverS) { s.entity = entity }
+}
+
+// WithSetup adds a func to prepare the manager before each command.
+func WithSetup(fn SetupFunc) Opt {
+	return func(s *serverS) { s.setups = append(s.setups, fn) }
+}
+
+// WithTitles replaces the titles of the root command, default
+// "server" and "s".
+func WithTitles(longTitle string, titles ...string) Opt {
+	return func(s *serverS) { s.title, s.titles = longTitle, titles }
+}
+
+// WithGroup puts the root command into group in the help screen.
+func WithGroup(group string) Opt {
+	return func(s *serverS) { s.group = group }
+}
+
+// WithStorePrefix replaces the section of the store bound to Config,
+// default "app.service".
+func WithStorePrefix(prefix string) Opt {
+	return func(s *serverS) { s.prefix = prefix }
+}
+
+// Attach attaches the server command tree to app.
+func Attach(app cli.App, opts ...Opt) {
+	s := &serverS{title: "server", titles: []string{"s"}, prefix: "app.service"}
+	for _, opt := range opts {
+		opt(s)
+	}
+	s.build(app.Cmd(s.title, s.titles...))
+}
+
+type serverS struct {
+	title  string
+	titles []string
+	group  string
+	prefix string
+	config *service.Config
+	entity service.Entity
+	setups []SetupFunc
+}
+
+// verify and pack are the pseudo commands of "server verify" and
+// "server package", they run without a manager.
+const (
+	verify = service.MaxCommand + 1 + iota
+	pack
+)
+
+func (s *serverS) build(b cli.CommandBuilder) {
+	b.Description("control this app as a system service",
+		"Install, uninstall and control this app as a system service,\n"+
+			"by systemd, launchd, the windows service manager or the others.")
+	if s.group != "" {
+		b.Group(s.group)
+	}
+	b.With(func(b cli.CommandBuilder) {
+		b.Flg("config", "c").
+			Default("").
+			Description("load the service config from FILE (yaml, toml or json)").
+			PlaceHolder("FILE").
+			Build()
+		b.Flg("user-level").
+			Default(false).
+			Description("a per-user service, instead of a system one").
+			Build()
+
+		s.cmd(b, service.Start, "start the service", func(b cli.CommandBuilder) {
+			b.Flg("foreground", "f").
+				Default(false).
+				Description("run in foreground, till it is interrupted").
+				Build()
+			b.Flg("service", "s").
+				Default(false).
+				Description("run as the service process, used by the service manager").
+				Build()
+		}, "start", "run")
+		s.cmd(b, service.Stop, "stop the service", func(b cli.CommandBuilder) {
+			b.Flg("service", "3").
+				Default(false).
+				Description("stop the service process, used by the service manager").
+				Build()
+		}, "stop", "halt", "quit")
+		s.cmd(b, service.Restart, "restart the service", nil, "restart", "re")
+		s.cmd(b, service.HotReload, "hot-reload the running service", nil, "reload", "hup")
+		s.cmd(b, service.Status, "show the status of the service", nil, "status", "st")
+		s.cmd(b, service.Install, "install the service", func(b cli.CommandBuilder) {
+			b.Flg("force", "f").
+				Default(false).
+				Description("reinstall it if installed already").
+				Build()
+			b.Flg("auto-enable").
+				Default(false).
+				Description("enable the service at boot").
+				Build()
+			b.Flg("user", "u").
+				Default("").
+				Description("run the service as USER, created if missing").
+				PlaceHolder("USER").
+				Build()
+			b.Flg("group", "g").
+				Default("").
+				Description("run the service in GROUP, created if missing").
+				PlaceHolder("GROUP").
+				Build()
+			b.Flg("work-dir", "w").
+				Default("").
+				Description("the working directory of the service").
+				PlaceHolder("DIR").
+				Build()
+			b.Flg("env", "e").
+				Default([]string{}).
+				Description("add KEY=VALUE into the env file of the service").
+				PlaceHolder("KEY=VALUE").
+				Build()
+		}, "install", "in")
+		s.cmd(b, service.Uninstall, "uninstall the service", nil, "uninstall", "rm", "remove")
+		s.cmd(b, service.Enable, "enable the service at boot", nil, "enable")
+		s.cmd(b, service.Disable, "disable the service at boot", nil, "disable")
+		s.cmd(b, service.ViewLog, "show the logs of the service", nil, "logs", "log")
+		s.cmd(b, verify, "validate the config of the service", func(b cli.CommandBuilder) {
+			b.Flg("dump", "d").
+				Default("").
+				Description("print the effective config as FORMAT: yaml, toml or json").
+				PlaceHolder("FORMAT").
+				Build()
+		}, "verify", "check")
+		s.cmd(b, pack, "write the deb or rpm package of the service into a staging directory", func(b cli.CommandBuilder) {
+			b.Flg("format", "t").
+				Default("deb").
+				Description("the package format: deb or rpm").
+				PlaceHolder("FORMAT").
+				Build()
+			b.Flg("destdir", "D").
+				Default("").
+				Description("the staging directory of the payload").
+				PlaceHolder("DIR").
+				Build()
+			b.Flg("scripts").
+				Default("").
+				Description("the directory of the maintainer scripts, default DESTDIR/DEBIAN for deb").
+				PlaceHolder("DIR").
+				Build()
+			b.Flg("executable", "x").
+				Default("").
+				Description("the installed executable, default /usr/bin/<name>").
+				PlaceHolder("FILE").
+				Build()
+			b.Flg("auto-enable").
+				Default(false).
+				Description("enable and start the service at installing the package").
+				Build()
+		}, "package", "pkg")
+	})
+}
+
+func (s *serverS) cmd(b cli.CommandBuilder, c service.Command, desc string, flags func(b cli.CommandBuilder), longTitle string, titles ...string) {
+	cb := b.Cmd(longTitle, titles...).
+		Description(desc).
+		OnAction(func(ctx context.Context, cmd cli.Cmd, args []string) (err error) {
+			return s.run(ctx, cmd, c, args)
+		})
+	if flags != nil {
+		cb.With(flags)
+		return
+	}
+	cb.Build()
+}
+
+// run runs the command c by a new manager.
+func (s *serverS) run(ctx context.Context, cmd cli.Cmd, c service.Command, args []string) (err error) {
+	flags := cmd.Store()
+	var config *service.Config
+	if config, err = s.loadConfig(flags); err != nil {
+		return
+	}
+	config.PositionalArgs = args
+	switch c {
+	case verify:
+		return s.verify(ctx, config, flags.MustString("dump"))
+	case pack:
+		return s.pack(config, flags)
+	}
+
+	m := service.New(ctx)
+	switch c {
+	case service.Start:
+		m.SetForegroundMode(flags.MustBool("foreground"))
+		m.SetServiceMode(flags.MustBool("service"))
+	case service.Stop:
+		m.SetServiceMode(flags.MustBool("service"))
+	case service.Install:
+		config.ForceReinstall = config.ForceReinstall || flags.MustBool("force")
+	}
+	for _, fn := range s.setups {
+		if err = fn(ctx, m, config); err != nil {
+			return
+		}
+	}
+	return m.Control(ctx, config, c)
+}
+
+func (s *serverS) verify(ctx context.Context, config *service.Config, dump string) (err error) {
+	if err = config.Validate(ctx); err != nil {
+		return
+	}
+	println("the config of", config.ServiceName(), "is valid.")
+	if dump != "" {
+		var data []byte
+		if data, err = config.Dump(dump); err != nil {
+			return
+		}
+		println()
+		print(string(data))
+	}
+	return
+}
+
+// pack writes the package of the service into the staging directory,
+// see service.Config.WritePackage.
+func (s *serverS) pack(config *service.Config, flags store.Store) (err error) {
+	format := render.PackageFormat(flags.MustString("format"))
+	destDir, scriptDir := flags.MustString("destdir"), flags.MustString("scripts")
+	if destDir == "" {
+		return errors.New("expect the staging directory by --destdir DIR")
+	}
+	if scriptDir == "" && format == render.Deb {
+		scriptDir = filepath.Join(destDir, "DEBIAN")
+	}
+	// the executable of this build host is not the installed one
+	if config.Executable == "" {
+		config.Executable = path.Join("/usr/bin", config.Name)
+	}
+	if err = config.WritePackage(format, destDir, scriptDir); err != nil {
+		return
+	}
+	println("the", string(format), "package of", config.ServiceName(), "is written into", destDir)
+	if scriptDir != "" {
+		println("the maintainer scripts are written into", scriptDir)
+	}
+	return
+}
+
+// loadConfig returns the Config bound from the store and the flags of
+// the command.
+func (s *serverS) loadConfig(flags store.Store) (config *service.Config, err error) {
+	return s.configFrom(cmdr.Store().WithPrefix(s.title), cmdr.Set().WithPrefix(s.prefix), flags)
+}
+
+// configFrom returns the Config loaded from the file of the persistent
+// "--config" flag, or else bound from the settings, and overridden by
+// the flags of the command.
+func (s *serverS) configFrom(persistent, settings, flags store.Store) (config *service.Config, err error) {
+	if file := persistent.MustString("config"); file != "" {
+		if config, err = service.LoadConfig(file, service.HostConfigPath(file)); err != nil {
+			return
+		}
+	} else {
+		config = &service.Config{}
+		if s.config != nil {
+			*config = *s.config
+		}
+		bindConfig(config, settings, false)
+	}
+	bindConfig(config, flags, true)
+	bindEnv(config, flags.MustStringSlice("env"))
+	if persistent.MustBool("user-level") {
+		config.UserLevel = true
+	}
+
+	if config.Name == "" {
+		config.Name = appName()
+	}
+	if config.Entity == nil && s.entity != nil {
+		config.Entity = s.entity
+	}
+	// the service manager runs this command tree to start and stop
+	// the service
+	if config.ExecStartArgs == "" {
+		config.ExecStartArgs = s.title + " start --foreground --service"
+	}
+	if config.ExecStopArgs == "" {
+		config.ExecStopArgs = s.title + " stop --service"
+	}
+	return
+}
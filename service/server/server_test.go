@@ -0,0 +1,110 @@
+package server
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	"github.com/hedzr/store"
+
+	"github.com/hedzr/cmdr-addons/service/v2"
+)
+
+// mapStore is an in-memory store.Store holding the keys used by
+// bindConfig and configFrom.
+type mapStore struct {
+	store.Store // the other methods are not used
+	m           map[string]any
+}
+
+func (s mapStore) Has(path string) bool { _, ok := s.m[path]; return ok }
+
+func (s mapStore) MustString(path string, d ...string) string {
+	v, _ := s.m[path].(string)
+	return v
+}
+
+func (s mapStore) MustBool(path string, d ...bool) bool {
+	v, _ := s.m[path].(bool)
+	return v
+}
+
+func (s mapStore) MustStringSlice(path string, d ...[]string) []string {
+	v, _ := s.m[path].([]string)
+	return v
+}
+
+func TestBindConfig(t *testing.T) {
+	config := &service.Config{Name: "demo", WorkDir: "/opt/demo", User: "demo"}
+	bindConfig(config, mapStore{m: map[string]any{
+		"work_dir":        "/srv/demo",
+		"user":            "", // zero values don't override
+		"auto_enable":     true,
+		"user_level":      false,
+		"dependencies":    []string{"postgresql"},
+		"exec-start-args": "run", // a flag name, not bound from the settings
+	}}, false)
+	if config.WorkDir != "/srv/demo" || config.User != "demo" || !config.AutoEnable || config.ExecStartArgs != "" {
+		t.Fatalf("bad binding: %+v", config)
+	}
+	if !slices.Equal(config.Dependencies, []string{"postgresql"}) {
+		t.Fatalf("bad dependencies: %v", config.Dependencies)
+	}
+
+	bindConfig(config, mapStore{m: map[string]any{
+		"work-dir":    "/var/lib/demo",
+		"group":       "staff",
+		"auto-enable": false,
+		"user_level":  true, // a settings key, not bound from the flags
+	}}, true)
+	if config.WorkDir != "/var/lib/demo" || config.Group != "staff" || !config.AutoEnable || config.UserLevel {
+		t.Fatalf("bad binding of the flags: %+v", config)
+	}
+}
+
+func TestConfigFrom(t *testing.T) {
+	s := &serverS{title: "server", config: &service.Config{Name: "demo", Description: "demo service"}}
+	none := mapStore{m: map[string]any{}}
+
+	config, err := s.configFrom(none, mapStore{m: map[string]any{"work_dir": "/opt/demo"}}, mapStore{m: map[string]any{
+		"user": "demo",
+		"env":  []string{"PORT=8080", "bad"},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if config.Name != "demo" || config.WorkDir != "/opt/demo" || config.User != "demo" || config.Env["PORT"] != "8080" || len(config.Env) != 1 {
+		t.Fatalf("bad config: %+v", config)
+	}
+	if config.ExecStartArgs != "server start --foreground --service" || config.ExecStopArgs != "server stop --service" {
+		t.Fatalf("bad default args: %q, %q", config.ExecStartArgs, config.ExecStopArgs)
+	}
+	if s.config.WorkDir != "" {
+		t.Fatal("the base config is modified")
+	}
+
+	// --config loads the file and its host override, the settings are
+	// ignored and the flags still override
+	file := filepath.Join(t.TempDir(), "service.yaml")
+	for name, content := range map[string]string{
+		file:                         "name: web\nwork_dir: /srv/web\nexec_stop_args: halt\n",
+		service.HostConfigPath(file): "user: www\n",
+	} {
+		if err = os.WriteFile(name, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	config, err = s.configFrom(mapStore{m: map[string]any{"config": file, "user-level": true}},
+		mapStore{m: map[string]any{"work_dir": "/opt/demo"}},
+		mapStore{m: map[string]any{"group": "www"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if config.Name != "web" || config.WorkDir != "/srv/web" || config.User != "www" || config.Group != "www" || !config.UserLevel {
+		t.Fatalf("bad config from file: %+v", config)
+	}
+	if config.ExecStartArgs != "server start --foreground --service" || config.ExecStopArgs != "halt" {
+		t.Fatalf("bad args: %q, %q", config.ExecStartArgs, config.ExecStopArgs)
+	}
+}
@@ -0,0 +1,200 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"gopkg.in/hedzr/errors.v3"
+
+	"github.com/hedzr/cmdr-addons/v2/tool/dbglog"
+)
+
+// EntityDrainAware is implemented by the entity which can stop
+// accepting new work (close its listeners, pause its consumers, ...)
+// before it is stopped.
+type EntityDrainAware interface {
+	Drain(ctx context.Context, config *Config, logger Logger) (err error)
+}
+
+// EntityShutdownAware is implemented by the entity which releases its
+// resources at shutting down, after it was drained. It runs in the
+// managed process, unlike EntityStopAware, which overrides the stop
+// command of the CLI.
+type EntityShutdownAware interface {
+	Shutdown(ctx context.Context, config *Config, logger Logger) (err error)
+}
+
+// ShutdownHook is a drain or close step run at shutting down.
+type ShutdownHook func(ctx context.Context) (err error)
+
+// ShutdownStep is the report of a step of the shutdown pipeline.
+type ShutdownStep struct {
+	Name     string
+	Elapsed  time.Duration
+	TimedOut bool // the step was abandoned at its deadline
+	Err      error
+}
+
+func (st ShutdownStep) String() string {
+	switch {
+	case st.TimedOut:
+		return fmt.Sprintf("%s: timed out after %v", st.Name, st.Elapsed.Round(time.Millisecond))
+	case st.Err != nil:
+		return fmt.Sprintf("%s: failed after %v: %v", st.Name, st.Elapsed.Round(time.Millisecond), st.Err)
+	}
+	return fmt.Sprintf("%s: ok (%v)", st.Name, st.Elapsed.Round(time.Millisecond))
+}
+
+// ShutdownReport is the result of the shutdown pipeline.
+type ShutdownReport struct {
+	Steps []ShutdownStep
+}
+
+// TimedOut returns the names of the steps which timed out.
+func (r ShutdownReport) TimedOut() (names []string) {
+	for _, st := range r.Steps {
+		if st.TimedOut {
+			names = append(names, st.Name)
+		}
+	}
+	return
+}
+
+// Err returns the errors of failed or timed-out steps, or nil.
+func (r ShutdownReport) Err() error {
+	var errs []error
+	for _, st := range r.Steps {
+		if st.TimedOut {
+			errs = append(errs, errors.New("shutdown step %q timed out", st.Name))
+		} else if st.Err != nil {
+			errs = append(errs, st.Err)
+		}
+	}
+	if len(errs) == 0 {
+		return nil
+	}
+	return errors.New("graceful shutdown incomplete").WithErrors(errs...)
+}
+
+func (r ShutdownReport) String() string {
+	var sb strings.Builder
+	sb.WriteString("shutdown:")
+	for _, st := range r.Steps {
+		sb.WriteString("\n  ")
+		sb.WriteString(st.String())
+	}
+	return sb.String()
+}
+
+const defaultShutdownHookTimeout = 5 * time.Second
+
+type shutdownHookS struct {
+	name    string
+	timeout time.Duration
+	hook    ShutdownHook
+}
+
+// OnShutdown registers a drain or close hook. The hooks run after the
+// entity stopped, in the reverse order of registration, each bounded
+// by its timeout (5s if timeout <= 0).
+func (s *mgmtS) OnShutdown(name string, timeout time.Duration, hook ShutdownHook) Manager {
+	if timeout <= 0 {
+		timeout = defaultShutdownHookTimeout
+	}
+	s.hooksMu.Lock()
+	defer s.hooksMu.Unlock()
+	s.shutdownHooks = append(s.shutdownHooks, shutdownHookS{name, timeout, hook})
+	return s
+}
+
+// shutdown runs the graceful shutdown pipeline in the managed process:
+//
+//  1. EntityDrainAware.Drain, to stop accepting new work;
+//  2. EntityShutdownAware.Shutdown;
+//  3. the hooks registered by OnShutdown, in reverse order.
+//
+// The first two steps share a deadline of Config.TimeoutStopSec. A
+// step is abandoned at its deadline and reported as timed out, the
+// pipeline goes on.
+//
+// It never asks the init system to stop us, we are being stopped by
+// it already.
+func (s *mgmtS) shutdown(ctx context.Context, config *Config, logger Logger) (report ShutdownReport) {
+	// the parent ctx is cancelled typically, keep its values only.
+	ctx = context.WithoutCancel(ctx)
+
+	timeout := parseTimespan(config.TimeoutStopSec, defaultStopTimeout)
+	entityCtx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	if fn, ok := config.Entity.(EntityDrainAware); ok {
+		report.Steps = append(report.Steps, runShutdownStep(entityCtx, "drain", func(ctx context.Context) error {
+			return fn.Drain(ctx, config, logger)
+		}))
+	}
+	if fn, ok := config.Entity.(EntityShutdownAware); ok {
+		report.Steps = append(report.Steps, runShutdownStep(entityCtx, "shutdown", func(ctx context.Context) error {
+			return fn.Shutdown(ctx, config, logger)
+		}))
+	}
+
+	s.hooksMu.Lock()
+	hooks := append([]shutdownHookS(nil), s.shutdownHooks...)
+	s.hooksMu.Unlock()
+	for i := len(hooks) - 1; i >= 0; i-- {
+		h := hooks[i]
+		hookCtx, cancel := context.WithTimeout(ctx, h.timeout)
+		report.Steps = append(report.Steps, runShutdownStep(hookCtx, h.name, h.hook))
+		cancel()
+	}
+
+	for _, st := range report.Steps {
+		if st.TimedOut || st.Err != nil {
+			dbglog.WarnContext(ctx, "[shutdown] "+st.String(), "service", config.ServiceName())
+		} else {
+			dbglog.InfoContext(ctx, "[shutdown] "+st.String(), "service", config.ServiceName())
+		}
+	}
+	if names := report.TimedOut(); len(names) > 0 && logger != nil {
+		_ = logger.Warnf("[shutdown] timed out: %s\n", strings.Join(names, ", "))
+	}
+	return
+}
+
+// shutdownBudget returns the longest time the shutdown pipeline may
+// take.
+func (s *mgmtS) shutdownBudget(config *Config) (d time.Duration) {
+	d = parseTimespan(config.TimeoutStopSec, defaultStopTimeout)
+	s.hooksMu.Lock()
+	defer s.hooksMu.Unlock()
+	for _, h := range s.shutdownHooks {
+		d += h.timeout
+	}
+	return
+}
+
+// runShutdownStep calls fn and waits till it returned or ctx is done.
+// A panic in fn is converted into an error.
+func runShutdownStep(ctx context.Context, name string, fn func(ctx context.Context) error) (st ShutdownStep) {
+	st.Name = name
+	started := time.Now()
+	done := make(chan error, 1)
+	go func() {
+		defer func() {
+			if r := recover(); r != nil {
+				done <- errors.New("shutdown step %q panicked: %v", name, r)
+			}
+		}()
+		done <- fn(ctx)
+	}()
+
+	select {
+	case st.Err = <-done:
+	case <-ctx.Done():
+		st.TimedOut = true
+	}
+	st.Elapsed = time.Since(started)
+	return
+}
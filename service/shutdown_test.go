@@ -0,0 +1,96 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"testing"
+	"time"
+)
+
+type shutdownEntity struct {
+	entityS
+	calls []string
+	block bool
+}
+
+func (e *shutdownEntity) Desc() string           { return e.description }
+func (e *shutdownEntity) ExecutablePath() string { return e.executable }
+
+func (e *shutdownEntity) Drain(ctx context.Context, config *Config, logger Logger) (err error) {
+	e.calls = append(e.calls, "drain")
+	return
+}
+
+func (e *shutdownEntity) Shutdown(ctx context.Context, config *Config, logger Logger) (err error) {
+	e.calls = append(e.calls, "shutdown")
+	if e.block {
+		<-ctx.Done()
+		time.Sleep(50 * time.Millisecond)
+	}
+	return
+}
+
+func TestShutdown_order(t *testing.T) {
+	entity := &shutdownEntity{entityS: entityS{name: "demo"}}
+	config := &Config{Name: "demo", Entity: entity, TimeoutStopSec: "1"}
+
+	var order []string
+	m := &mgmtS{}
+	for _, name := range []string{"db", "cache", "listener"} {
+		m.OnShutdown(name, 0, func(ctx context.Context) error {
+			order = append(order, name)
+			if name == "cache" {
+				return errors.New("flush failed")
+			}
+			return nil
+		})
+	}
+
+	report := m.shutdown(context.Background(), config, nil)
+	if !slices.Equal(entity.calls, []string{"drain", "shutdown"}) {
+		t.Fatalf("bad entity calls: %v", entity.calls)
+	}
+	if !slices.Equal(order, []string{"listener", "cache", "db"}) {
+		t.Fatalf("hooks should run in reverse order, got %v", order)
+	}
+	if len(report.Steps) != 5 || report.Steps[3].Err == nil || report.Err() == nil {
+		t.Fatalf("expect the failed hook reported, got %v", report)
+	}
+	if len(report.TimedOut()) != 0 {
+		t.Fatalf("expect no timed-out steps, got %v", report.TimedOut())
+	}
+}
+
+func TestShutdown_timeout(t *testing.T) {
+	entity := &shutdownEntity{entityS: entityS{name: "demo"}, block: true}
+	config := &Config{Name: "demo", Entity: entity, TimeoutStopSec: "100ms"}
+
+	closed := false
+	m := &mgmtS{}
+	m.OnShutdown("closer", time.Second, func(ctx context.Context) error {
+		closed = true
+		return nil
+	})
+	release := make(chan struct{})
+	defer close(release)
+	m.OnShutdown("stuck", 50*time.Millisecond, func(ctx context.Context) error {
+		<-release // ignores ctx, released at the end of the test
+		return nil
+	})
+
+	started := time.Now()
+	report := m.shutdown(context.Background(), config, nil)
+	if elapsed := time.Since(started); elapsed > time.Second {
+		t.Fatalf("shutdown took too long: %v", elapsed)
+	}
+	if !slices.Equal(report.TimedOut(), []string{"shutdown", "stuck"}) {
+		t.Fatalf("bad timed-out steps: %v", report)
+	}
+	if !closed {
+		t.Fatal("expect the hooks after a timed-out one still run")
+	}
+	if got := m.shutdownBudget(config); got != 100*time.Millisecond+time.Second+50*time.Millisecond {
+		t.Fatalf("bad shutdown budget: %v", got)
+	}
+}
@@ -5,6 +5,7 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"time"
 )
 
 // const Version = "v0.1.0"
@@ -15,6 +16,19 @@ type Manager interface {
 	Err() error
 
 	WithEntity(entity Entity) Manager
+	// OnShutdown registers a drain or close hook which runs at
+	// graceful shutdown, after the entity stopped.
+	OnShutdown(name string, timeout time.Duration, hook ShutdownHook) Manager
+	// OnControl registers a custom command of the control socket of
+	// the running service, see SendControl.
+	OnControl(name string, handler ControlHandler) Manager
+	// OnEvent registers an observer of the lifecycle events, such as
+	// BeforeInstall and Started. An observer can veto an operation by
+	// returning an error for its before event.
+	OnEvent(observer EventObserver) Manager
+	// Group returns the group of several cooperating services, which
+	// are controlled in their dependency order.
+	Group(name string, configs ...*Config) (g *ServiceGroup, err error)
 
 	SetForegroundMode(b bool) // run in foreground-mode?
 	SetServiceMode(b bool)    // run in service-mode?
@@ -40,51 +54,117 @@ type Service interface {
 }
 
 type Config struct {
-	Name        string
-	DisplayName string
-	Description string
-
-	WorkDir         string            // service main workDir, eg: /var/lib/service1/
-	Executable      string            // eg: /var/lib/service1/bin/service1
-	ArgsForInstall  []string          //
-	Env             map[string]string //
-	RunAs           string            // run as user
-	User            string
-	Group           string
-	TimeoutStartSec string
-	TimeoutStopSec  string
-	RestartSec      string
-	PIDFile         string
-
-	Dependencies []string
-
-	StandardOutPath   string // "/dev/null" is valid for darwin and linux
-	StandardErrorPath string //
-
-	Entity Entity
-
-	Type string // for systemd: simple, forking, exec, oneshot, dbus, notify, idle
-
-	ForceReinstall     bool
-	AutoEnable         bool
-	DelayedAutoStart   bool
-	UserLevel          bool
-	VendorDomainPrefix string
-
-	CmdLines    []string
-	TemplateDir string
-	RunDir      string
-	LogDir      string
-	TempDir     string
-
-	RetCode int // return error code to main func if non-zero
+	Name        string `json:"name,omitempty" yaml:"name,omitempty" toml:"name,omitempty"`
+	DisplayName string `json:"display_name,omitempty" yaml:"display_name,omitempty" toml:"display_name,omitempty"`
+	Description string `json:"description,omitempty" yaml:"description,omitempty" toml:"description,omitempty"`
+
+	WorkDir         string            `json:"work_dir,omitempty" yaml:"work_dir,omitempty" toml:"work_dir,omitempty"`                         // service main workDir, eg: /var/lib/service1/
+	Executable      string            `json:"executable,omitempty" yaml:"executable,omitempty" toml:"executable,omitempty"`                   // eg: /var/lib/service1/bin/service1
+	ArgsForInstall  []string          `json:"args_for_install,omitempty" yaml:"args_for_install,omitempty" toml:"args_for_install,omitempty"` //
+	Env             map[string]string `json:"env,omitempty" yaml:"env,omitempty" toml:"env,omitempty"`                                        // synced into the env file at installing, see SyncEnvFile
+	RunAs           string            `json:"run_as,omitempty" yaml:"run_as,omitempty" toml:"run_as,omitempty"`                               // run as user
+	User            string            `json:"user,omitempty" yaml:"user,omitempty" toml:"user,omitempty"`
+	Group           string            `json:"group,omitempty" yaml:"group,omitempty" toml:"group,omitempty"`
+	TimeoutStartSec string            `json:"timeout_start_sec,omitempty" yaml:"timeout_start_sec,omitempty" toml:"timeout_start_sec,omitempty"`
+	TimeoutStopSec  string            `json:"timeout_stop_sec,omitempty" yaml:"timeout_stop_sec,omitempty" toml:"timeout_stop_sec,omitempty"`
+	RestartSec      string            `json:"restart_sec,omitempty" yaml:"restart_sec,omitempty" toml:"restart_sec,omitempty"`
+	PIDFile         string            `json:"pid_file,omitempty" yaml:"pid_file,omitempty" toml:"pid_file,omitempty"`
+
+	StopSignal      string `json:"stop_signal,omitempty" yaml:"stop_signal,omitempty" toml:"stop_signal,omitempty"`                   // signal to stop the service, such as "TERM" (default), "QUIT", "INT"
+	FinalStopSignal string `json:"final_stop_signal,omitempty" yaml:"final_stop_signal,omitempty" toml:"final_stop_signal,omitempty"` // optional second signal sent after TimeoutStopSec, before SIGKILL
+
+	Dependencies     []string `json:"dependencies,omitempty" yaml:"dependencies,omitempty" toml:"dependencies,omitempty"`                // the services required, started before this one. Requires= and After= for systemd
+	WeakDependencies []string `json:"weak_dependencies,omitempty" yaml:"weak_dependencies,omitempty" toml:"weak_dependencies,omitempty"` // the services wanted, started before this one if present. Wants= and After= for systemd
+
+	// Target is the umbrella target unit this service is part of, set
+	// by ServiceGroup.Install if ServiceGroup.Target is true.
+	Target string `json:"target,omitempty" yaml:"target,omitempty" toml:"target,omitempty"`
+
+	StandardOutPath   string `json:"standard_out_path,omitempty" yaml:"standard_out_path,omitempty" toml:"standard_out_path,omitempty"`       // "/dev/null" is valid for darwin and linux
+	StandardErrorPath string `json:"standard_error_path,omitempty" yaml:"standard_error_path,omitempty" toml:"standard_error_path,omitempty"` //
+
+	Entity Entity `json:"-" yaml:"-" toml:"-"`
+
+	// Supervisor restarts the entity in foreground mode if it
+	// crashed, see SupervisorConfig. nil to disable it.
+	Supervisor *SupervisorConfig `json:"supervisor,omitempty" yaml:"supervisor,omitempty" toml:"supervisor,omitempty"`
+
+	// InitMode tells how to act as PID 1 in a container, see
+	// InitMode. By default, it is enabled if running as PID 1.
+	InitMode InitMode `json:"init_mode,omitempty" yaml:"init_mode,omitempty" toml:"init_mode,omitempty"`
+
+	// SignalActions overrides the actions of the signals caught in
+	// service mode, keyed by signal name such as "HUP" or "SIGUSR1".
+	// By default, TERM/INT/QUIT quit, HUP reloads and USR1/USR2
+	// notify the entity, see SignalAction.
+	SignalActions map[string]SignalAction `json:"signal_actions,omitempty" yaml:"signal_actions,omitempty" toml:"signal_actions,omitempty"`
+
+	// Health configures the probes and the health monitor, see
+	// HealthConfig. nil to disable the built-in probes.
+	Health *HealthConfig `json:"health,omitempty" yaml:"health,omitempty" toml:"health,omitempty"`
+
+	// ControlSocket is the unix socket served by the running service
+	// for Control and SendControl, default <RunDir>/<Name>.sock. "-"
+	// disables it.
+	ControlSocket string `json:"control_socket,omitempty" yaml:"control_socket,omitempty" toml:"control_socket,omitempty"`
+	// ControlUsers are the users (names or uids) allowed to talk to
+	// the control socket, besides root, the service user and the
+	// members of Group.
+	ControlUsers []string `json:"control_users,omitempty" yaml:"control_users,omitempty" toml:"control_users,omitempty"`
+
+	// Metrics exposes the metrics of the running service in the
+	// Prometheus text format, see MetricsConfig. The "metrics" command
+	// of the control socket serves them even if it is nil.
+	Metrics *MetricsConfig `json:"metrics,omitempty" yaml:"metrics,omitempty" toml:"metrics,omitempty"`
+
+	// AuditLog records the control operations as JSON lines, default
+	// <LogDir>/<name>-audit.jsonl. "syslog" sends them to the AUTH
+	// facility of syslog, "-" disables it. See ReadAuditLog.
+	AuditLog string `json:"audit_log,omitempty" yaml:"audit_log,omitempty" toml:"audit_log,omitempty"`
+
+	// EnvFile is the environment file of the service, by default it
+	// is /etc/default/<name> or /etc/sysconfig/<name> depending on the
+	// distro, see Config.EnvFilePath.
+	EnvFile string `json:"env_file,omitempty" yaml:"env_file,omitempty" toml:"env_file,omitempty"`
+	// EnvApply is HotReload or Restart to apply the changes of the
+	// env file by EnvSet and EnvUnset. Zero to do nothing.
+	EnvApply Command `json:"env_apply,omitempty" yaml:"env_apply,omitempty" toml:"env_apply,omitempty"`
+
+	// BackupDir keeps the versioned backups of the installation, by
+	// default /var/backups/<name>, see Config.BackupDirPath.
+	BackupDir string `json:"backup_dir,omitempty" yaml:"backup_dir,omitempty" toml:"backup_dir,omitempty"`
+
+	Type string `json:"type,omitempty" yaml:"type,omitempty" toml:"type,omitempty"` // for systemd: simple, forking, exec, oneshot, dbus, notify, idle
+
+	ForceReinstall     bool   `json:"force_reinstall,omitempty" yaml:"force_reinstall,omitempty" toml:"force_reinstall,omitempty"`
+	AutoEnable         bool   `json:"auto_enable,omitempty" yaml:"auto_enable,omitempty" toml:"auto_enable,omitempty"`
+	DelayedAutoStart   bool   `json:"delayed_auto_start,omitempty" yaml:"delayed_auto_start,omitempty" toml:"delayed_auto_start,omitempty"`
+	UserLevel          bool   `json:"user_level,omitempty" yaml:"user_level,omitempty" toml:"user_level,omitempty"`
+	VendorDomainPrefix string `json:"vendor_domain_prefix,omitempty" yaml:"vendor_domain_prefix,omitempty" toml:"vendor_domain_prefix,omitempty"`
+
+	CmdLines    []string `json:"-" yaml:"-" toml:"-"`
+	TemplateDir string   `json:"template_dir,omitempty" yaml:"template_dir,omitempty" toml:"template_dir,omitempty"`
+	RunDir      string   `json:"run_dir,omitempty" yaml:"run_dir,omitempty" toml:"run_dir,omitempty"`
+	LogDir      string   `json:"log_dir,omitempty" yaml:"log_dir,omitempty" toml:"log_dir,omitempty"`
+	TempDir     string   `json:"temp_dir,omitempty" yaml:"temp_dir,omitempty" toml:"temp_dir,omitempty"`
+
+	// StateDir is the state directory of the service, default
+	// /var/lib/<name>. The missing WorkDir, StateDir, LogDir and RunDir
+	// are created at installing, owned by User and Group, see
+	// Config.ServiceDirs.
+	StateDir string `json:"state_dir,omitempty" yaml:"state_dir,omitempty" toml:"state_dir,omitempty"`
+	// SysUsers declares the missing User and Group by a sysusers.d(5)
+	// snippet if systemd-sysusers is found, instead of useradd.
+	SysUsers bool `json:"sys_users,omitempty" yaml:"sys_users,omitempty" toml:"sys_users,omitempty"`
+
+	RetCode int `json:"-" yaml:"-" toml:"-"` // return error code to main func if non-zero
 
 	// Translate allows formatting msg with your own translator.
-	Translate func(string) string
+	Translate func(string) string `json:"-" yaml:"-" toml:"-"`
 
-	ExecStartArgs  string
-	ExecStopArgs   string
-	PositionalArgs []string
+	ExecStartArgs  string   `json:"exec_start_args,omitempty" yaml:"exec_start_args,omitempty" toml:"exec_start_args,omitempty"`
+	ExecStopArgs   string   `json:"exec_stop_args,omitempty" yaml:"exec_stop_args,omitempty" toml:"exec_stop_args,omitempty"`
+	PositionalArgs []string `json:"-" yaml:"-" toml:"-"`
 }
 
 type Chooser interface {
@@ -195,21 +275,29 @@ func (c Command) String() string {
 }
 
 var mCommandStrings = map[Command]string{
-	MinCommand: "MIN",
-	Info:       "Info",
-	Port:       "Port",
-	Addr:       "Addr",
-	Start:      "Start",
-	Stop:       "Stop",
-	Status:     "Status",
-	Restart:    "Restart",
-	HotReload:  "HotReload",
-	Install:    "Install",
-	Uninstall:  "Uninstall",
-	Enable:     "Enable",
-	Disable:    "Disable",
-	ViewLog:    "ViewLog",
-	MaxCommand: "MAX",
+	MinCommand:  "MIN",
+	Info:        "Info",
+	Port:        "Port",
+	Addr:        "Addr",
+	Start:       "Start",
+	Stop:        "Stop",
+	Status:      "Status",
+	Restart:     "Restart",
+	HotReload:   "HotReload",
+	Install:     "Install",
+	Uninstall:   "Uninstall",
+	Enable:      "Enable",
+	Disable:     "Disable",
+	ViewLog:     "ViewLog",
+	Health:      "Health",
+	EnvGet:      "EnvGet",
+	EnvSet:      "EnvSet",
+	EnvUnset:    "EnvUnset",
+	EnvList:     "EnvList",
+	Restore:     "Restore",
+	ListBackups: "ListBackups",
+	AuditLog:    "AuditLog",
+	MaxCommand:  "MAX",
 }
 
 const (
@@ -235,6 +323,27 @@ const (
 	// ViewLog to show system log about this service
 	ViewLog
 
+	// Health checks the health of the service, see CheckHealth.
+	Health
+
+	// EnvGet, EnvSet, EnvUnset and EnvList manage the environment
+	// file of the service, see EnvFile. The keys are taken from
+	// Config.PositionalArgs.
+	EnvGet
+	EnvSet
+	EnvUnset
+	EnvList
+
+	// Restore brings back a backup of the installation archived by
+	// Uninstall or a forced Install, the version is taken from
+	// Config.PositionalArgs. ListBackups lists the backups.
+	Restore
+	ListBackups
+
+	// AuditLog lists the recent operations of the service in the audit
+	// log, the number is taken from Config.PositionalArgs.
+	AuditLog
+
 	MaxCommand
 )
 
@@ -0,0 +1,153 @@
+package service
+
+import (
+	"context"
+	"os"
+	"os/signal"
+	"sort"
+	"syscall"
+
+	"github.com/hedzr/cmdr-addons/v2/tool/dbglog"
+)
+
+// EntitySignalAware is implemented by the entity which handles the
+// signals mapped to SignalNotify, such as reopening its log files on
+// SIGUSR1 or dumping its state on SIGUSR2.
+type EntitySignalAware interface {
+	Signal(ctx context.Context, config *Config, logger Logger, sig os.Signal) (err error)
+}
+
+// SignalAction tells what to do when a signal is caught in service
+// mode.
+type SignalAction string
+
+const (
+	SignalQuit   SignalAction = "quit"   // graceful shutdown
+	SignalReload SignalAction = "reload" // EntityHotReloadAware.HotReload
+	SignalNotify SignalAction = "notify" // EntitySignalAware.Signal
+	SignalIgnore SignalAction = "ignore" // swallow it
+
+	// SignalUpgrade starts the new binary with the listeners handed
+	// off, and quits once it is ready. No signal is mapped to it by
+	// default, map one (such as "USR2") to enable the zero-downtime
+	// upgrading, see Listen.
+	SignalUpgrade SignalAction = "upgrade"
+)
+
+// defaultSignalActions is the mapping used if Config.SignalActions
+// doesn't override. The signals unknown on this platform are skipped.
+var defaultSignalActions = map[string]SignalAction{
+	"TERM": SignalQuit,
+	"INT":  SignalQuit,
+	"QUIT": SignalQuit,
+	"HUP":  SignalReload,
+	"USR1": SignalNotify,
+	"USR2": SignalNotify,
+}
+
+// signalActions merges Config.SignalActions over the default mapping.
+func (e *Config) signalActions() (actions map[syscall.Signal]SignalAction) {
+	actions = make(map[syscall.Signal]SignalAction)
+	for name, action := range defaultSignalActions {
+		if sig, err := parseSignal(name); err == nil {
+			actions[sig] = action
+		}
+	}
+	for name, action := range e.SignalActions {
+		sig, err := parseSignal(name)
+		if err != nil {
+			dbglog.Warn("[signals] unknown signal in mapping", "signal", name, "err", err)
+			continue
+		}
+		actions[sig] = action
+	}
+	return
+}
+
+// signalsOf returns the signals mapped to action, in a stable order.
+func (e *Config) signalsOf(action SignalAction) (sigs []os.Signal) {
+	for sig, a := range e.signalActions() {
+		if a == action {
+			sigs = append(sigs, sig)
+		}
+	}
+	sort.Slice(sigs, func(i, j int) bool { return sigs[i].(syscall.Signal) < sigs[j].(syscall.Signal) })
+	return
+}
+
+// reloadSignal returns the signal mapped to SignalReload, it is used
+// to trigger a hot-reload from outside, such as ExecReload=.
+func (e *Config) reloadSignal() syscall.Signal {
+	if sigs := e.signalsOf(SignalReload); len(sigs) > 0 {
+		return sigs[0].(syscall.Signal)
+	}
+	return sigHUP
+}
+
+// quitSignals returns the signals which stop the service loop.
+func (e *Config) quitSignals() (sigs []os.Signal) {
+	if sigs = e.signalsOf(SignalQuit); len(sigs) == 0 {
+		sigs = []os.Signal{syscall.SIGTERM, syscall.SIGINT}
+	}
+	return
+}
+
+// dispatchSignals routes the caught signals which are not mapped to
+// SignalQuit to the entity hooks, till the returned func is called.
+// The quit signals are left to the caller's loop.
+func (s *mgmtS) dispatchSignals(ctx context.Context, config *Config, logger Logger) (stop func()) {
+	actions := config.signalActions()
+	var sigs []os.Signal
+	for sig, action := range actions {
+		if action != SignalQuit {
+			sigs = append(sigs, sig)
+		}
+	}
+	if len(sigs) == 0 {
+		return func() {}
+	}
+
+	ch := make(chan os.Signal, 4)
+	signal.Notify(ch, sigs...)
+	ctx, cancel := context.WithCancel(ctx)
+	go func() {
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case sig := <-ch:
+				action := actions[sig.(syscall.Signal)]
+				dbglog.InfoContext(ctx, "[signals] signal caught", "sig", sig, "action", action)
+				if err := s.dispatchSignal(ctx, config, logger, sig, action); err != nil {
+					dbglog.ErrorContext(ctx, "[signals] signal handling failed", "sig", sig, "action", action, "err", err)
+				}
+			}
+		}
+	}()
+	return func() {
+		signal.Stop(ch)
+		cancel()
+	}
+}
+
+func (s *mgmtS) dispatchSignal(ctx context.Context, config *Config, logger Logger, sig os.Signal, action SignalAction) (err error) {
+	switch action {
+	case SignalReload:
+		if _, ok := config.Entity.(EntityHotReloadAware); ok {
+			return s.hotReload(ctx, config, logger)
+		}
+	case SignalNotify:
+		if fn, ok := config.Entity.(EntitySignalAware); ok {
+			return fn.Signal(ctx, config, logger, sig)
+		}
+	case SignalUpgrade:
+		return s.upgradeAndQuit(ctx, config, logger)
+	case SignalIgnore:
+		return
+	default:
+		dbglog.WarnContext(ctx, "[signals] unknown signal action", "sig", sig, "action", action)
+		return
+	}
+	dbglog.WarnContext(ctx, "[signals] the entity doesn't handle this signal", "sig", sig, "action", action)
+	return
+}
@@ -0,0 +1,69 @@
+//go:build !windows && !plan9 && !js
+// +build !windows,!plan9,!js
+
+package service
+
+import (
+	"context"
+	"os"
+	"syscall"
+	"testing"
+	"time"
+)
+
+type signalEntity struct {
+	shutdownEntity
+	caught chan os.Signal
+}
+
+func (e *signalEntity) Signal(ctx context.Context, config *Config, logger Logger, sig os.Signal) (err error) {
+	e.caught <- sig
+	return
+}
+
+func (e *signalEntity) HotReload(ctx context.Context, config *Config, logger Logger) (err error) {
+	e.caught <- syscall.SIGHUP
+	return
+}
+
+func TestConfig_signalActions(t *testing.T) {
+	config := &Config{SignalActions: map[string]SignalAction{
+		"SIGUSR2": SignalReload,
+		"hup":     SignalIgnore,
+		"BOGUS":   SignalQuit,
+	}}
+	actions := config.signalActions()
+	if actions[syscall.SIGTERM] != SignalQuit || actions[syscall.SIGUSR1] != SignalNotify || actions[syscall.SIGHUP] != SignalIgnore {
+		t.Fatalf("bad actions: %v", actions)
+	}
+	if sig := config.reloadSignal(); sig != syscall.SIGUSR2 {
+		t.Fatalf("expect SIGUSR2 for reloading, got %v", sig)
+	}
+	if sig := (&Config{}).reloadSignal(); sig != syscall.SIGHUP {
+		t.Fatalf("expect SIGHUP for reloading by default, got %v", sig)
+	}
+	if sigs := (&Config{}).quitSignals(); len(sigs) != 3 {
+		t.Fatalf("expect TERM/INT/QUIT, got %v", sigs)
+	}
+}
+
+func TestDispatchSignals(t *testing.T) {
+	entity := &signalEntity{caught: make(chan os.Signal, 4)}
+	config := &Config{Name: "demo", Entity: entity}
+	stop := (&mgmtS{}).dispatchSignals(context.Background(), config, nil)
+	defer stop()
+
+	for _, sig := range []syscall.Signal{syscall.SIGUSR1, syscall.SIGHUP} {
+		if err := syscall.Kill(os.Getpid(), sig); err != nil {
+			t.Fatal(err)
+		}
+		select {
+		case got := <-entity.caught:
+			if got != sig {
+				t.Fatalf("expect %v, got %v", sig, got)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("%v was not dispatched", sig)
+		}
+	}
+}
@@ -0,0 +1,28 @@
+//go:build windows || plan9 || js
+// +build windows plan9 js
+
+package service
+
+import (
+	"os"
+	"syscall"
+
+	"gopkg.in/hedzr/errors.v3"
+)
+
+// sendSignal only supports SIGKILL, which terminates the process.
+func sendSignal(pid int, sig syscall.Signal) (err error) {
+	if sig != syscall.SIGKILL {
+		return errors.New("sending %v to a process is not supported", signalName(sig))
+	}
+
+	var proc *os.Process
+	if proc, err = os.FindProcess(pid); err != nil {
+		return
+	}
+	defer proc.Release()
+	return proc.Kill()
+}
+
+// quitSelf is not supported, a process can't signal itself here.
+func quitSelf(config *Config) error { return errors.Unavailable }
@@ -0,0 +1,46 @@
+//go:build !windows && !plan9 && !js
+// +build !windows,!plan9,!js
+
+package service
+
+import (
+	"os"
+	"strconv"
+	"syscall"
+
+	"gopkg.in/hedzr/errors.v3"
+)
+
+func init() {
+	for name, sig := range map[string]syscall.Signal{
+		"USR1":  syscall.SIGUSR1,
+		"USR2":  syscall.SIGUSR2,
+		"ABRT":  syscall.SIGABRT,
+		"ALRM":  syscall.SIGALRM,
+		"CHLD":  syscall.SIGCHLD,
+		"CONT":  syscall.SIGCONT,
+		"STOP":  syscall.SIGSTOP,
+		"WINCH": syscall.SIGWINCH,
+	} {
+		signalsByName[name] = sig
+	}
+}
+
+// sendSignal sends sig to pid, retry by the elevator if the target
+// process belongs to another user.
+func sendSignal(pid int, sig syscall.Signal) (err error) {
+	if err = syscall.Kill(pid, sig); err != syscall.EPERM {
+		return
+	}
+
+	if err = elevateE("kill", "-"+strconv.Itoa(int(sig)), strconv.Itoa(pid)); err != nil {
+		err = errors.New("failed to send %v to %d", signalName(sig), pid).WithErrors(err)
+	}
+	return
+}
+
+// quitSelf starts the graceful shutdown of the current process by
+// sending itself the first quit signal.
+func quitSelf(config *Config) error {
+	return syscall.Kill(os.Getpid(), config.quitSignals()[0].(syscall.Signal))
+}
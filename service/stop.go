@@ -0,0 +1,290 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"gopkg.in/hedzr/errors.v3"
+
+	"github.com/hedzr/cmdr-addons/v2/tool/dbglog"
+)
+
+// StopOutcome tells how a stop request ended.
+type StopOutcome int
+
+const (
+	StopNotRunning   StopOutcome = iota // no process to stop
+	StopExited                          // exited after the stop signal
+	StopExitedOnLast                    // exited after the final stop signal
+	StopKilled                          // exited after SIGKILL
+	StopFailed                          // still alive, or signals could not be sent
+)
+
+func (o StopOutcome) String() string {
+	switch o {
+	case StopNotRunning:
+		return "not-running"
+	case StopExited:
+		return "exited"
+	case StopExitedOnLast:
+		return "exited-on-final-signal"
+	case StopKilled:
+		return "killed"
+	case StopFailed:
+		return "failed"
+	}
+	return fmt.Sprintf("StopOutcome{%d}", int(o))
+}
+
+// StopResult is the final report of StopProcess.
+type StopResult struct {
+	Pid     int
+	Outcome StopOutcome
+	Signals []syscall.Signal // the signals sent, in order
+	Elapsed time.Duration
+	Err     error
+}
+
+func (r StopResult) String() string {
+	var sigs []string
+	for _, sig := range r.Signals {
+		sigs = append(sigs, signalName(sig))
+	}
+	return fmt.Sprintf("pid %d %s after %v (signals: %s)", r.Pid, r.Outcome, r.Elapsed.Round(time.Millisecond), strings.Join(sigs, ","))
+}
+
+// Stopped reports whether the target process is gone.
+func (r StopResult) Stopped() bool { return r.Outcome != StopFailed }
+
+const (
+	defaultStopTimeout = 60 * time.Second
+	killWaitTimeout    = 5 * time.Second
+	stopPollInterval   = 100 * time.Millisecond
+)
+
+// StopProcess stops pid by escalating signals:
+//
+//  1. send Config.StopSignal (SIGTERM by default), and wait up to
+//     Config.TimeoutStopSec;
+//  2. if Config.FinalStopSignal is specified, send it and wait up to
+//     Config.TimeoutStopSec again;
+//  3. send SIGKILL, and wait a few seconds.
+//
+// Each step is logged to logger (if not nil) and dbglog. The final
+// outcome is reported as a StopResult, it never panics.
+func StopProcess(ctx context.Context, config *Config, pid int, logger Logger) (res StopResult) {
+	started := time.Now()
+	res.Pid = pid
+	defer func() { res.Elapsed = time.Since(started) }()
+
+	logf := func(format string, args ...any) {
+		msg := fmt.Sprintf(format, args...)
+		dbglog.InfoContext(ctx, "[stop] "+msg, "pid", pid, "service", config.ServiceName())
+		if logger != nil {
+			_ = logger.Infof("[stop] %s\n", msg)
+		}
+	}
+
+	if !processAlive(pid) {
+		res.Outcome = StopNotRunning
+		logf("process %d is not running", pid)
+		return
+	}
+
+	timeout := parseTimespan(config.TimeoutStopSec, defaultStopTimeout)
+	steps := []stopStep{{config.stopSignal(), timeout, StopExited}}
+	if sig, ok := config.finalStopSignal(); ok {
+		steps = append(steps, stopStep{sig, timeout, StopExitedOnLast})
+	}
+	steps = append(steps, stopStep{syscall.SIGKILL, killWaitTimeout, StopKilled})
+
+	for _, step := range steps {
+		logf("sending %s to %d, wait up to %v", signalName(step.sig), pid, step.timeout)
+		if err := sendSignal(pid, step.sig); err != nil {
+			if !processAlive(pid) {
+				res.Outcome = step.outcome
+				return
+			}
+			logf("sending %s to %d failed: %v", signalName(step.sig), pid, err)
+			res.Err = err
+			continue
+		}
+		res.Signals = append(res.Signals, step.sig)
+
+		if waitProcessExit(ctx, pid, step.timeout) {
+			res.Outcome, res.Err = step.outcome, nil
+			logf("process %d %s", pid, res.Outcome)
+			return
+		}
+		if ctx.Err() != nil {
+			break
+		}
+		logf("process %d is still alive after %v", pid, step.timeout)
+	}
+
+	res.Outcome = StopFailed
+	if res.Err == nil {
+		res.Err = errors.New("process %d is still alive after %d signal(s)", pid, len(res.Signals))
+	}
+	logf("stopping process %d failed: %v", pid, res.Err)
+	return
+}
+
+type stopStep struct {
+	sig     syscall.Signal
+	timeout time.Duration
+	outcome StopOutcome
+}
+
+// waitProcessExit polls pid till it exited, or timeout.
+func waitProcessExit(ctx context.Context, pid int, timeout time.Duration) (exited bool) {
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	ticker := time.NewTicker(stopPollInterval)
+	defer ticker.Stop()
+	for {
+		if !processAlive(pid) {
+			return true
+		}
+		select {
+		case <-ctx.Done():
+			return false
+		case <-timer.C:
+			return !processAlive(pid)
+		case <-ticker.C:
+		}
+	}
+}
+
+func (e *Config) stopSignal() syscall.Signal {
+	if sig, err := parseSignal(e.StopSignal); err == nil {
+		return sig
+	}
+	return syscall.SIGTERM
+}
+
+func (e *Config) finalStopSignal() (sig syscall.Signal, ok bool) {
+	if e.FinalStopSignal == "" {
+		return
+	}
+	var err error
+	sig, err = parseSignal(e.FinalStopSignal)
+	ok = err == nil
+	return
+}
+
+// parseSignal parses "TERM", "SIGTERM", "sigterm" or "15".
+func parseSignal(s string) (sig syscall.Signal, err error) {
+	s = strings.ToUpper(strings.TrimSpace(s))
+	if s == "" {
+		err = errors.New("empty signal name")
+		return
+	}
+	if n, e := strconv.Atoi(s); e == nil {
+		if n <= 0 {
+			err = errors.New("invalid signal number %d", n)
+			return
+		}
+		sig = syscall.Signal(n)
+		return
+	}
+	var ok bool
+	if sig, ok = signalsByName[strings.TrimPrefix(s, "SIG")]; !ok {
+		err = errors.New("unknown signal %q", s)
+	}
+	return
+}
+
+func signalName(sig syscall.Signal) string {
+	for name, v := range signalsByName {
+		if v == sig {
+			return "SIG" + name
+		}
+	}
+	return strconv.Itoa(int(sig))
+}
+
+// sigHUP is SIGHUP, which is not defined by the syscall package of
+// js/wasm.
+const sigHUP = syscall.Signal(0x1)
+
+// signalsByName holds the portable signals, the platform-specific
+// ones are appended by init() in signal_unix.go.
+var signalsByName = map[string]syscall.Signal{
+	"HUP":  sigHUP,
+	"INT":  syscall.SIGINT,
+	"QUIT": syscall.SIGQUIT,
+	"KILL": syscall.SIGKILL,
+	"TERM": syscall.SIGTERM,
+}
+
+// parseTimespan parses a systemd time span, such as "90", "90s",
+// "1min 30s", "500ms" or "infinity".
+//
+// An empty or invalid span returns def. A bare number means seconds.
+func parseTimespan(s string, def time.Duration) (d time.Duration) {
+	d, err := parseTimespanE(s)
+	if err != nil || d <= 0 {
+		return def
+	}
+	return
+}
+
+func parseTimespanE(s string) (d time.Duration, err error) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		err = errors.New("empty time span")
+		return
+	}
+	if s == "infinity" {
+		d = time.Duration(1<<63 - 1)
+		return
+	}
+	if n, e := strconv.ParseFloat(s, 64); e == nil {
+		d = time.Duration(n * float64(time.Second))
+		return
+	}
+
+	rest := s
+	for rest != "" {
+		rest = strings.TrimLeft(rest, " ")
+		i := 0
+		for i < len(rest) && (rest[i] >= '0' && rest[i] <= '9' || rest[i] == '.') {
+			i++
+		}
+		j := i
+		for j < len(rest) && rest[j] >= 'a' && rest[j] <= 'z' {
+			j++
+		}
+		if i == 0 || j == i {
+			err = errors.New("invalid time span %q", s)
+			return
+		}
+		var n float64
+		if n, err = strconv.ParseFloat(rest[:i], 64); err != nil {
+			return
+		}
+		unit, ok := timespanUnits[rest[i:j]]
+		if !ok {
+			err = errors.New("invalid time span %q, unknown unit %q", s, rest[i:j])
+			return
+		}
+		d += time.Duration(n * float64(unit))
+		rest = rest[j:]
+	}
+	return
+}
+
+var timespanUnits = map[string]time.Duration{
+	"us": time.Microsecond, "usec": time.Microsecond,
+	"ms": time.Millisecond, "msec": time.Millisecond,
+	"s": time.Second, "sec": time.Second, "second": time.Second, "seconds": time.Second,
+	"m": time.Minute, "min": time.Minute, "minute": time.Minute, "minutes": time.Minute,
+	"h": time.Hour, "hr": time.Hour, "hour": time.Hour, "hours": time.Hour,
+	"d": 24 * time.Hour, "day": 24 * time.Hour, "days": 24 * time.Hour,
+	"w": 7 * 24 * time.Hour, "week": 7 * 24 * time.Hour, "weeks": 7 * 24 * time.Hour,
+}
@@ -0,0 +1,89 @@
+//go:build linux
+// +build linux
+
+package service
+
+import (
+	"context"
+	"os/exec"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestStopProcess(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("exited on stop signal", func(t *testing.T) {
+		cmd := exec.Command("sleep", "30")
+		if err := cmd.Start(); err != nil {
+			t.Skip(err)
+		}
+		go func() { _ = cmd.Wait() }()
+
+		config := &Config{Name: "demo", TimeoutStopSec: "3s"}
+		res := StopProcess(ctx, config, cmd.Process.Pid, nil)
+		if res.Outcome != StopExited {
+			t.Fatalf("expect exited, got %v", res)
+		}
+		if len(res.Signals) != 1 || res.Signals[0] != syscall.SIGTERM {
+			t.Fatalf("expect SIGTERM sent, got %v", res.Signals)
+		}
+	})
+
+	t.Run("killed", func(t *testing.T) {
+		cmd := exec.Command("sh", "-c", `trap "" TERM QUIT; sleep 5`)
+		if err := cmd.Start(); err != nil {
+			t.Skip(err)
+		}
+		go func() { _ = cmd.Wait() }()
+		time.Sleep(100 * time.Millisecond) // wait for trap installed
+
+		config := &Config{Name: "demo", TimeoutStopSec: "300ms", FinalStopSignal: "QUIT"}
+		res := StopProcess(ctx, config, cmd.Process.Pid, nil)
+		if res.Outcome != StopKilled {
+			t.Fatalf("expect killed, got %v", res)
+		}
+		if len(res.Signals) != 3 || res.Signals[2] != syscall.SIGKILL {
+			t.Fatalf("expect TERM, QUIT, KILL sent, got %v", res.Signals)
+		}
+	})
+
+	t.Run("not running", func(t *testing.T) {
+		res := StopProcess(ctx, &Config{Name: "demo"}, 999999999, nil)
+		if res.Outcome != StopNotRunning {
+			t.Fatalf("expect not running, got %v", res)
+		}
+	})
+}
+
+func TestParseTimespan(t *testing.T) {
+	for _, c := range []struct {
+		in   string
+		want time.Duration
+	}{
+		{"", time.Minute},
+		{"90", 90 * time.Second},
+		{"90s", 90 * time.Second},
+		{"1min 30s", 90 * time.Second},
+		{"1m30s", 90 * time.Second},
+		{"500ms", 500 * time.Millisecond},
+		{"2h", 2 * time.Hour},
+		{"bad", time.Minute},
+	} {
+		if got := parseTimespan(c.in, time.Minute); got != c.want {
+			t.Fatalf("parseTimespan(%q) = %v, want %v", c.in, got, c.want)
+		}
+	}
+}
+
+func TestParseSignal(t *testing.T) {
+	for _, in := range []string{"TERM", "SIGTERM", "sigterm", "15"} {
+		if sig, err := parseSignal(in); err != nil || sig != syscall.SIGTERM {
+			t.Fatalf("parseSignal(%q) = %v, %v", in, sig, err)
+		}
+	}
+	if _, err := parseSignal("NOPE"); err == nil {
+		t.Fatal("expect an error for unknown signal")
+	}
+}
@@ -0,0 +1,16 @@
+package service
+
+import (
+	"syscall"
+)
+
+// prSetChildSubreaper is PR_SET_CHILD_SUBREAPER of prctl(2).
+const prSetChildSubreaper = 36
+
+// setSubreaper marks the current process as a child subreaper.
+func setSubreaper() (err error) {
+	if _, _, errno := syscall.RawSyscall(syscall.SYS_PRCTL, prSetChildSubreaper, 1, 0); errno != 0 {
+		err = errno
+	}
+	return
+}
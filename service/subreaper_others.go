@@ -0,0 +1,11 @@
+//go:build !linux && !windows && !plan9 && !js
+// +build !linux,!windows,!plan9,!js
+
+package service
+
+import (
+	"gopkg.in/hedzr/errors.v3"
+)
+
+// setSubreaper is only supported on linux.
+func setSubreaper() (err error) { return errors.Unavailable }
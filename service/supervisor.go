@@ -0,0 +1,371 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math/rand"
+	"os"
+	"runtime/debug"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/hedzr/is"
+	"gopkg.in/hedzr/errors.v3"
+
+	"github.com/hedzr/cmdr-addons/v2/tool/dbglog"
+)
+
+// RestartPolicy tells the supervisor when to restart the service
+// entity.
+type RestartPolicy string
+
+const (
+	RestartNever     RestartPolicy = "never"
+	RestartOnFailure RestartPolicy = "on-failure" // restart if Run returned an error or panicked
+	RestartAlways    RestartPolicy = "always"     // restart even if Run returned nil
+)
+
+// SupervisorConfig enables the in-process supervisor loop around
+// RunnableService.Run and EntityStartAware.Start in foreground mode.
+//
+// The supervisor expects Run/Start to block till the service ends,
+// and to return once its ctx is cancelled by a quit signal.
+// Only the panics raised in the calling goroutine can be recovered.
+//
+// It is useful in containers where nothing else restarts us.
+type SupervisorConfig struct {
+	Policy RestartPolicy `json:"policy,omitempty" yaml:"policy,omitempty" toml:"policy,omitempty"`
+
+	InitialBackoff time.Duration `json:"initial_backoff,omitempty" yaml:"initial_backoff,omitempty" toml:"initial_backoff,omitempty"` // the first delay before restarting, default 1s
+	MaxBackoff     time.Duration `json:"max_backoff,omitempty" yaml:"max_backoff,omitempty" toml:"max_backoff,omitempty"`             // the delay doubles on each restart up to MaxBackoff, default 1m
+	Jitter         float64       `json:"jitter,omitempty" yaml:"jitter,omitempty" toml:"jitter,omitempty"`                            // randomize the delay by ±Jitter, in [0, 1), zero disables it
+
+	// More than StartLimitBurst starts within StartLimitInterval
+	// cause the crash-loop state, the supervisor holds off for
+	// MaxBackoff before trying again. Default 5 starts in 1m.
+	StartLimitBurst    int           `json:"start_limit_burst,omitempty" yaml:"start_limit_burst,omitempty" toml:"start_limit_burst,omitempty"`
+	StartLimitInterval time.Duration `json:"start_limit_interval,omitempty" yaml:"start_limit_interval,omitempty" toml:"start_limit_interval,omitempty"`
+
+	// A run lasted longer than ResetAfter resets the backoff delay and
+	// clears the LastError of the status, default 1m.
+	ResetAfter time.Duration `json:"reset_after,omitempty" yaml:"reset_after,omitempty" toml:"reset_after,omitempty"`
+}
+
+// SupervisorState is the state of the supervised service entity.
+type SupervisorState string
+
+const (
+	SupervisorRunning   SupervisorState = "running"
+	SupervisorBackoff   SupervisorState = "backoff"    // waiting for restarting
+	SupervisorCrashLoop SupervisorState = "crash-loop" // start limit hit, holding off
+	SupervisorExited    SupervisorState = "exited"     // ended and won't be restarted
+	SupervisorStopped   SupervisorState = "stopped"    // stopped by request
+)
+
+// SupervisorStatus is the report of the supervisor. It is saved
+// beside the pidfile so that Status command can show it.
+type SupervisorStatus struct {
+	Pid         int             `json:"pid"`
+	State       SupervisorState `json:"state"`
+	Policy      RestartPolicy   `json:"policy"`
+	Restarts    int             `json:"restarts"`
+	LastError   string          `json:"last_error,omitempty"`
+	Since       time.Time       `json:"since"`
+	NextRestart time.Time       `json:"next_restart,omitempty"`
+}
+
+func (st SupervisorStatus) String() string {
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "supervisor: %s since %s (pid %d, policy %s, %d restart(s))",
+		st.State, st.Since.Format(time.RFC3339), st.Pid, st.Policy, st.Restarts)
+	if !st.NextRestart.IsZero() {
+		fmt.Fprintf(&sb, ", next restart at %s", st.NextRestart.Format(time.RFC3339))
+	}
+	if st.LastError != "" {
+		fmt.Fprintf(&sb, "\n  last error: %s", st.LastError)
+	}
+	return sb.String()
+}
+
+// ReadSupervisorStatus loads the supervisor report of the running
+// service.
+func ReadSupervisorStatus(config *Config) (st SupervisorStatus, err error) {
+	var data []byte
+	if data, err = os.ReadFile(supervisorStatusPath(config)); err != nil {
+		return
+	}
+	err = json.Unmarshal(data, &st)
+	return
+}
+
+func supervisorStatusPath(config *Config) string {
+	return strings.TrimSuffix(config.PidfilePath(), ".pid") + ".supervisor.json"
+}
+
+// printSupervisorStatus prints the supervisor report if the service
+// is running under supervisor.
+func printSupervisorStatus(config *Config) {
+	if st, err := ReadSupervisorStatus(config); err == nil && processAlive(st.Pid) {
+		fmt.Println(st.String())
+	}
+}
+
+// ErrCrashLoop is the error recorded when the start limit was hit.
+var ErrCrashLoop = errors.New("service is in crash-loop")
+
+// supervised tells if the service entity runs under the supervisor
+// loop.
+func supervised(config *Config) bool {
+	return config.Supervisor != nil && config.Supervisor.Policy != RestartNever && config.Supervisor.Policy != ""
+}
+
+// supervise runs fn once, or under the supervisor loop if
+// Config.Supervisor is specified.
+func (s *mgmtS) supervise(ctx context.Context, config *Config, logger Logger, fn func(ctx context.Context) error) (err error) {
+	if !supervised(config) {
+		return fn(ctx)
+	}
+	sv := newSupervisor(config, logger)
+	return sv.Run(ctx, fn)
+}
+
+// serveEntity runs the service entity by fn in service mode. The serve
+// loop and the quit signal catcher are up before fn is called, and a
+// quit signal cancels the ctx passed to fn.
+//
+// Under the supervisor fn blocks till the service ended, or else fn
+// returns once the entity started and we wait for a quit signal. The
+// shutdown pipeline runs at last in both cases. fn may be nil.
+func (s *mgmtS) serveEntity(ctx context.Context, config *Config, logger Logger, fn func(ctx context.Context) error) (err error) {
+	runCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	pid, ppid := os.Getpid(), os.Getppid()
+
+	defer s.serveLoop(ctx, config, logger)()
+	catcher := is.Signals().Catch(config.quitSignals()...)
+	catcher.WithOnSignalCaught(func(ctx context.Context, sig os.Signal, wgShutdown *sync.WaitGroup) {
+		dbglog.InfoContext(ctx, "signal caught", "sig", sig, "service", config.ServiceName())
+		cancel()
+	}).WaitFor(ctx, func(ctx context.Context, closer func()) {
+		defer closer()
+		dbglog.InfoContext(ctx, "entering loop", "service", config.ServiceName(), "pid", pid, "ppid", ppid)
+		if fn != nil {
+			err = s.supervise(runCtx, config, logger, fn)
+		}
+		if err == nil && !supervised(config) {
+			<-runCtx.Done()
+		}
+		cancel()
+
+		// we are being stopped, don't ask the init system again.
+		dbglog.InfoContext(ctx, "stop service", "service", config.ServiceName(), "pid", pid, "ppid", ppid)
+		report := s.shutdown(ctx, config, logger)
+		if e := report.Err(); e != nil {
+			dbglog.ErrorContext(ctx, "stop service failed", "service", config.ServiceName(), "pid", pid, "ppid", ppid, "err", e)
+			if err == nil {
+				err = e
+			}
+		}
+	})
+	return
+}
+
+func newSupervisor(config *Config, logger Logger) *supervisor {
+	opts := *config.Supervisor
+	if opts.InitialBackoff <= 0 {
+		opts.InitialBackoff = time.Second
+	}
+	if opts.MaxBackoff < opts.InitialBackoff {
+		opts.MaxBackoff = max(time.Minute, opts.InitialBackoff)
+	}
+	if opts.Jitter < 0 || opts.Jitter >= 1 {
+		opts.Jitter = 0.2 // out of range, fall back to a moderate one
+	}
+	if opts.StartLimitBurst <= 0 {
+		opts.StartLimitBurst = 5
+	}
+	if opts.StartLimitInterval <= 0 {
+		opts.StartLimitInterval = time.Minute
+	}
+	if opts.ResetAfter <= 0 {
+		opts.ResetAfter = time.Minute
+	}
+	return &supervisor{
+		config: config,
+		opts:   opts,
+		logger: logger,
+		rnd:    rand.New(rand.NewSource(time.Now().UnixNano())),
+		status: SupervisorStatus{Pid: os.Getpid(), Policy: opts.Policy},
+	}
+}
+
+type supervisor struct {
+	config *Config
+	opts   SupervisorConfig
+	logger Logger
+	rnd    *rand.Rand
+
+	mu     sync.Mutex
+	status SupervisorStatus
+	starts []time.Time
+}
+
+// Status returns a snapshot of the supervisor report.
+func (sv *supervisor) Status() SupervisorStatus {
+	sv.mu.Lock()
+	defer sv.mu.Unlock()
+	return sv.status
+}
+
+func (sv *supervisor) Run(ctx context.Context, fn func(ctx context.Context) error) (err error) {
+	defer func() { _ = os.Remove(supervisorStatusPath(sv.config)) }()
+
+	backoff := sv.opts.InitialBackoff
+	for {
+		if sv.startLimitHit(time.Now()) {
+			sv.logf(ctx, "start limit hit (%d starts within %v), hold off %v", sv.opts.StartLimitBurst, sv.opts.StartLimitInterval, sv.opts.MaxBackoff)
+			sv.setState(SupervisorCrashLoop, ErrCrashLoop, sv.opts.MaxBackoff)
+			if !sleepCtx(ctx, sv.opts.MaxBackoff) {
+				sv.setState(SupervisorStopped, nil, 0)
+				return
+			}
+			continue
+		}
+
+		sv.setState(SupervisorRunning, nil, 0)
+		started := time.Now()
+		sv.starts = append(sv.starts, started)
+		// the entity staying up past ResetAfter has recovered.
+		recovered := time.AfterFunc(sv.opts.ResetAfter, sv.clearLastError)
+		err = sv.runOnce(ctx, fn)
+		recovered.Stop()
+
+		if ctx.Err() != nil {
+			sv.setState(SupervisorStopped, err, 0)
+			return
+		}
+		if !sv.shouldRestart(err) {
+			sv.setState(SupervisorExited, err, 0)
+			return
+		}
+
+		if time.Since(started) >= sv.opts.ResetAfter {
+			backoff = sv.opts.InitialBackoff
+		}
+		delay := sv.jitter(backoff)
+		backoff = min(backoff*2, sv.opts.MaxBackoff)
+
+		sv.logf(ctx, "service ended (err: %v), restart in %v", err, delay)
+		sv.setState(SupervisorBackoff, err, delay)
+		if !sleepCtx(ctx, delay) {
+			sv.setState(SupervisorStopped, err, 0)
+			return
+		}
+		sv.mu.Lock()
+		sv.status.Restarts++
+		sv.mu.Unlock()
+	}
+}
+
+// runOnce calls fn and converts a panic into an error, the stack
+// trace is logged.
+func (sv *supervisor) runOnce(ctx context.Context, fn func(ctx context.Context) error) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			stack := debug.Stack()
+			err = errors.New("service panicked: %v", r)
+			dbglog.ErrorContext(ctx, "[supervisor] service panicked", "panic", r, "stack", string(stack))
+			if sv.logger != nil {
+				_ = sv.logger.Errorf("[supervisor] service panicked: %v\n%s", r, stack)
+			}
+		}
+	}()
+	return fn(ctx)
+}
+
+func (sv *supervisor) shouldRestart(err error) bool {
+	switch sv.opts.Policy {
+	case RestartAlways:
+		return true
+	case RestartOnFailure:
+		return err != nil
+	}
+	return false
+}
+
+// startLimitHit reports whether the starts within the last
+// StartLimitInterval reached StartLimitBurst.
+func (sv *supervisor) startLimitHit(now time.Time) bool {
+	i := 0
+	for i < len(sv.starts) && now.Sub(sv.starts[i]) > sv.opts.StartLimitInterval {
+		i++
+	}
+	sv.starts = sv.starts[i:]
+	return len(sv.starts) >= sv.opts.StartLimitBurst
+}
+
+func (sv *supervisor) jitter(d time.Duration) time.Duration {
+	if sv.opts.Jitter == 0 {
+		return d
+	}
+	f := 1 + sv.opts.Jitter*(2*sv.rnd.Float64()-1)
+	return time.Duration(float64(d) * f)
+}
+
+func (sv *supervisor) setState(state SupervisorState, err error, delay time.Duration) {
+	sv.mu.Lock()
+	sv.status.State, sv.status.Since = state, time.Now()
+	sv.status.NextRestart = time.Time{}
+	if delay > 0 {
+		sv.status.NextRestart = sv.status.Since.Add(delay)
+	}
+	if err != nil {
+		sv.status.LastError = err.Error()
+	}
+	sv.save()
+	sv.mu.Unlock()
+}
+
+// clearLastError forgets the error of the previous run, once the
+// entity has recovered.
+func (sv *supervisor) clearLastError() {
+	sv.mu.Lock()
+	defer sv.mu.Unlock()
+	if sv.status.LastError != "" {
+		sv.status.LastError = ""
+		sv.save()
+	}
+}
+
+// save writes the status file, sv.mu must be held so the writes keep
+// the order of the changes.
+func (sv *supervisor) save() {
+	if data, e := json.Marshal(sv.status); e == nil {
+		if e = writeFileAtomic(supervisorStatusPath(sv.config), data, 0o644); e != nil {
+			dbglog.Debug("[supervisor] cannot save status", "err", e)
+		}
+	}
+}
+
+func (sv *supervisor) logf(ctx context.Context, format string, args ...any) {
+	msg := fmt.Sprintf(format, args...)
+	dbglog.WarnContext(ctx, "[supervisor] "+msg, "service", sv.config.ServiceName())
+	if sv.logger != nil {
+		_ = sv.logger.Warnf("[supervisor] %s\n", msg)
+	}
+}
+
+// sleepCtx sleeps d, it returns false if ctx was cancelled.
+func sleepCtx(ctx context.Context, d time.Duration) bool {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return false
+	case <-timer.C:
+		return true
+	}
+}
@@ -0,0 +1,128 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"path"
+	"testing"
+	"time"
+)
+
+func newTestSupervisor(t *testing.T, opts SupervisorConfig) *supervisor {
+	config := &Config{Name: "demo", PIDFile: path.Join(t.TempDir(), "demo.pid"), Supervisor: &opts}
+	return newSupervisor(config, nil)
+}
+
+func TestSupervisor_onFailure(t *testing.T) {
+	sv := newTestSupervisor(t, SupervisorConfig{
+		Policy:         RestartOnFailure,
+		InitialBackoff: time.Millisecond,
+		MaxBackoff:     4 * time.Millisecond,
+	})
+
+	runs := 0
+	err := sv.Run(context.Background(), func(ctx context.Context) error {
+		runs++
+		switch runs {
+		case 1:
+			return errors.New("failed")
+		case 2:
+			panic("boom")
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("expect the third run succeeded, got %v", err)
+	}
+	if runs != 3 {
+		t.Fatalf("expect 3 runs, got %d", runs)
+	}
+	if st := sv.Status(); st.State != SupervisorExited || st.Restarts != 2 {
+		t.Fatalf("bad status: %+v", st)
+	}
+}
+
+func TestSupervisor_never(t *testing.T) {
+	config := &Config{Name: "demo", Supervisor: &SupervisorConfig{Policy: RestartNever}}
+	runs := 0
+	err := (&mgmtS{}).supervise(context.Background(), config, nil, func(ctx context.Context) error {
+		runs++
+		return errors.New("failed")
+	})
+	if err == nil || runs != 1 {
+		t.Fatalf("expect one failed run, got runs=%d err=%v", runs, err)
+	}
+}
+
+func TestSupervisor_crashLoop(t *testing.T) {
+	sv := newTestSupervisor(t, SupervisorConfig{
+		Policy:             RestartAlways,
+		InitialBackoff:     time.Millisecond,
+		MaxBackoff:         time.Hour,
+		StartLimitBurst:    3,
+		StartLimitInterval: time.Hour,
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		_ = sv.Run(ctx, func(ctx context.Context) error { return nil })
+	}()
+
+	// the saved status is updated after the in-memory one
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		if st, err := ReadSupervisorStatus(sv.config); err == nil && st.State == SupervisorCrashLoop {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("expect crash-loop, got %+v", sv.Status())
+		}
+		time.Sleep(time.Millisecond)
+	}
+	if st := sv.Status(); st.State != SupervisorCrashLoop || st.Restarts != 3 {
+		t.Fatalf("expect crash-loop after 3 restarts, got %+v", st)
+	}
+
+	cancel()
+	<-done
+	if st := sv.Status(); st.State != SupervisorStopped {
+		t.Fatalf("expect stopped, got %+v", st)
+	}
+}
+
+func TestSupervisor_jitter(t *testing.T) {
+	sv := newTestSupervisor(t, SupervisorConfig{Policy: RestartAlways, Jitter: 0.5})
+	for i := 0; i < 100; i++ {
+		if d := sv.jitter(time.Second); d < 500*time.Millisecond || d > 1500*time.Millisecond {
+			t.Fatalf("jitter out of range: %v", d)
+		}
+	}
+}
+
+func TestSupervisor_recovered(t *testing.T) {
+	sv := newTestSupervisor(t, SupervisorConfig{
+		Policy:         RestartOnFailure,
+		InitialBackoff: time.Millisecond,
+		ResetAfter:     20 * time.Millisecond,
+	})
+
+	runs := 0
+	err := sv.Run(context.Background(), func(ctx context.Context) error {
+		runs++
+		if runs == 1 {
+			return errors.New("failed")
+		}
+		// stays up past ResetAfter
+		time.Sleep(100 * time.Millisecond)
+		if st := sv.Status(); st.LastError != "" {
+			return errors.New("last error is kept after recovering: " + st.LastError)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,88 @@
+package service
+
+import (
+	"context"
+	"os"
+	"strconv"
+	"syscall"
+	"time"
+
+	"gopkg.in/hedzr/errors.v3"
+
+	"github.com/hedzr/cmdr-addons/v2/tool/dbglog"
+)
+
+// The environment variables passed to the new process at upgrading.
+const (
+	listenersEnv   = "CMDR_SERVICE_LISTENERS"    // JSON array of "network://address", their fds start at listenersFd
+	readyFdEnv     = "CMDR_SERVICE_READY_FD"     // the pipe to report the readiness
+	upgradeFromEnv = "CMDR_SERVICE_UPGRADE_FROM" // the pid of the old process
+)
+
+const (
+	readyFd     = 3 // ExtraFiles[0]
+	listenersFd = 4 // ExtraFiles[1:]
+
+	defaultStartTimeout = 90 * time.Second
+)
+
+// Ready reports the service is ready to serve, to the old process at
+// upgrading and to systemd (READY=1).
+//
+// It is called automatically when the service enters its loop in
+// service mode. An entity whose Run blocks should call it after its
+// listeners are serving. Only the first call takes effect.
+func Ready() { notifyReady() }
+
+// upgradingFrom returns the pid of the old process if we were started
+// by an upgrading.
+func upgradingFrom() (pid int) {
+	pid, _ = strconv.Atoi(os.Getenv(upgradeFromEnv))
+	return
+}
+
+// upgradeSignal returns the signal mapped to SignalUpgrade.
+func (e *Config) upgradeSignal() (sig syscall.Signal, ok bool) {
+	if sigs := e.signalsOf(SignalUpgrade); len(sigs) > 0 {
+		return sigs[0].(syscall.Signal), true
+	}
+	return
+}
+
+// canUpgrade reports whether a restart should be done as a
+// zero-downtime upgrade: the entity implements EntityPortAware and a
+// signal is mapped to SignalUpgrade.
+func (e *Config) canUpgrade() (sig syscall.Signal, ok bool) {
+	if _, ok = e.Entity.(EntityPortAware); !ok {
+		return
+	}
+	return e.upgradeSignal()
+}
+
+// upgradeService asks the running service pid to upgrade itself with
+// sig, and waits till mainPid reports a new process, bounded by
+// TimeoutStartSec.
+func upgradeService(ctx context.Context, config *Config, pid int, sig syscall.Signal, mainPid func() int) (err error) {
+	if pid <= 0 || !processAlive(pid) {
+		return ErrServiceIsNotRunning
+	}
+	if err = sendSignal(pid, sig); err != nil {
+		return
+	}
+	println(signalName(sig), "sent to", pid, "for upgrading")
+
+	timeout := parseTimespan(config.TimeoutStartSec, defaultStartTimeout)
+	deadline := time.Now().Add(timeout)
+	for time.Now().Before(deadline) {
+		if np := mainPid(); np > 0 && np != pid {
+			println("upgraded, new pid:", np)
+			dbglog.InfoContext(ctx, "[upgrade] service upgraded", "old", pid, "new", np)
+			return
+		}
+		if !sleepCtx(ctx, stopPollInterval) {
+			return ctx.Err()
+		}
+	}
+	err = errors.New("service %d was not upgraded in %v, see its logs", pid, timeout)
+	return
+}
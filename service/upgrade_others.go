@@ -0,0 +1,23 @@
+//go:build windows || plan9 || js
+// +build windows plan9 js
+
+package service
+
+import (
+	"context"
+	"net"
+
+	"gopkg.in/hedzr/errors.v3"
+)
+
+// Listen is net.Listen, the listeners can't be handed off on this
+// platform.
+func Listen(network, address string) (ln net.Listener, err error) {
+	return net.Listen(network, address)
+}
+
+func notifyReady() {}
+
+func (s *mgmtS) upgradeAndQuit(ctx context.Context, config *Config, logger Logger) (err error) {
+	return errors.Unavailable
+}
@@ -0,0 +1,104 @@
+//go:build linux
+// +build linux
+
+package service
+
+import (
+	"context"
+	"io"
+	"net"
+	"os"
+	"testing"
+	"time"
+)
+
+type portEntity struct{ shutdownEntity }
+
+func (e *portEntity) Port(ctx context.Context, config *Config, logger Logger) (port int) { return -1 }
+
+const upgradeHelperEnv = "GO_WANT_UPGRADE_HELPER"
+
+// TestUpgradeHelperProcess is the new process started by TestUpgrade.
+func TestUpgradeHelperProcess(t *testing.T) {
+	if os.Getenv(upgradeHelperEnv) != "1" {
+		t.Skip("helper process")
+	}
+	ln, err := Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		os.Exit(2)
+	}
+	Ready()
+	_ = ln.(*net.TCPListener).SetDeadline(time.Now().Add(5 * time.Second))
+	conn, err := ln.Accept()
+	if err != nil {
+		os.Exit(3)
+	}
+	_, _ = conn.Write([]byte("new"))
+	_ = conn.Close()
+	os.Exit(0)
+}
+
+func TestUpgrade(t *testing.T) {
+	ln, err := Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	t.Setenv(upgradeHelperEnv, "1")
+	args := os.Args
+	os.Args = []string{args[0], "-test.run=^TestUpgradeHelperProcess$"}
+	defer func() { os.Args = args }()
+
+	config := &Config{Name: "demo", Executable: args[0], Entity: &portEntity{}, TimeoutStartSec: "10s"}
+	pid, err := (&mgmtS{}).upgrade(context.Background(), config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pid <= 0 || pid == os.Getpid() {
+		t.Fatalf("bad new pid %d", pid)
+	}
+
+	// the new process accepts on the same socket
+	conn, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	_ = conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	data, err := io.ReadAll(conn)
+	if err != nil || string(data) != "new" {
+		t.Fatalf("expect the new process served, got %q, err: %v", data, err)
+	}
+}
+
+func TestUpgrade_failed(t *testing.T) {
+	config := &Config{Name: "demo", Executable: "/bin/false", Entity: &portEntity{}, TimeoutStartSec: "5s"}
+	if _, err := (&mgmtS{}).upgrade(context.Background(), config); err == nil {
+		t.Fatal("expect an error if the new process exited before ready")
+	}
+	config.Entity = &shutdownEntity{}
+	if _, err := (&mgmtS{}).upgrade(context.Background(), config); err == nil {
+		t.Fatal("expect an error without EntityPortAware")
+	}
+}
+
+func TestSdNotify(t *testing.T) {
+	sock := t.TempDir() + "/notify.sock"
+	conn, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: sock, Net: "unixgram"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	t.Setenv("NOTIFY_SOCKET", sock)
+	if err = sdNotify("MAINPID=123"); err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, 64)
+	_ = conn.SetReadDeadline(time.Now().Add(time.Second))
+	n, err := conn.Read(buf)
+	if err != nil || string(buf[:n]) != "MAINPID=123" {
+		t.Fatalf("bad notification %q, err: %v", buf[:n], err)
+	}
+}
@@ -0,0 +1,245 @@
+//go:build !windows && !plan9 && !js
+// +build !windows,!plan9,!js
+
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"gopkg.in/hedzr/errors.v3"
+
+	"github.com/hedzr/cmdr-addons/v2/tool/dbglog"
+)
+
+// Listen announces on the local network address like net.Listen.
+//
+// If the process was started by an upgrading, the listener inherited
+// from the old process for the same network and address is returned
+// instead, so that no connection is refused during the upgrading.
+// The listeners got from Listen are handed off to the new process at
+// upgrading.
+func Listen(network, address string) (ln net.Listener, err error) {
+	key := network + "://" + address
+	handoff.Lock()
+	defer handoff.Unlock()
+
+	handoff.loadInherited()
+	if f, ok := handoff.inherited[key]; ok {
+		delete(handoff.inherited, key)
+		ln, err = net.FileListener(f)
+		_ = f.Close()
+		if err != nil {
+			err = errors.New("cannot use the inherited listener %q", key).WithErrors(err)
+			return
+		}
+		dbglog.Info("[upgrade] listener inherited", "addr", key)
+	} else if ln, err = net.Listen(network, address); err != nil {
+		return
+	}
+	handoff.listeners = append(handoff.listeners, handoffListener{key, ln})
+	return
+}
+
+var handoff handoffS
+
+type handoffS struct {
+	sync.Mutex
+	loaded    bool
+	inherited map[string]*os.File
+	listeners []handoffListener
+}
+
+type handoffListener struct {
+	key string
+	ln  net.Listener
+}
+
+// loadInherited picks up the listeners passed by the old process.
+func (h *handoffS) loadInherited() {
+	if h.loaded {
+		return
+	}
+	h.loaded, h.inherited = true, make(map[string]*os.File)
+
+	var keys []string
+	if v := os.Getenv(listenersEnv); v != "" {
+		if err := json.Unmarshal([]byte(v), &keys); err != nil {
+			dbglog.Warn("[upgrade] bad inherited listeners", "env", listenersEnv, "value", v, "err", err)
+		}
+		_ = os.Unsetenv(listenersEnv)
+	}
+	for i, key := range keys {
+		fd := listenersFd + i
+		syscall.CloseOnExec(fd)
+		h.inherited[key] = os.NewFile(uintptr(fd), key)
+	}
+}
+
+// files duplicates the fds of the active listeners. The unix sockets
+// won't be unlinked when the old process closes them.
+func (h *handoffS) files() (keys []string, files []*os.File) {
+	h.Lock()
+	defer h.Unlock()
+	for _, l := range h.listeners {
+		fl, ok := l.ln.(interface{ File() (*os.File, error) })
+		if !ok {
+			continue
+		}
+		f, err := fl.File()
+		if err != nil {
+			// closed already
+			dbglog.Debug("[upgrade] skip listener", "addr", l.key, "err", err)
+			continue
+		}
+		if ul, ok := l.ln.(*net.UnixListener); ok {
+			ul.SetUnlinkOnClose(false)
+		}
+		keys, files = append(keys, l.key), append(files, f)
+	}
+	return
+}
+
+// closeUnused closes the inherited listeners which were not picked up
+// by Listen.
+func (h *handoffS) closeUnused() {
+	h.Lock()
+	defer h.Unlock()
+	for key, f := range h.inherited {
+		dbglog.Warn("[upgrade] inherited listener is unused, closed", "addr", key)
+		_ = f.Close()
+	}
+	h.inherited = nil
+}
+
+var readyOnce sync.Once
+
+func notifyReady() {
+	readyOnce.Do(func() {
+		if v := os.Getenv(readyFdEnv); v != "" {
+			_ = os.Unsetenv(readyFdEnv)
+			if fd, err := strconv.Atoi(v); err == nil {
+				f := os.NewFile(uintptr(fd), "ready")
+				_, _ = f.Write([]byte{'1'})
+				_ = f.Close()
+			}
+		}
+		handoff.closeUnused()
+		if err := sdNotify("READY=1"); err != nil {
+			dbglog.Warn("[upgrade] sd_notify failed", "err", err)
+		}
+	})
+}
+
+// upgrade starts the current executable as a child process, with the
+// listeners got from Listen handed off, and waits till it reported
+// ready, bounded by TimeoutStartSec.
+//
+// If the child failed, it is killed and the current process keeps
+// serving.
+func (s *mgmtS) upgrade(ctx context.Context, config *Config) (pid int, err error) {
+	if _, ok := config.Entity.(EntityPortAware); !ok {
+		err = errors.New("upgrading needs an entity implementing EntityPortAware")
+		return
+	}
+
+	keys, files := handoff.files()
+	defer func() {
+		for _, f := range files {
+			_ = f.Close()
+		}
+	}()
+	var addrs []byte
+	if addrs, err = json.Marshal(keys); err != nil {
+		return
+	}
+
+	var r, w *os.File
+	if r, w, err = os.Pipe(); err != nil {
+		return
+	}
+	defer r.Close()
+
+	cmd := exec.Command(config.ExecutablePath(), os.Args[1:]...)
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = os.Stdin, os.Stdout, os.Stderr
+	cmd.ExtraFiles = append([]*os.File{w}, files...)
+	for _, kv := range os.Environ() {
+		if !strings.HasPrefix(kv, listenersEnv+"=") && !strings.HasPrefix(kv, readyFdEnv+"=") && !strings.HasPrefix(kv, upgradeFromEnv+"=") {
+			cmd.Env = append(cmd.Env, kv)
+		}
+	}
+	cmd.Env = append(cmd.Env,
+		listenersEnv+"="+string(addrs),
+		fmt.Sprintf("%s=%d", readyFdEnv, readyFd),
+		fmt.Sprintf("%s=%d", upgradeFromEnv, os.Getpid()),
+	)
+
+	err = cmd.Start()
+	_ = w.Close()
+	if err != nil {
+		err = errors.New("cannot start the new process %q", cmd.Path).WithErrors(err)
+		return
+	}
+	pid = cmd.Process.Pid
+	dbglog.InfoContext(ctx, "[upgrade] new process started", "pid", pid, "listeners", keys)
+
+	ready, exited := make(chan bool, 1), make(chan error, 1)
+	go func() {
+		buf := make([]byte, 1)
+		n, _ := r.Read(buf)
+		ready <- n == 1
+	}()
+	go func() { exited <- cmd.Wait() }()
+
+	timeout := parseTimespan(config.TimeoutStartSec, defaultStartTimeout)
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	fail := func(e error) {
+		_ = cmd.Process.Kill()
+		err, pid = e, 0
+	}
+	select {
+	case ok := <-ready:
+		if !ok {
+			fail(errors.New("new process %d closed the readiness pipe before ready", pid))
+			return
+		}
+	case e := <-exited:
+		fail(errors.New("new process %d exited before ready", pid).WithErrors(e))
+		return
+	case <-timer.C:
+		fail(errors.New("new process %d is not ready in %v", pid, timeout))
+		return
+	case <-ctx.Done():
+		fail(ctx.Err())
+		return
+	}
+
+	if e := sdNotify(fmt.Sprintf("MAINPID=%d", pid)); e != nil {
+		dbglog.WarnContext(ctx, "[upgrade] sd_notify MAINPID failed", "pid", pid, "err", e)
+	}
+	dbglog.InfoContext(ctx, "[upgrade] new process is ready", "pid", pid)
+	return
+}
+
+// upgradeAndQuit upgrades the service, then stops the current process
+// through the graceful shutdown by sending a quit signal to itself.
+func (s *mgmtS) upgradeAndQuit(ctx context.Context, config *Config, logger Logger) (err error) {
+	var pid int
+	if pid, err = s.upgrade(ctx, config); err != nil {
+		return
+	}
+	if logger != nil {
+		_ = logger.Infof("[upgrade] handed off to %d, shutting down\n", pid)
+	}
+	return quitSelf(config)
+}
@@ -0,0 +1,331 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path"
+	"regexp"
+	"runtime"
+	"sort"
+	"strings"
+)
+
+// FieldError is a problem of a field of Config. Field is the path of
+// the key in the config file, such as "supervisor.max_backoff".
+type FieldError struct {
+	Field   string
+	Problem string
+}
+
+func (e FieldError) String() string { return e.Field + ": " + e.Problem }
+
+// ValidationError is returned by Config.Validate, it lists all the
+// problems found.
+type ValidationError struct {
+	Service  string
+	Problems []FieldError
+}
+
+func (e *ValidationError) Error() string {
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "invalid config of service %q, %d problem(s):", e.Service, len(e.Problems))
+	for _, p := range e.Problems {
+		sb.WriteString("\n  ")
+		sb.WriteString(p.String())
+	}
+	return sb.String()
+}
+
+// Validate checks the config before it is used to install or control
+// the service: the name, the paths, the durations, the user and the
+// group, the dependencies and the conflicting settings. All problems
+// are returned together as a *ValidationError.
+func (e *Config) Validate(ctx context.Context) error {
+	return e.validate(ctx, MinCommand, nativeBackend())
+}
+
+// nativeBackend returns the name of the usual backend on this OS, the
+// naming rules of Validate are of it.
+func nativeBackend() string {
+	switch runtime.GOOS {
+	case "linux":
+		return "systemd"
+	case "darwin":
+		return "launchd"
+	case "windows":
+		return "ntservice"
+	}
+	return runtime.GOOS
+}
+
+// validate is Validate before running cmd by backend, the name of the
+// backend chosen by Control, such as "systemd". For Install, the missing
+// user, group and WorkDir are not problems, they will be provisioned.
+// For Uninstall, Stop and Disable, the existence of the executable,
+// the user and the group is not checked, a service must be able to be
+// removed after they are gone.
+func (e *Config) validate(ctx context.Context, cmd Command, backend string) error {
+	v := &validator{}
+	provisioning := cmd == Install
+	checkExist := cmd != Uninstall && cmd != Stop && cmd != Disable
+
+	name := e.ServiceBareName()
+	if name == "" {
+		v.add("name", "is required")
+	} else if p := serviceNameProblem(backend, name); p != "" {
+		v.add("name", p)
+	}
+
+	// makeSafety fills the empty ones only, the specified but missing
+	// files are reported here.
+	if v.absPath("executable", e.Executable) && e.Executable != "" && checkExist {
+		if info, err := os.Stat(e.Executable); err != nil {
+			v.add("executable", fmt.Sprintf("%q does not exist", e.Executable))
+		} else if info.IsDir() || runtime.GOOS != "windows" && info.Mode().Perm()&0o111 == 0 {
+			v.add("executable", fmt.Sprintf("%q is not an executable file", e.Executable))
+		}
+	}
+	if v.absPath("work_dir", e.WorkDir) && e.WorkDir != "" && checkExist && !provisioning && !dirExists(e.WorkDir) {
+		v.add("work_dir", fmt.Sprintf("%q does not exist", e.WorkDir))
+	}
+	for _, f := range []struct{ field, value string }{
+		{"pid_file", e.PIDFile},
+		{"standard_out_path", e.StandardOutPath},
+		{"standard_error_path", e.StandardErrorPath},
+		{"env_file", e.EnvFile},
+		{"backup_dir", e.BackupDir},
+		{"template_dir", e.TemplateDir},
+		{"run_dir", e.RunDir},
+		{"log_dir", e.LogDir},
+		{"temp_dir", e.TempDir},
+		{"state_dir", e.StateDir},
+	} {
+		v.absPath(f.field, f.value)
+	}
+	if e.ControlSocket != "-" {
+		v.absPath("control_socket", e.ControlSocket)
+	}
+
+	v.timespan("timeout_start_sec", e.TimeoutStartSec)
+	v.timespan("timeout_stop_sec", e.TimeoutStopSec)
+	v.timespan("restart_sec", e.RestartSec)
+	v.signal("stop_signal", e.StopSignal)
+	v.signal("final_stop_signal", e.FinalStopSignal)
+	sigs := make([]string, 0, len(e.SignalActions))
+	for sig := range e.SignalActions {
+		sigs = append(sigs, sig)
+	}
+	sort.Strings(sigs)
+	for _, sig := range sigs {
+		v.signal("signal_actions."+sig, sig)
+		switch action := e.SignalActions[sig]; action {
+		case SignalQuit, SignalReload, SignalNotify, SignalIgnore, SignalUpgrade:
+		default:
+			v.add("signal_actions."+sig, fmt.Sprintf("unknown action %q", action))
+		}
+	}
+
+	e.validateAccounts(v, checkExist && !provisioning)
+	e.validateDependencies(v, name)
+	e.validateSettings(v)
+
+	if len(v.problems) > 0 {
+		return &ValidationError{Service: name, Problems: v.problems}
+	}
+	return nil
+}
+
+func (e *Config) validateAccounts(v *validator, checkExist bool) {
+	if e.User != "" {
+		if !accountNameRe.MatchString(e.User) {
+			v.add("user", fmt.Sprintf("%q is not a valid user name", e.User))
+		} else if checkExist && !userExists(e.User) {
+			v.add("user", fmt.Sprintf("user %q does not exist", e.User))
+		}
+	}
+	if e.Group != "" {
+		if !accountNameRe.MatchString(e.Group) {
+			v.add("group", fmt.Sprintf("%q is not a valid group name", e.Group))
+		} else if checkExist && !groupExists(e.Group) {
+			v.add("group", fmt.Sprintf("group %q does not exist", e.Group))
+		}
+	}
+	if e.RunAs != "" && e.User != "" && e.RunAs != e.User {
+		v.add("run_as", fmt.Sprintf("conflicts with user: %q != %q", e.RunAs, e.User))
+	}
+	if e.UserLevel && (e.User != "" || e.Group != "") {
+		v.add("user_level", "a user-level service runs as the invoking user, user and group must be empty")
+	}
+}
+
+func (e *Config) validateDependencies(v *validator, name string) {
+	strong := make(map[string]bool)
+	for i, dep := range e.Dependencies {
+		field := fmt.Sprintf("dependencies[%d]", i)
+		v.dependency(field, dep, name)
+		if strong[unitName(dep)] {
+			v.add(field, fmt.Sprintf("%q is duplicated", dep))
+		}
+		strong[unitName(dep)] = true
+	}
+	weak := make(map[string]bool)
+	for i, dep := range e.WeakDependencies {
+		field := fmt.Sprintf("weak_dependencies[%d]", i)
+		v.dependency(field, dep, name)
+		if strong[unitName(dep)] {
+			v.add(field, fmt.Sprintf("%q is in dependencies too", dep))
+		} else if weak[unitName(dep)] {
+			v.add(field, fmt.Sprintf("%q is duplicated", dep))
+		}
+		weak[unitName(dep)] = true
+	}
+}
+
+func (e *Config) validateSettings(v *validator) {
+	switch e.Type {
+	case "", "simple", "forking", "exec", "oneshot", "dbus", "notify", "notify-reload", "idle":
+	default:
+		v.add("type", fmt.Sprintf("unknown service type %q", e.Type))
+	}
+	switch e.InitMode {
+	case InitModeAuto, InitModeOff, InitModeFork, InitModeInProcess:
+	default:
+		v.add("init_mode", fmt.Sprintf("unknown init mode %q", e.InitMode))
+	}
+	if e.EnvApply != 0 && e.EnvApply != HotReload && e.EnvApply != Restart {
+		v.add("env_apply", fmt.Sprintf("expect HotReload or Restart, got %v", e.EnvApply))
+	}
+
+	if sv := e.Supervisor; sv != nil {
+		switch sv.Policy {
+		case "", RestartNever, RestartOnFailure, RestartAlways:
+		default:
+			v.add("supervisor.policy", fmt.Sprintf("unknown restart policy %q", sv.Policy))
+		}
+		if sv.InitialBackoff < 0 || sv.MaxBackoff < 0 || sv.StartLimitInterval < 0 || sv.ResetAfter < 0 {
+			v.add("supervisor", "the durations must not be negative")
+		}
+		if sv.MaxBackoff > 0 && sv.InitialBackoff > sv.MaxBackoff {
+			v.add("supervisor.initial_backoff", fmt.Sprintf("%v is greater than max_backoff %v", sv.InitialBackoff, sv.MaxBackoff))
+		}
+		if sv.Jitter < 0 || sv.Jitter >= 1 {
+			v.add("supervisor.jitter", fmt.Sprintf("%v is not in [0, 1)", sv.Jitter))
+		}
+	}
+
+	if h := e.Health; h != nil {
+		v.timespan("health.watchdog_sec", h.WatchdogSec)
+		if h.Interval < 0 || h.Timeout < 0 || h.FailureThreshold < 0 {
+			v.add("health", "the durations and failure_threshold must not be negative")
+		}
+		if h.RestartOnFailure && len(h.Liveness) == 0 {
+			v.add("health.restart_on_failure", "needs at least one liveness probe")
+		}
+		for _, kind := range []ProbeKind{Liveness, Readiness} {
+			probes := h.Liveness
+			if kind == Readiness {
+				probes = h.Readiness
+			}
+			for i, p := range probes {
+				n := 0
+				for _, set := range []bool{p.HTTP != "", p.TCP != "", len(p.Exec) > 0} {
+					if set {
+						n++
+					}
+				}
+				if n != 1 {
+					v.add(fmt.Sprintf("health.%s[%d]", kind, i), "expect exactly one of http, tcp and exec")
+				}
+			}
+		}
+	}
+}
+
+type validator struct {
+	problems []FieldError
+}
+
+func (v *validator) add(field, problem string) {
+	v.problems = append(v.problems, FieldError{field, problem})
+}
+
+// absPath reports a relative path, it returns false if reported.
+func (v *validator) absPath(field, value string) bool {
+	if value != "" && !path.IsAbs(value) && !(runtime.GOOS == "windows" && len(value) > 2 && value[1] == ':') {
+		v.add(field, fmt.Sprintf("%q is not an absolute path", value))
+		return false
+	}
+	return true
+}
+
+func (v *validator) timespan(field, value string) {
+	if value == "" {
+		return
+	}
+	if _, err := parseTimespanE(value); err != nil {
+		v.add(field, err.Error())
+	}
+}
+
+func (v *validator) signal(field, value string) {
+	if value == "" {
+		return
+	}
+	if _, err := parseSignal(value); err != nil {
+		v.add(field, err.Error())
+	}
+}
+
+func (v *validator) dependency(field, dep, self string) {
+	switch {
+	case dep == "":
+		v.add(field, "is empty")
+	case !systemdNameRe.MatchString(dep):
+		v.add(field, fmt.Sprintf("%q is not a valid unit name", dep))
+	case unitName(dep) == unitName(self):
+		v.add(field, "the service depends on itself")
+	}
+}
+
+var (
+	// systemdNameRe matches the unit names, see systemd.unit(5).
+	systemdNameRe = regexp.MustCompile(`^[A-Za-z0-9:_.\\-]+(@[A-Za-z0-9:_.\\-]*)?$`)
+	// launchdLabelRe matches the labels, reverse-DNS style.
+	launchdLabelRe = regexp.MustCompile(`^[A-Za-z0-9._-]+$`)
+	// accountNameRe matches the portable user and group names.
+	accountNameRe = regexp.MustCompile(`^[a-z_][a-z0-9_-]*\$?$`)
+)
+
+// serviceNameProblem checks name against the naming rules of the
+// service manager backend, and returns the problem or "".
+func serviceNameProblem(backend, name string) string {
+	switch backend {
+	case "systemd":
+		if len(name) > 255-len(".service") {
+			return "is too long for a systemd unit name"
+		}
+		if !systemdNameRe.MatchString(name) {
+			return fmt.Sprintf("%q has characters not allowed in a systemd unit name, use [A-Za-z0-9:_.-]", name)
+		}
+	case "ntservice":
+		if len(name) > 256 {
+			return "is too long for a windows service name"
+		}
+		if strings.ContainsAny(name, `/\`) {
+			return fmt.Sprintf("%q must not contain '/' or '\\'", name)
+		}
+	default: // launchd, the daemon, rc.d and the init scripts
+		if !launchdLabelRe.MatchString(name) {
+			return fmt.Sprintf("%q has characters not allowed in a service name, use [A-Za-z0-9._-]", name)
+		}
+	}
+	return ""
+}
+
+// privilegedCommands are validated before run, see Config.Validate.
+var privilegedCommands = map[Command]bool{
+	Install: true, Uninstall: true, Enable: true, Disable: true,
+	Start: true, Stop: true, Restart: true, HotReload: true,
+	Restore: true, EnvSet: true, EnvUnset: true,
+}
@@ -0,0 +1,78 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestConfigValidate(t *testing.T) {
+	ctx := context.Background()
+	exe, err := os.Executable()
+	if err != nil {
+		t.Fatal(err)
+	}
+	good := &Config{Name: "demo", Executable: exe, WorkDir: t.TempDir(), TimeoutStopSec: "1min 30s", Dependencies: []string{"db"}}
+	if err = good.Validate(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	bad := &Config{
+		Name:             "demo",
+		Executable:       path.Join(t.TempDir(), "nope"),
+		WorkDir:          "relative/dir",
+		TimeoutStartSec:  "soon",
+		StopSignal:       "STOPIT",
+		User:             "no-such-user-demo",
+		Dependencies:     []string{"db", "demo.service"},
+		WeakDependencies: []string{"db"},
+		Supervisor:       &SupervisorConfig{InitialBackoff: time.Minute, MaxBackoff: time.Second},
+		Health:           &HealthConfig{Liveness: []Probe{{HTTP: "http://localhost", TCP: "localhost:80"}}},
+	}
+	err = bad.Validate(ctx)
+	var ve *ValidationError
+	if !errors.As(err, &ve) {
+		t.Fatalf("expect a ValidationError, got %v", err)
+	}
+	var fields []string
+	for _, p := range ve.Problems {
+		fields = append(fields, p.Field)
+	}
+	want := "executable work_dir timeout_start_sec stop_signal user dependencies[1] weak_dependencies[0] supervisor.initial_backoff health.liveness[0]"
+	if got := strings.Join(fields, " "); got != want {
+		t.Fatalf("expect problems of\n  %s\ngot\n  %s\n%v", want, got, err)
+	}
+
+	// the missing user is provisioned by Install, and nothing needs to
+	// exist for Uninstall
+	if err = bad.validate(ctx, Install, "systemd"); !strings.Contains(err.Error(), "executable:") || strings.Contains(err.Error(), "user:") {
+		t.Fatalf("bad problems for Install: %v", err)
+	}
+	if err = bad.validate(ctx, Uninstall, "systemd"); strings.Contains(err.Error(), "executable:") || strings.Contains(err.Error(), "user:") {
+		t.Fatalf("bad problems for Uninstall: %v", err)
+	}
+}
+
+func TestServiceNameProblem(t *testing.T) {
+	for _, c := range []struct {
+		backend, name string
+		ok            bool
+	}{
+		{"systemd", "my-app@1", true},
+		{"systemd", "my app", false},
+		{"launchd", "com.example.app", true},
+		{"launchd", "app:1", false},
+		{"ntservice", "My App", true},
+		{"ntservice", `a\b`, false},
+		{"daemon", "my-app@1", false},
+		{"sysvinit", "my_app", true},
+	} {
+		if p := serviceNameProblem(c.backend, c.name); (p == "") != c.ok {
+			t.Errorf("%s %q: %q", c.backend, c.name, p)
+		}
+	}
+}